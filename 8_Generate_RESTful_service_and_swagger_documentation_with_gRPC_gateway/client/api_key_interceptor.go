@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// apiKeyHeader must match service.APIKeyHeader
+const apiKeyHeader = "x-api-key"
+
+// APIKeyInterceptor is a client interceptor that authenticates with an API key instead of a username and password
+type APIKeyInterceptor struct {
+	apiKey      string
+	authMethods map[string]bool
+}
+
+// NewAPIKeyInterceptor returns a new API key interceptor
+func NewAPIKeyInterceptor(apiKey string, authMethods map[string]bool) *APIKeyInterceptor {
+	return &APIKeyInterceptor{
+		apiKey:      apiKey,
+		authMethods: authMethods,
+	}
+}
+
+// Unary returns a client interceptor to authenticate unary RPC
+func (interceptor *APIKeyInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		if interceptor.authMethods[method] {
+			ctx = interceptor.attachAPIKey(ctx)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Stream returns a client interceptor to authenticate stream RPC
+func (interceptor *APIKeyInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		if interceptor.authMethods[method] {
+			ctx = interceptor.attachAPIKey(ctx)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func (interceptor *APIKeyInterceptor) attachAPIKey(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, apiKeyHeader, interceptor.apiKey)
+}
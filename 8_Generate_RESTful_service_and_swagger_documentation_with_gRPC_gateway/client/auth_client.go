@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"pcbook/pb"
 	"time"
 )
@@ -37,3 +38,13 @@ func (client *AuthClient) Login() (string, error) {
 
 	return res.GetAccessToken(), nil
 }
+
+// Logout revokes the given access token on the server
+func (client *AuthClient) Logout(accessToken string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", accessToken)
+	_, err := client.service.Logout(ctx, &pb.LogoutRequest{})
+	return err
+}
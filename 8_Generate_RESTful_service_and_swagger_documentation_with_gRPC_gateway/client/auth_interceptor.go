@@ -3,35 +3,27 @@ package client
 import (
 	"context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
-	"log"
-	"time"
+	"google.golang.org/grpc/status"
+	"pcbook/logging"
 )
 
 // AuthInterceptor is a client interceptor for authentication
 type AuthInterceptor struct {
-	authClient *AuthClient
+	tokenSource *TokenSource
 	authMethods map[string]bool
-	accessToken string
 }
 
-// 启动一个单独的goroutine，定期调用登陆API以获取新的访问令牌
-// NewAuthInterceptor returns a new auth interceptor
+// NewAuthInterceptor returns a new auth interceptor that attaches the tokens of the token source
 func NewAuthInterceptor(
-	authClient *AuthClient,
+	tokenSource *TokenSource,
 	authMethods map[string]bool,
-	refreshDuration time.Duration, // 用于刷新令牌持续时间（多久调用一次登陆API来获取一个新令牌）
-) (*AuthInterceptor, error) {
-	interceptor := &AuthInterceptor{
-		authClient:  authClient,
+) *AuthInterceptor {
+	return &AuthInterceptor{
+		tokenSource: tokenSource,
 		authMethods: authMethods,
 	}
-
-	err := interceptor.scheduleRefreshToken(refreshDuration)
-	if err != nil {
-		return nil, err
-	}
-	return interceptor, nil
 }
 
 // 添加拦截器以将令牌附加到请求上下文中
@@ -45,21 +37,25 @@ func (interceptor *AuthInterceptor) Unary() grpc.UnaryClientInterceptor {
 		invoker grpc.UnaryInvoker,
 		opts ...grpc.CallOption,
 	) error {
-		log.Printf("--> unary interceptor: %s", method)
+		logging.Default().Debug("calling unary RPC", "method", method)
 
-		if interceptor.authMethods[method] {
-			return invoker(interceptor.attachToken(ctx), method, req, reply, cc, opts...)
+		if !interceptor.authMethods[method] {
+			return invoker(ctx, method, req, reply, cc, opts...)
 		}
 
-		return invoker(ctx, method, req, reply, cc, opts...)
-	}
-}
+		accessToken := interceptor.tokenSource.Token()
+		err := invoker(attachToken(ctx, accessToken), method, req, reply, cc, opts...)
+		if !interceptor.refreshRejected(accessToken, err) {
+			return err
+		}
 
-func (interceptor *AuthInterceptor) attachToken(ctx context.Context) context.Context {
-	return metadata.AppendToOutgoingContext(ctx, "authorization", interceptor.accessToken)
+		// 令牌被拒绝（如已过期或被吊销），刷新后重试一次
+		return invoker(attachToken(ctx, interceptor.tokenSource.Token()), method, req, reply, cc, opts...)
+	}
 }
 
-// Stream returns a client interceptor to authencicate stream RPC
+// Stream returns a client interceptor to authencicate stream RPC.
+// Only the errors of opening the stream are retried, the stream cannot be replayed after messages are sent.
 func (interceptor *AuthInterceptor) Stream() grpc.StreamClientInterceptor {
 	return func(
 		ctx context.Context,
@@ -69,46 +65,36 @@ func (interceptor *AuthInterceptor) Stream() grpc.StreamClientInterceptor {
 		streamer grpc.Streamer,
 		opts ...grpc.CallOption,
 	) (grpc.ClientStream, error) {
-		log.Printf("--> stream interceptor: %s", method)
+		logging.Default().Debug("calling stream RPC", "method", method)
 
-		if interceptor.authMethods[method] {
-			return streamer(interceptor.attachToken(ctx), desc, cc, method, opts...)
+		if !interceptor.authMethods[method] {
+			return streamer(ctx, desc, cc, method, opts...)
 		}
 
-		return streamer(ctx, desc, cc, method, opts...)
-	}
-}
-
-func (interceptor *AuthInterceptor) scheduleRefreshToken(refreshDuration time.Duration) error {
-	err := interceptor.refreshToken()
-	if err != nil {
-		return err
-	}
-
-	go func() {
-		wait := refreshDuration // 使用一个等待变量来存储，刷新令牌之前，我们需要等待多久时间
-		for {
-			time.Sleep(wait)
-			err = interceptor.refreshToken()
-			if err != nil { // 如果发生错误，我们应该只等一小段时间，假设是1秒，然后重试
-				wait = time.Second
-			} else { // 如果没有错误，那么我们绝对应该等待刷新持续时间
-				wait = refreshDuration
-			}
+		accessToken := interceptor.tokenSource.Token()
+		stream, err := streamer(attachToken(ctx, accessToken), desc, cc, method, opts...)
+		if !interceptor.refreshRejected(accessToken, err) {
+			return stream, err
 		}
-	}()
 
-	return nil
+		return streamer(attachToken(ctx, interceptor.tokenSource.Token()), desc, cc, method, opts...)
+	}
 }
 
-func (interceptor *AuthInterceptor) refreshToken() error {
-	accessToken, err := interceptor.authClient.Login()
-	if err != nil {
-		return err
+// refreshRejected refreshes the token if the server rejected it, and reports if the RPC should be retried
+func (interceptor *AuthInterceptor) refreshRejected(accessToken string, err error) bool {
+	if status.Code(err) != codes.Unauthenticated {
+		return false
 	}
 
-	interceptor.accessToken = accessToken
-	log.Printf("token refreshed: %v", accessToken)
+	refreshErr := interceptor.tokenSource.RefreshRejected(accessToken)
+	if refreshErr != nil {
+		logging.Default().Warn("cannot refresh rejected token", "error", refreshErr)
+		return false
+	}
+	return true
+}
 
-	return nil
-}
\ No newline at end of file
+func attachToken(ctx context.Context, accessToken string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", accessToken)
+}
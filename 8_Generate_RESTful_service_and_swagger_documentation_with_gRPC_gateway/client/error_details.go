@@ -0,0 +1,75 @@
+package client
+
+import (
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"pcbook/pb"
+	"time"
+)
+
+// ErrorDetails are the google.rpc details of an error returned by the server
+type ErrorDetails struct {
+	Code    codes.Code
+	Message string
+	// Reason is the reason of the ErrorInfo detail, ERROR_REASON_UNSPECIFIED without it
+	Reason   pb.ErrorReason
+	Metadata map[string]string
+	// FieldViolations are the invalid fields of the request, from the BadRequest detail
+	FieldViolations []*errdetails.BadRequest_FieldViolation
+	// Resource is the resource that is missing, already exists or cannot be accessed, from the ResourceInfo detail
+	Resource *errdetails.ResourceInfo
+	// RetryDelay is how long to wait before retrying a throttled request, from the RetryInfo detail
+	RetryDelay time.Duration
+}
+
+// ParseErrorDetails returns the details of the error, or nil if err is nil.
+// An error that is not a gRPC status has the Unknown code and no details.
+func ParseErrorDetails(err error) *ErrorDetails {
+	if err == nil {
+		return nil
+	}
+
+	st := status.Convert(err)
+	details := &ErrorDetails{
+		Code:    st.Code(),
+		Message: st.Message(),
+	}
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			// 未知的原因保持为ERROR_REASON_UNSPECIFIED，旧的客户端也能处理新的原因
+			details.Reason = pb.ErrorReason(pb.ErrorReason_value[detail.GetReason()])
+			details.Metadata = detail.GetMetadata()
+		case *errdetails.BadRequest:
+			details.FieldViolations = append(details.FieldViolations, detail.GetFieldViolations()...)
+		case *errdetails.ResourceInfo:
+			details.Resource = detail
+		case *errdetails.RetryInfo:
+			delay, err := ptypes.Duration(detail.GetRetryDelay())
+			if err == nil {
+				details.RetryDelay = delay
+			}
+		}
+	}
+	return details
+}
+
+// ErrorReason returns the reason of the error, or ERROR_REASON_UNSPECIFIED if it has none
+func ErrorReason(err error) pb.ErrorReason {
+	details := ParseErrorDetails(err)
+	if details == nil {
+		return pb.ErrorReason_ERROR_REASON_UNSPECIFIED
+	}
+	return details.Reason
+}
+
+// RetryDelay returns how long to wait before retrying a throttled request, it returns false if the error has no RetryInfo
+func RetryDelay(err error) (time.Duration, bool) {
+	details := ParseErrorDetails(err)
+	if details == nil || details.RetryDelay <= 0 {
+		return 0, false
+	}
+	return details.RetryDelay, true
+}
@@ -5,12 +5,10 @@ import (
 	"context"
 	"fmt"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
+	"pcbook/logging"
 	"pcbook/pb"
 	"time"
 )
@@ -40,21 +38,55 @@ func (laptopClient *LaptopClient) CreateLaptop(laptop *pb.Laptop) {
 	// res, err := laptopClient.CreateLaptop(context.Background(), req)
 	res, err := laptopClient.service.CreateLaptop(ctx, req)
 	if err != nil {
-		st, ok := status.FromError(err)
-		if ok && st.Code() == codes.AlreadyExists {
+		details := ParseErrorDetails(err)
+		switch details.Reason {
+		case pb.ErrorReason_LAPTOP_ALREADY_EXISTS:
 			// not a big deal
-			log.Println("laptop already exists")
-		} else {
-			log.Fatal("cannot create laptop: ", err)
+			logging.Default().Info("laptop already exists", "laptop_id", laptop.GetId())
+		case pb.ErrorReason_INVALID_LAPTOP_ID:
+			logging.Default().Fatal("cannot create laptop", "reason", details.Reason, "field_violations", details.FieldViolations)
+		default:
+			logging.Default().Fatal("cannot create laptop", "error", err)
 		}
 		return // 在任何错误情况器，我们都必须返回此处
 	}
-	log.Printf("create laptop with id: %s", res.Id)
+	logging.Default().Info("created laptop", "laptop_id", res.GetId())
+}
+
+// UpdateLaptop calls update laptop RPC
+func (laptopClient *LaptopClient) UpdateLaptop(laptop *pb.Laptop) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.UpdateLaptopRequest{Laptop: laptop}
+	_, err := laptopClient.service.UpdateLaptop(ctx, req)
+	if err != nil {
+		return fmt.Errorf("cannot update laptop: %v", err)
+	}
+
+	logging.Default().Info("updated laptop", "laptop_id", laptop.GetId())
+	return nil
+}
+
+// DeleteLaptop calls delete laptop RPC
+func (laptopClient *LaptopClient) DeleteLaptop(laptopID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.DeleteLaptopRequest{Id: laptopID}
+	_, err := laptopClient.service.DeleteLaptop(ctx, req)
+	if err != nil {
+		return fmt.Errorf("cannot delete laptop: %v", err)
+	}
+
+	logging.Default().Info("deleted laptop", "laptop_id", laptopID)
+	return nil
 }
 
 // SearchLaptop calls search laptop RPC
 func  (laptopClient *LaptopClient) SearchLaptop(filter *pb.Filter) {
-	log.Print("search filter: ", filter)
+	logger := logging.Default()
+	logger.Info("search laptops", "filter", filter)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -62,7 +94,7 @@ func  (laptopClient *LaptopClient) SearchLaptop(filter *pb.Filter) {
 	req := &pb.SearchLaptopRequest{Filter: filter}
 	stream, err := laptopClient.service.SearchLaptop(ctx, req)
 	if err != nil {
-		log.Fatal("cannot search laptop: ", err)
+		logger.Fatal("cannot search laptop", "error", err)
 	}
 
 	for {
@@ -72,25 +104,29 @@ func  (laptopClient *LaptopClient) SearchLaptop(filter *pb.Filter) {
 			return
 		}
 		if err != nil {
-			log.Fatal("cannot receive response: ", err)
+			logger.Fatal("cannot receive response", "error", err)
 		}
 
 		laptop := res.GetLaptop()
-		log.Print("- found: ", laptop.GetId())
-		log.Print("  + brand: ", laptop.GetBrand())
-		log.Print("  + name: ", laptop.GetName())
-		log.Print("  + cpu cores: ", laptop.GetCpu().GetNumberCores())
-		log.Print("  + cpu min ghz: ", laptop.GetCpu().GetMinGhz())
-		log.Print("  + ram: ", laptop.GetRam().GetValue(), laptop.GetRam().GetUnit())
-		log.Print("  + price: ", laptop.GetPriceUsd(), "usd")
+		logger.Info(
+			"found laptop",
+			"laptop_id", laptop.GetId(),
+			"brand", laptop.GetBrand(),
+			"name", laptop.GetName(),
+			"cpu_cores", laptop.GetCpu().GetNumberCores(),
+			"cpu_min_ghz", laptop.GetCpu().GetMinGhz(),
+			"ram", fmt.Sprintf("%d %s", laptop.GetRam().GetValue(), laptop.GetRam().GetUnit()),
+			"price_usd", laptop.GetPriceUsd(),
+		)
 	}
 }
 
 // UploadImage calls upload laptop RPC
 func  (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string) {
+	logger := logging.Default()
 	file, err := os.Open(imagePath)
 	if err != nil {
-		log.Fatal("cannot open image file: ", err)
+		logger.Fatal("cannot open image file", "error", err)
 	}
 	defer file.Close()
 
@@ -99,7 +135,7 @@ func  (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string
 
 	stream, err := laptopClient.service.UploadImage(ctx)
 	if err != nil {
-		log.Fatal("cannot upload image: ", err)
+		logger.Fatal("cannot upload image", "error", err)
 	}
 
 	req := &pb.UploadImageRequest{
@@ -113,7 +149,7 @@ func  (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string
 
 	err = stream.Send(req)
 	if err != nil {
-		log.Fatal("cannot send image info: ", err)
+		logger.Fatal("cannot send image info", "error", err)
 	}
 
 	reader := bufio.NewReader(file)
@@ -124,7 +160,7 @@ func  (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string
 			break
 		}
 		if err != nil {
-			log.Fatal("cannot read chunk to buffer: ", err)
+			logger.Fatal("cannot read chunk to buffer", "error", err)
 		}
 
 		req := &pb.UploadImageRequest{
@@ -135,16 +171,16 @@ func  (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string
 
 		err = stream.Send(req)
 		if err != nil {
-			log.Fatal("cannot send chunk to server: ", err)
+			logger.Fatal("cannot send chunk to server", "error", err)
 		}
 	}
 
 	res, err := stream.CloseAndRecv()
 	if err != nil {
-		log.Fatal("cannot receive response: ", err)
+		logger.Fatal("cannot receive response", "error", err)
 	}
 
-	log.Printf("image upload with id: %s, size: %d", res.GetId(), res.GetSize())
+	logger.Info("uploaded image", "image_id", res.GetId(), "size", res.GetSize())
 }
 
 // RateLaptop calls rate laptop RPC
@@ -165,7 +201,7 @@ func  (laptopClient *LaptopClient) RateLaptop(laptopIDs []string, scores []float
 		for {
 			res, err := stream.Recv()
 			if err == io.EOF {
-				log.Print("no more responses")
+				logging.Default().Debug("no more responses")
 				waitResponse <- nil
 				return
 			}
@@ -174,7 +210,7 @@ func  (laptopClient *LaptopClient) RateLaptop(laptopIDs []string, scores []float
 				return
 			}
 
-			log.Print("received response: ", res)
+			logging.Default().Info("received rating", "laptop_id", res.GetLaptopId(), "rated_count", res.GetRatedCount(), "average_score", res.GetAverageScore())
 		}
 	}()
 
@@ -192,7 +228,7 @@ func  (laptopClient *LaptopClient) RateLaptop(laptopIDs []string, scores []float
 			return fmt.Errorf("cannot send stream request: %v - %v", err, stream.RecvMsg(nil))
 		}
 
-		log.Print("send request: ", req)
+		logging.Default().Info("sent rating", "laptop_id", req.GetLaptopId(), "score", req.GetScore())
 	}
 	err = stream.CloseSend()
 	if err != nil {
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"pcbook/logging"
+)
+
+// RequestIDHeader is the metadata key of the request IDs, the server logs it in every line of the RPC
+const RequestIDHeader = "x-request-id"
+
+// RequestIDInterceptor is a client interceptor that sends a new request ID with each RPC,
+// and logs it when the RPC fails, so that the error can be found in the server logs
+type RequestIDInterceptor struct{}
+
+// NewRequestIDInterceptor returns a new request ID interceptor
+func NewRequestIDInterceptor() *RequestIDInterceptor {
+	return &RequestIDInterceptor{}
+}
+
+// Unary returns a client interceptor to identify unary RPC
+func (interceptor *RequestIDInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, id := attachRequestID(ctx)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err != nil {
+			logFailedRPC(method, id, err)
+		}
+		return err
+	}
+}
+
+// Stream returns a client interceptor to identify stream RPC, only the errors of opening the stream are logged
+func (interceptor *RequestIDInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, id := attachRequestID(ctx)
+		logging.Default().Debug("opening stream", "method", method, "request_id", id)
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			logFailedRPC(method, id, err)
+		}
+		return stream, err
+	}
+}
+
+// attachRequestID sends a new request ID, unless the caller has set one
+func attachRequestID(ctx context.Context) (context.Context, string) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if values := md.Get(RequestIDHeader); len(values) > 0 {
+		return ctx, values[0]
+	}
+
+	id := uuid.New().String()
+	return metadata.AppendToOutgoingContext(ctx, RequestIDHeader, id), id
+}
+
+func logFailedRPC(method string, id string, err error) {
+	logging.Default().Warn("RPC failed", "method", method, "request_id", id, "code", status.Code(err), "error", status.Convert(err).Message())
+}
@@ -0,0 +1,220 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"pcbook/logging"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 刷新令牌的时间安排
+const (
+	refreshRatio    = 0.75             // 在令牌有效期过去75%时刷新
+	refreshJitter   = 0.1              // 再随机提前最多10%的有效期，避免大量客户端同时登录
+	defaultLifetime = 30 * time.Second // 无法从令牌中读取有效期时使用
+	minRetryDelay   = time.Second
+	maxRetryDelay   = time.Minute
+)
+
+// TokenSource logs in to get access tokens and refreshes them before they expire, it is safe for concurrent use
+type TokenSource struct {
+	authClient *AuthClient
+	clock      clock
+
+	mutex       sync.RWMutex
+	accessToken string
+	receivedAt  time.Time     // 客户端收到令牌的时间，按本地时钟计算，不受服务端时钟偏差影响
+	lifetime    time.Duration // 令牌的有效期
+
+	refreshMutex sync.Mutex // 同一时间只有一个登录请求
+	done         chan struct{}
+	stopped      chan struct{}
+	closeOnce    sync.Once
+}
+
+// NewTokenSource logs in and starts refreshing the token in the background until Close is called
+func NewTokenSource(authClient *AuthClient) (*TokenSource, error) {
+	return newTokenSource(authClient, realClock{})
+}
+
+func newTokenSource(authClient *AuthClient, clock clock) (*TokenSource, error) {
+	source := &TokenSource{
+		authClient: authClient,
+		clock:      clock,
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	err := source.Refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	go source.run()
+	return source, nil
+}
+
+// Token returns the current access token
+func (source *TokenSource) Token() string {
+	source.mutex.RLock()
+	defer source.mutex.RUnlock()
+
+	return source.accessToken
+}
+
+// Refresh logs in again to get a new access token
+func (source *TokenSource) Refresh() error {
+	source.refreshMutex.Lock()
+	defer source.refreshMutex.Unlock()
+
+	return source.refresh()
+}
+
+// RefreshRejected refreshes the token after the server rejected it,
+// unless another goroutine has already replaced the rejected token
+func (source *TokenSource) RefreshRejected(rejectedToken string) error {
+	source.refreshMutex.Lock()
+	defer source.refreshMutex.Unlock()
+
+	if source.Token() != rejectedToken {
+		return nil
+	}
+	return source.refresh()
+}
+
+// Close stops refreshing the token and waits for the background goroutine to exit
+func (source *TokenSource) Close() {
+	source.closeOnce.Do(func() {
+		close(source.done)
+	})
+	<-source.stopped
+}
+
+func (source *TokenSource) refresh() error {
+	accessToken, err := source.authClient.Login()
+	if err != nil {
+		return err
+	}
+
+	receivedAt := source.clock.Now()
+	lifetime, err := tokenLifetime(accessToken)
+	if err != nil {
+		// 令牌对客户端来说是不透明的，读取失败时按默认有效期刷新
+		lifetime = defaultLifetime
+	}
+
+	source.mutex.Lock()
+	source.accessToken = accessToken
+	source.receivedAt = receivedAt
+	source.lifetime = lifetime
+	source.mutex.Unlock()
+
+	logging.Default().Info("token refreshed", "expires_in", lifetime)
+	return nil
+}
+
+func (source *TokenSource) run() {
+	defer close(source.stopped)
+
+	wait := source.nextRefresh()
+	failures := 0
+	for {
+		if !source.clock.Wait(source.done, wait) {
+			return
+		}
+
+		err := source.Refresh()
+		if err != nil {
+			failures++
+			wait = retryDelay(failures)
+			logging.Default().Warn("cannot refresh token", "retry_in", wait.Round(time.Millisecond), "error", err)
+		} else {
+			failures = 0
+			wait = source.nextRefresh()
+		}
+	}
+}
+
+// nextRefresh returns how long to wait before refreshing the current token
+func (source *TokenSource) nextRefresh() time.Duration {
+	source.mutex.RLock()
+	receivedAt, lifetime := source.receivedAt, source.lifetime
+	source.mutex.RUnlock()
+
+	jitter := time.Duration(rand.Float64() * refreshJitter * float64(lifetime))
+	refreshAt := receivedAt.Add(time.Duration(refreshRatio*float64(lifetime)) - jitter)
+
+	wait := refreshAt.Sub(source.clock.Now())
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// retryDelay returns the capped exponential backoff after the given number of consecutive failures,
+// with half of it randomized
+func retryDelay(failures int) time.Duration {
+	delay := maxRetryDelay
+	if failures < 32 {
+		if backoff := minRetryDelay << uint(failures-1); backoff < maxRetryDelay {
+			delay = backoff
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// tokenLifetime reads the lifetime of a JWT from its iat and exp claims without verifying it
+func tokenLifetime(accessToken string) (time.Duration, error) {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("access token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("cannot decode token payload: %w", err)
+	}
+
+	var claims struct {
+		IssuedAt  int64 `json:"iat"`
+		ExpiresAt int64 `json:"exp"`
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse token claims: %w", err)
+	}
+	if claims.IssuedAt == 0 || claims.ExpiresAt <= claims.IssuedAt {
+		return 0, fmt.Errorf("token has no valid lifetime")
+	}
+
+	return time.Duration(claims.ExpiresAt-claims.IssuedAt) * time.Second, nil
+}
+
+// clock tells the time and waits, the tests replace it to refresh the tokens without sleeping
+type clock interface {
+	Now() time.Time
+	// Wait waits for the duration, it returns false if done is closed before
+	Wait(done <-chan struct{}, d time.Duration) bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Wait(done <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net"
+	"pcbook/pb"
+	"pcbook/service"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenSourceRefresh(t *testing.T) {
+	t.Parallel()
+
+	authServer := startTestAuthServer(t, time.Minute)
+	clock := newFakeClock()
+	source, err := newTokenSource(newTestAuthClient(t, authServer.address), clock)
+	require.NoError(t, err)
+
+	firstToken := source.Token()
+	require.NotEmpty(t, firstToken)
+
+	// 有效期为1分钟的令牌在其有效期的65%~75%时刷新
+	require.True(t, clock.WaitFor() >= 39*time.Second)
+	clock.Advance(38 * time.Second)
+	require.Equal(t, firstToken, source.Token())
+
+	// 后台刷新令牌的同时并发读取，在 -race 下检查数据竞争
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				require.NotEmpty(t, source.Token())
+			}
+		}()
+	}
+	clock.Advance(7 * time.Second)
+	wg.Wait()
+
+	// 下一次等待开始时，刷新已经完成
+	require.True(t, clock.WaitFor() >= 39*time.Second)
+	require.NotEqual(t, firstToken, source.Token())
+	require.EqualValues(t, 2, atomic.LoadInt32(&authServer.logins))
+
+	source.Close()
+	source.Close()
+	clock.Advance(time.Hour)
+	require.EqualValues(t, 2, atomic.LoadInt32(&authServer.logins))
+}
+
+func TestAuthInterceptorRetryUnauthenticated(t *testing.T) {
+	t.Parallel()
+
+	authServer := startTestAuthServer(t, time.Minute)
+	source, err := NewTokenSource(newTestAuthClient(t, authServer.address))
+	require.NoError(t, err)
+	defer source.Close()
+
+	const method = "/pcbook.pbfiles.LaptopService/CreateLaptop"
+	interceptor := NewAuthInterceptor(source, map[string]bool{method: true})
+	rejectedToken := source.Token()
+
+	var tokens []string
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		token := md.Get("authorization")[0]
+		tokens = append(tokens, token)
+		if token == rejectedToken {
+			return status.Error(codes.Unauthenticated, "access token has been revoked")
+		}
+		return nil
+	}
+
+	err = interceptor.Unary()(context.Background(), method, nil, nil, nil, invoker)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	require.NotEqual(t, rejectedToken, tokens[1])
+	require.EqualValues(t, 2, atomic.LoadInt32(&authServer.logins))
+
+	// 重试仍被拒绝时不会无限重试
+	rejectedToken = ""
+	tokens = nil
+	invoker2 := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		tokens = append(tokens, "")
+		return status.Error(codes.Unauthenticated, "access token is invalid")
+	}
+	err = interceptor.Unary()(context.Background(), method, nil, nil, nil, invoker2)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	require.Len(t, tokens, 2)
+}
+
+func TestRetryDelay(t *testing.T) {
+	t.Parallel()
+
+	for failures := 1; failures <= 100; failures++ {
+		delay := retryDelay(failures)
+		require.True(t, delay >= minRetryDelay/2)
+		require.True(t, delay <= maxRetryDelay)
+	}
+	require.True(t, retryDelay(100) >= maxRetryDelay/2)
+}
+
+// fakeClock is a clock that only moves when the test advances it
+type fakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	wakeAt  time.Time
+	wake    chan struct{} // 等待中的Wait的通知，没有等待时为nil
+	waiting chan time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:     time.Now(),
+		waiting: make(chan time.Duration, 1),
+	}
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return clock.now
+}
+
+func (clock *fakeClock) Wait(done <-chan struct{}, d time.Duration) bool {
+	wake := make(chan struct{})
+	clock.mutex.Lock()
+	clock.wakeAt = clock.now.Add(d)
+	clock.wake = wake
+	clock.mutex.Unlock()
+	clock.waiting <- d
+
+	select {
+	case <-done:
+		return false
+	case <-wake:
+		return true
+	}
+}
+
+// WaitFor waits until the token source waits for the clock, and returns the duration it waits for
+func (clock *fakeClock) WaitFor() time.Duration {
+	return <-clock.waiting
+}
+
+// Advance moves the clock forward, and wakes up the waiting token source if its time has come
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.now = clock.now.Add(d)
+	if clock.wake != nil && !clock.now.Before(clock.wakeAt) {
+		close(clock.wake)
+		clock.wake = nil
+	}
+}
+
+type testAuthServer struct {
+	*service.AuthServer
+	address string
+	logins  int32
+}
+
+func (server *testAuthServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	atomic.AddInt32(&server.logins, 1)
+	return server.AuthServer.Login(ctx, req)
+}
+
+func startTestAuthServer(t *testing.T, tokenDuration time.Duration) *testAuthServer {
+	// 使用最低的bcrypt成本，让登录足够快，不影响刷新的时间安排
+	passwordHasher := service.NewBcryptHasher(bcrypt.MinCost)
+	userStore := service.NewInMemoryUserStore()
+	user, err := service.NewUserWithHasher("admin", "secret", "admin", passwordHasher)
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(user))
+
+	authServer := &testAuthServer{
+		AuthServer: service.NewAuthServer(
+			userStore,
+			service.NewInMemorySessionStore(),
+			service.NewInMemoryAPIKeyStore(),
+			service.NewJWTManager("secret", tokenDuration),
+			service.NewLoginLimiter(3, time.Second, time.Minute, time.Minute),
+			nil,
+			passwordHasher,
+		),
+	}
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterAuthServiceServer(grpcServer, authServer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	authServer.address = listener.Addr().String()
+	return authServer
+}
+
+func newTestAuthClient(t *testing.T, serverAddress string) *AuthClient {
+	conn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return NewAuthClient(conn, "admin", "secret")
+}
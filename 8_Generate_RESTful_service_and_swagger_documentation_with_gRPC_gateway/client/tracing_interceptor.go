@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"pcbook/tracing"
+	"sync"
+)
+
+// TracingInterceptor is a client interceptor that starts a span for each RPC
+// and sends its traceparent in the metadata, so that the server spans join the same trace.
+// The REST gateway uses it too, its spans are the children of the HTTP request spans.
+type TracingInterceptor struct {
+	tracer *tracing.Tracer
+}
+
+// NewTracingInterceptor returns a new tracing interceptor, it traces nothing if the tracer is nil
+func NewTracingInterceptor(tracer *tracing.Tracer) *TracingInterceptor {
+	return &TracingInterceptor{
+		tracer: tracer,
+	}
+}
+
+// Unary returns a client interceptor to trace unary RPC
+func (interceptor *TracingInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, span := interceptor.start(ctx, method)
+		defer span.End()
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		span.SetRPCStatus(err)
+		return err
+	}
+}
+
+// Stream returns a client interceptor to trace stream RPC, the span ends when the stream returns its status
+func (interceptor *TracingInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, span := interceptor.start(ctx, method)
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			span.SetRPCStatus(err)
+			span.End()
+			return nil, err
+		}
+		return &tracingClientStream{ClientStream: stream, span: span, serverStreams: desc.ServerStreams}, nil
+	}
+}
+
+func (interceptor *TracingInterceptor) start(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	if interceptor.tracer == nil {
+		return ctx, nil
+	}
+
+	ctx, span := interceptor.tracer.Start(ctx, method, tracing.KindClient)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.method", method)
+
+	// 替换而不是追加，服务端只读取第一个traceparent
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(tracing.TraceparentHeader, span.SpanContext().Traceparent())
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// tracingClientStream ends the span of the stream when it receives the status of the RPC
+type tracingClientStream struct {
+	grpc.ClientStream
+	span          *tracing.Span
+	serverStreams bool
+	endOnce       sync.Once
+}
+
+func (stream *tracingClientStream) RecvMsg(m interface{}) error {
+	err := stream.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		stream.end(nil)
+	} else if err != nil {
+		stream.end(err)
+	} else if !stream.serverStreams {
+		// 客户端流式RPC只有一个响应，收到后RPC就结束了
+		stream.end(nil)
+	}
+	return err
+}
+
+func (stream *tracingClientStream) SendMsg(m interface{}) error {
+	err := stream.ClientStream.SendMsg(m)
+	if err != nil && err != io.EOF {
+		stream.end(err)
+	}
+	return err
+}
+
+func (stream *tracingClientStream) end(err error) {
+	stream.endOnce.Do(func() {
+		stream.span.SetRPCStatus(err)
+		stream.span.End()
+	})
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"pcbook/validate"
+)
+
+// ValidationInterceptor is a client interceptor that checks the request messages against the validation rules
+// of the proto files before sending them, with the same validators as the server.
+// The invalid requests fail with an InvalidArgument error and a BadRequest detail, without an ErrorInfo since they never reach the server.
+type ValidationInterceptor struct{}
+
+// NewValidationInterceptor returns a new validation interceptor
+func NewValidationInterceptor() *ValidationInterceptor {
+	return &ValidationInterceptor{}
+}
+
+// Unary returns a client interceptor to validate unary RPC
+func (interceptor *ValidationInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		err := validateRequest(req)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Stream returns a client interceptor to validate stream RPC, each message is checked before it is sent
+func (interceptor *ValidationInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &validationClientStream{ClientStream: stream}, nil
+	}
+}
+
+// validateRequest returns the error of an invalid request, the messages that are not protobuf are not checked
+func validateRequest(req interface{}) error {
+	message, ok := req.(protoreflect.ProtoMessage)
+	if !ok {
+		return nil
+	}
+	return validate.Validate(message)
+}
+
+// validationClientStream checks the messages before sending them
+type validationClientStream struct {
+	grpc.ClientStream
+}
+
+func (stream *validationClientStream) SendMsg(m interface{}) error {
+	err := validateRequest(m)
+	if err != nil {
+		return err
+	}
+	return stream.ClientStream.SendMsg(m)
+}
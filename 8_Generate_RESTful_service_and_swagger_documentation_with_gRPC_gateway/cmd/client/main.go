@@ -9,12 +9,13 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"io/ioutil"
-	"log"
 	"pcbook/client"
+	"pcbook/logging"
 	"pcbook/pb"
 	"pcbook/sample"
+	"pcbook/tracing"
+	"pcbook/validate"
 	"strings"
-	"time"
 )
 
 func testCreateLaptop(laptopClient *client.LaptopClient) {
@@ -71,22 +72,23 @@ func testRateLaptop(laptopClient *client.LaptopClient) {
 
 		err := laptopClient.RateLaptop(laptopIDs, scores)
 		if err != nil {
-			log.Fatal(err)
+			logging.Default().Fatal("cannot rate laptops", "error", err)
 		}
 	}
 }
 
 const (
-	username        = "admin"
-	password        = "123"
-	refreshDuration = 30 * time.Second
+	username = "admin"
+	password = "123"
 )
 
 func authMeehods() map[string]bool {
-	const laptopServicePath = " /pcbook.pbfiles.LaptopService/"
+	const laptopServicePath = "/pcbook.pbfiles.LaptopService/"
 
 	return map[string]bool{
 		laptopServicePath + "CreateLaptop": true,
+		laptopServicePath + "UpdateLaptop": true,
+		laptopServicePath + "DeleteLaptop": true,
 		laptopServicePath + "UploadImage":  true,
 		laptopServicePath + "RateLaptop":   true,
 	}
@@ -123,38 +125,98 @@ func loadTLSCredentials() (credentials.TransportCredentials, error) {
 func main() {
 	serverAddress := flag.String("address", "", "the server address")
 	enableTLS := flag.Bool("tls", false, "enable SSL/TLS")
+	apiKey := flag.String("api-key", "", "authenticate with the API key instead of username and password")
+	traceFile := flag.String("trace-file", "", "write the spans of the RPCs to the file, one JSON object per line")
+	logLevel := flag.String("log-level", "info", "the minimum level of the logs (debug/info/warn/error)")
 	flag.Parse()
-	log.Printf("dail server = %s, TLS = %t", *serverAddress, *enableTLS)
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		logging.Default().Fatal("invalid log level", "error", err)
+	}
+	logger := logging.Default()
+	logger.SetLevel(level)
+	logger.Info("dial server", "address", *serverAddress, "tls", *enableTLS)
 
 	transportOption := grpc.WithInsecure()
 
 	if *enableTLS {
 		tlsCredentials, err := loadTLSCredentials()
 		if err != nil {
-			log.Fatal("cannot load TLS credentials: ", err)
+			logger.Fatal("cannot load TLS credentials", "error", err)
 		}
 		transportOption = grpc.WithTransportCredentials(tlsCredentials)
 	}
 
-	cc1, err := grpc.Dial(*serverAddress, transportOption)
-	if err != nil {
-		log.Fatal("cannot dail server: ", err)
+	var interceptorOptions []grpc.DialOption
+	if *apiKey != "" {
+		// 使用API密钥时不需要登录
+		interceptor := client.NewAPIKeyInterceptor(*apiKey, authMeehods())
+		interceptorOptions = []grpc.DialOption{
+			grpc.WithUnaryInterceptor(interceptor.Unary()),
+			grpc.WithStreamInterceptor(interceptor.Stream()),
+		}
+	} else {
+		cc1, err := grpc.Dial(*serverAddress, transportOption)
+		if err != nil {
+			logger.Fatal("cannot dial server", "error", err)
+		}
+
+		authClient := client.NewAuthClient(cc1, username, password)
+		tokenSource, err := client.NewTokenSource(authClient)
+		if err != nil {
+			logger.Fatal("cannot login", "error", err)
+		}
+		defer tokenSource.Close()
+
+		interceptor := client.NewAuthInterceptor(tokenSource, authMeehods())
+		interceptorOptions = []grpc.DialOption{
+			grpc.WithUnaryInterceptor(interceptor.Unary()),
+			grpc.WithStreamInterceptor(interceptor.Stream()),
+		}
 	}
 
-	authClient := client.NewAuthClient(cc1, username, password)
-	interceptor, err := client.NewAuthInterceptor(authClient, authMeehods(), refreshDuration)
-	if err != nil {
-		log.Fatal("cannot create auth interceptor: ", err)
+	// 服务端的日志中记录同样的请求ID
+	requestIDInterceptor := client.NewRequestIDInterceptor()
+	interceptorOptions = append(
+		interceptorOptions,
+		grpc.WithChainUnaryInterceptor(requestIDInterceptor.Unary()),
+		grpc.WithChainStreamInterceptor(requestIDInterceptor.Stream()),
+	)
+
+	// 发送之前用与服务端相同的规则校验请求，无效的请求不会发送
+	if err := validate.CheckRules(); err != nil {
+		logger.Fatal("invalid validation rules", "error", err)
+	}
+	validationInterceptor := client.NewValidationInterceptor()
+	interceptorOptions = append(
+		interceptorOptions,
+		grpc.WithChainUnaryInterceptor(validationInterceptor.Unary()),
+		grpc.WithChainStreamInterceptor(validationInterceptor.Stream()),
+	)
+
+	if *traceFile != "" {
+		// 服务端的span与客户端的span属于同一个追踪
+		exporter, err := tracing.OpenFileExporter(*traceFile)
+		if err != nil {
+			logger.Fatal("cannot open trace file", "error", err)
+		}
+		defer exporter.Close()
+
+		interceptor := client.NewTracingInterceptor(tracing.NewTracer("pcbook-client", exporter))
+		interceptorOptions = append(
+			interceptorOptions,
+			grpc.WithChainUnaryInterceptor(interceptor.Unary()),
+			grpc.WithChainStreamInterceptor(interceptor.Stream()),
+		)
 	}
 
 	cc2, err := grpc.Dial(
 		*serverAddress,
-		transportOption,
-		grpc.WithUnaryInterceptor(interceptor.Unary()),
-		grpc.WithStreamInterceptor(interceptor.Stream()),
+		append([]grpc.DialOption{transportOption}, interceptorOptions...)...,
 	)
 	if err != nil {
-		log.Fatal("cannot dial server: ", err)
+		logger.Fatal("cannot dial server", "error", err)
 	}
 
 	laptopClient := client.NewLaptopClient(cc2)
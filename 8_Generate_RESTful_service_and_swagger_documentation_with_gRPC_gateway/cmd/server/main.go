@@ -4,193 +4,831 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/test/bufconn"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"pcbook/client"
+	"pcbook/config"
+	"pcbook/logging"
+	"pcbook/metrics"
 	"pcbook/pb"
 	"pcbook/service"
+	"pcbook/tracing"
+	"pcbook/validate"
+	"strings"
+	"syscall"
 	"time"
 )
 
-const (
-	secretKey     = "secret"
-	tokenDuration = 20 * time.Minute
-)
-
-const (
-	caCertFile = "cert/ca-cert.pem"
-	serverCertFile = "cert/server-cert.pem"
-	serverKeyFile = "cert/server-key.pem"
-)
-
-func seedUsers(userStore service.UserStore) error {
-	err := createUser(userStore, "admin", "123", "admin")
-	if err != nil {
-		return err
+// seedUsers creates the users of the configuration
+func seedUsers(userStore service.UserStore, passwordHasher service.PasswordHasher, users []config.UserConfig) error {
+	for _, user := range users {
+		err := createUser(userStore, passwordHasher, user.Username, user.Password, user.Role, user.TenantID)
+		if err != nil {
+			return fmt.Errorf("cannot create user %s: %w", user.Username, err)
+		}
 	}
-	return createUser(userStore, "pd", "123", "user")
+	return nil
 }
 
-func createUser(userStore service.UserStore, username, password, role string) error {
-	user, err := service.NewUser(username, password, role)
+func createUser(userStore service.UserStore, passwordHasher service.PasswordHasher, username, password, role, tenantID string) error {
+	user, err := service.NewUserWithHasher(username, password, role, passwordHasher)
 	if err != nil {
 		return err
 	}
+	user.TenantID = tenantID
 	return userStore.Save(user)
 }
 
-func accessibleRoles() map[string][]string {
-	const laptopServicePath = " /pcbook.pbfiles.LaptopService/"
-
-	return map[string][]string{
-		laptopServicePath + "CreateLaptop": {"admin"}, // 只有admin才可以调用
-		laptopServicePath + "UploadImage":  {"admin"},
-		laptopServicePath + "RateLaptop":   {"admin", "user"},
+// newPasswordHasher returns the hasher of the configuration, changing its parameters upgrades the old hashes on login
+func newPasswordHasher(passwordConfig config.PasswordConfig) (service.PasswordHasher, error) {
+	switch passwordConfig.Hash {
+	case "bcrypt":
+		return service.NewBcryptHasher(passwordConfig.BcryptCost), nil
+	case "argon2id":
+		params := passwordConfig.Argon2id
+		return service.NewArgon2idHasher(service.Argon2idParams{
+			Time:       params.Time,
+			Memory:     params.Memory,
+			Threads:    params.Threads,
+			SaltLength: params.SaltLength,
+			KeyLength:  params.KeyLength,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm: %s", passwordConfig.Hash)
 	}
 }
 
-func loadTLSCredentials() (credentials.TransportCredentials, error) {
-	// load certificate of the CA who signed client's certificate
-	pemClientCA, err := ioutil.ReadFile(caCertFile)
+func newJWTManager(authConfig config.AuthConfig) (*service.JWTManager, error) {
+	tokenDuration := time.Duration(authConfig.TokenDuration)
+	if authConfig.JWTKeys == "" {
+		return service.NewJWTManager(authConfig.SecretKey, tokenDuration), nil
+	}
+
+	// 旧密钥在被替换后，还需要保留一个令牌有效期，用于验证它签发的令牌
+	keySet, err := service.LoadKeySet(authConfig.JWTKeys, tokenDuration)
 	if err != nil {
 		return nil, err
 	}
+	logging.Default().Info("sign tokens with key", "key_id", keySet.SigningKey().ID, "alg", keySet.SigningKey().Method.Alg())
 
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(pemClientCA) {
-		return nil, errors.New("failed to add client CA's certificate")
+	return service.NewJWTManagerWithKeySet(keySet, tokenDuration), nil
+}
+
+func loadTLSCredentials(tlsConfig config.TLSConfig) (credentials.TransportCredentials, error) {
+	serverConfig, err := loadTLSConfig(tlsConfig)
+	if err != nil {
+		return nil, err
 	}
+	return credentials.NewTLS(serverConfig), nil
+}
 
+// loadTLSConfig loads the server certificate and the CA to verify client certificates
+func loadTLSConfig(tlsConfig config.TLSConfig) (*tls.Config, error) {
 	// load server's certificate and private key
-	serverCert, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
+	serverCert, err := tls.LoadX509KeyPair(tlsConfig.ServerCert, tlsConfig.ServerKey)
 	if err != nil {
 		return nil, err
 	}
 
 	// create the credentials and return it
-	config := &tls.Config{
+	serverConfig := &tls.Config{
 		Certificates: []tls.Certificate{serverCert}, // 服务端证书
-		// ClientAuth:   tls.RequireAndVerifyClientCert,
 		ClientAuth:   tls.NoClientCert,
-		ClientCAs: certPool,
+	}
+	if tlsConfig.ClientAuth == config.ClientAuthNone {
+		return serverConfig, nil
 	}
 
-	return credentials.NewTLS(config), nil
-}
+	// load certificate of the CA who signed client's certificate
+	pemClientCA, err := ioutil.ReadFile(tlsConfig.CACert)
+	if err != nil {
+		return nil, err
+	}
 
-func runGRPCServer(
-	authServer pb.AuthServiceServer,
-	laptopServer pb.LaptopServiceServer,
-	jwtManager *service.JWTManager,
-	enableTLS bool,
-	listener net.Listener,
-) error {
-	interceptor := service.NewAuthInterceptor(jwtManager, accessibleRoles())
-	serverOptions := []grpc.ServerOption{
-		grpc.UnaryInterceptor(interceptor.Unary()),
-		grpc.StreamInterceptor(interceptor.Stream()),
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(pemClientCA) {
+		return nil, errors.New("failed to add client CA's certificate")
 	}
+	serverConfig.ClientCAs = certPool
 
-	if enableTLS {
-		tlsCredentials, err := loadTLSCredentials()
+	// 默认客户端证书是可选的，提供时必须由ClientCAs签署，验证后可按访问策略映射为角色
+	serverConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if tlsConfig.ClientAuth == config.ClientAuthRequire {
+		serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return serverConfig, nil
+}
+
+// grpcServices are the services served by the gRPC server
+type grpcServices struct {
+	authServer           *service.AuthServer
+	laptopServer         pb.LaptopServiceServer
+	adminServer          pb.AdminServiceServer
+	jwtManager           *service.JWTManager
+	sessionStore         service.SessionStore
+	apiKeyStore          service.APIKeyStore
+	tracer               *tracing.Tracer
+	requestIDInterceptor *service.RequestIDInterceptor
+	tracingInterceptor   *service.TracingInterceptor
+	metricsInterceptor   *service.MetricsInterceptor
+	rateLimitInterceptor *service.RateLimitInterceptor
+	auditInterceptor     *service.AuditInterceptor
+	deadlineInterceptor  *service.DeadlineInterceptor
+	healthServer         *health.Server
+}
+
+func runGRPCServer(services *grpcServices, cfg *config.Config, listener net.Listener, shutdown *service.GracefulShutdown) error {
+	var serverOptions []grpc.ServerOption
+	if cfg.TLS.Enabled {
+		tlsCredentials, err := loadTLSCredentials(cfg.TLS)
 		if err != nil {
 			return fmt.Errorf("cannot load TLS credentials: %w", err)
 		}
 
 		serverOptions = append(serverOptions, grpc.Creds(tlsCredentials))
-		log.Printf("start GRPC server at %s, TLS = %t", listener.Addr().String(), enableTLS)
 	}
+	logging.Default().Info("start gRPC server", "address", listener.Addr().String(), "tls", cfg.TLS.Enabled)
+
+	grpcServer, stop, err := newGRPCServer(services, cfg, serverOptions...)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	shutdown.AddGRPCServer(grpcServer)
+	return grpcServer.Serve(listener)
+}
+
+// newGRPCServer returns a new gRPC server with the interceptor chain,
+// and a function to stop watching the access policy file
+func newGRPCServer(services *grpcServices, cfg *config.Config, serverOptions ...grpc.ServerOption) (*grpc.Server, func(), error) {
+	policyFile := cfg.Auth.PolicyFile
+	accessPolicy, err := service.LoadAccessPolicy(policyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	interceptor := service.NewAuthInterceptor(services.jwtManager, services.sessionStore, services.apiKeyStore, accessPolicy)
+	// 恢复拦截器紧跟在请求ID之后，任何后续阶段的panic都不会使服务端崩溃，并且记录了请求ID；
+	// 处理函数之前还有一个恢复拦截器，处理函数panic时，日志、指标和审计拦截器看到的是Internal错误。
+	// 日志、追踪、指标和审计拦截器在认证之前运行，被认证拒绝或被限流的调用也会被记录，审计记录的调用者由认证拦截器告知；
+	// 限流拦截器在认证之后运行，才能知道调用者是谁。期限和校验拦截器在审计之后运行，超时和无效的请求也有审计记录
+	chain := service.NewInterceptorChain().
+		Add(service.StageRequestID, services.requestIDInterceptor).
+		Add(service.StageRecovery, service.NewRecoveryInterceptor()).
+		Add(service.StageLogging, service.NewLoggingInterceptor()).
+		Add(service.StageTracing, services.tracingInterceptor).
+		Add(service.StageMetrics, services.metricsInterceptor).
+		Add(service.StageAudit, services.auditInterceptor).
+		Add(service.StageAuth, interceptor).
+		Add(service.StageRateLimit, services.rateLimitInterceptor).
+		Add(service.StageDeadline, services.deadlineInterceptor).
+		Add(service.StageValidation, service.NewValidationInterceptor()).
+		Add(service.StageHandlerRecovery, service.NewRecoveryInterceptor())
+	logging.Default().Debug("interceptor chain", "stages", strings.Join(chain.Stages(), ","))
+	serverOptions = append(serverOptions, chain.ServerOptions()...)
 
 	grpcServer := grpc.NewServer(serverOptions...)
 
-	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
-	pb.RegisterAuthServiceServer(grpcServer, authServer)
+	pb.RegisterLaptopServiceServer(grpcServer, services.laptopServer)
+	pb.RegisterAuthServiceServer(grpcServer, services.authServer)
+	pb.RegisterAdminServiceServer(grpcServer, services.adminServer)
+	healthpb.RegisterHealthServer(grpcServer, services.healthServer)
 
 	reflection.Register(grpcServer)
 
+	// 规则中的方法名写错时，启动直接失败，而不是悄悄地不生效
+	serviceInfo := grpcServer.GetServiceInfo()
+	err = accessPolicy.CheckMethods(serviceInfo)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid access policy %s: %w", policyFile, err)
+	}
+	err = services.rateLimitInterceptor.Policy().CheckMethods(serviceInfo)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid rate limits: %w", err)
+	}
+	err = services.deadlineInterceptor.Policy().CheckMethods(serviceInfo)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid deadlines: %w", err)
+	}
+	err = validate.CheckRules()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid validation rules: %w", err)
+	}
+
+	// 新的API密钥只能使用策略中定义的角色
+	services.authServer.SetAccessPolicy(accessPolicy)
+	stopWatch := service.WatchAccessPolicy(
+		policyFile,
+		time.Duration(cfg.Auth.PolicyReloadInterval),
+		func(policy *service.AccessPolicy) error {
+			return policy.CheckMethods(serviceInfo)
+		},
+		func(policy *service.AccessPolicy) {
+			interceptor.SetAccessPolicy(policy)
+			services.authServer.SetAccessPolicy(policy)
+		},
+	)
 
-	return grpcServer.Serve(listener)
+	return grpcServer, stopWatch, nil
 }
 
 func runRESTServer(
-	authServer pb.AuthServiceServer,
-	laptopServer pb.LaptopServiceServer,
 	jwtManager *service.JWTManager,
-	enableTLS bool,
+	tracer *tracing.Tracer,
+	tlsConfig config.TLSConfig,
 	listener net.Listener,
 	grpcEndpoint string, // 改进
+	shutdown *service.GracefulShutdown,
 ) error {
-	// 创建一个新的Http请求多路复用器
-	// 确保其来自 github.com/grpc-ecosystem/grpc-gateway/runtime
-	mux := runtime.NewServeMux()
-
 	// 为了方便演示，这里使用grpc.WithInsecure()
 	dialOptions := []grpc.DialOption{grpc.WithInsecure()}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	httpMux, err := newRESTHandler(ctx, jwtManager, tracer, grpcEndpoint, dialOptions)
+	if err != nil {
+		return err
+	}
+
+	httpServer := &http.Server{Handler: httpMux}
+	shutdown.AddHTTPServer(httpServer, nil)
+
+	logging.Default().Info("start REST server", "address", listener.Addr().String(), "tls", tlsConfig.Enabled)
+	if tlsConfig.Enabled {
+		return httpServer.ServeTLS(listener, tlsConfig.ServerCert, tlsConfig.ServerKey)
+	}
+	return httpServer.Serve(listener)
+}
+
+// newRESTHandler returns the HTTP handler of the REST gateway that calls the gRPC endpoint.
+// The API requests are traced with the tracer, which may be nil, the health checks are not.
+func newRESTHandler(
+	ctx context.Context,
+	jwtManager *service.JWTManager,
+	tracer *tracing.Tracer,
+	grpcEndpoint string,
+	dialOptions []grpc.DialOption,
+) (http.Handler, error) {
+	conn, err := grpc.DialContext(ctx, grpcEndpoint, dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// 网关调用gRPC时把traceparent放入元数据，gRPC的span与HTTP请求的span属于同一个追踪
+	tracingInterceptor := client.NewTracingInterceptor(tracer)
+	dialOptions = append(
+		dialOptions,
+		grpc.WithChainUnaryInterceptor(tracingInterceptor.Unary()),
+		grpc.WithChainStreamInterceptor(tracingInterceptor.Stream()),
+	)
+
+	// 创建一个新的Http请求多路复用器
+	// 确保其来自 github.com/grpc-ecosystem/grpc-gateway/runtime
+	// 错误以Google API的JSON格式返回，包含google.rpc的错误详情
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher),
+		runtime.WithProtoErrorHandler(service.GatewayErrorHandler),
+	)
+
 	// 现在开始写从REST到gRPC的进程内转换
 	// err := pb.RegisterAuthServiceHandlerServer(ctx, mux, authServer)
-	err := pb.RegisterAuthServiceHandlerFromEndpoint(ctx, mux, grpcEndpoint, dialOptions) // 改进
+	err = pb.RegisterAuthServiceHandlerFromEndpoint(ctx, mux, grpcEndpoint, dialOptions) // 改进
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// in-process handler
 	// err = pb.RegisterLaptopServiceHandlerServer(ctx, mux, laptopServer)
 	err = pb.RegisterLaptopServiceHandlerFromEndpoint(ctx, mux, grpcEndpoint, dialOptions) // 改进
+	if err != nil {
+		return nil, err
+	}
+
+	err = pb.RegisterAdminServiceHandlerFromEndpoint(ctx, mux, grpcEndpoint, dialOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	httpMux := http.NewServeMux()
+	httpMux.Handle("/", service.NewTracingHandler(tracer, mux))
+	// 健康状态来自gRPC服务端的健康检查服务，nginx等负载均衡器可以据此摘除实例
+	healthClient := healthpb.NewHealthClient(conn)
+	httpMux.Handle(service.HealthzPath, service.NewHealthzHandler(healthClient))
+	httpMux.Handle(service.ReadyzPath, service.NewReadyzHandler(healthClient, []string{service.AuthServiceName, service.LaptopServiceName}))
+	if keySet := jwtManager.KeySet(); keySet != nil {
+		// 发布公钥，其他服务可以用它验证pcbook签发的令牌
+		httpMux.Handle(service.JWKSPath, service.NewJWKSHandler(keySet))
+	}
+	// 网关把请求ID转发给gRPC服务端，两边的日志可以关联起来
+	return service.NewRequestIDHandler(logging.Default(), httpMux), nil
+}
+
+// inProcessBufferSize is the buffer size of the in-process connections between the REST gateway and the gRPC server
+const inProcessBufferSize = 1024 * 1024
+
+// runCombinedServer serves gRPC and REST on one listener, with TLS or in cleartext (h2c).
+// The REST gateway calls the gRPC server in-process, so the RPCs go through the same interceptors.
+func runCombinedServer(services *grpcServices, cfg *config.Config, listener net.Listener, shutdown *service.GracefulShutdown) error {
+	// 证书由HTTP服务端处理，gRPC服务端本身不使用TLS
+	grpcServer, stop, err := newGRPCServer(services, cfg)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	inProcessListener := bufconn.Listen(inProcessBufferSize)
+	go func() {
+		err := grpcServer.Serve(inProcessListener)
+		if err != nil {
+			logging.Default().Error("in-process gRPC server stopped", "error", err)
+		}
+	}()
+	shutdown.AddGRPCServer(grpcServer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dialOptions := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return inProcessListener.Dial()
+		}),
+	}
+	restHandler, err := newRESTHandler(ctx, services.jwtManager, services.tracer, service.InProcessNetwork, dialOptions)
 	if err != nil {
 		return err
 	}
 
-	if enableTLS {
-		log.Printf("start REST server at %s, TLS = %t", listener.Addr().String(), enableTLS)
-		return http.ServeTLS(listener, mux, serverCertFile, serverKeyFile)
+	// 关闭时等待所有请求结束，包括h2c连接上的gRPC流
+	inFlight := &service.InFlightRequests{}
+	handler := inFlight.Handler(service.GRPCHandlerFunc(grpcServer, restHandler))
+	logging.Default().Info("start combined gRPC and REST server", "address", listener.Addr().String(), "tls", cfg.TLS.Enabled)
+
+	if cfg.TLS.Enabled {
+		tlsConfig, err := loadTLSConfig(cfg.TLS)
+		if err != nil {
+			return fmt.Errorf("cannot load TLS config: %w", err)
+		}
+
+		// ServeTLS会通过ALPN协商HTTP/2，gRPC客户端总是使用HTTP/2
+		httpServer := &http.Server{Handler: handler, TLSConfig: tlsConfig}
+		shutdown.AddHTTPServer(httpServer, inFlight)
+		return httpServer.ServeTLS(listener, "", "")
 	}
-	return http.Serve(listener, mux)
+
+	// 明文的HTTP/2（h2c），gRPC客户端不经过升级直接发送HTTP/2前言
+	http2Server := &http2.Server{}
+	httpServer := &http.Server{Handler: h2c.NewHandler(handler, http2Server)}
+	// 关闭时向h2c连接发送GOAWAY，客户端不再在这些连接上发起新的请求
+	err = http2.ConfigureServer(httpServer, http2Server)
+	if err != nil {
+		return err
+	}
+	shutdown.AddHTTPServer(httpServer, inFlight)
+	return httpServer.Serve(listener)
 }
 
-func main() {
-	port := flag.Int("port", 0, "the server port")
-	enableTLS := flag.Bool("tls", false, "enable SSL/TLS")
-	serverType := flag.String("type", "grpc", "type of grpc (grpc/rest)")
-	endPoint := flag.String("endpoint", "", "gprc endpoint") // 改进
+// runMetricsServer serves the Prometheus metrics on their own port, so that they are not exposed with the API
+func runMetricsServer(registry *metrics.Registry, port int, shutdown *service.GracefulShutdown) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(metrics.Path, registry.Handler())
+	httpServer := &http.Server{Handler: mux}
+	shutdown.AddHTTPServer(httpServer, nil)
+
+	logging.Default().Info("start metrics server", "address", listener.Addr().String(), "path", metrics.Path)
+	return httpServer.Serve(listener)
+}
+
+// newTracer returns the tracer of the server, or nil if tracing is disabled.
+// The file exporter is closed after the connections are drained, so that the spans of the last requests are written.
+func newTracer(tracingConfig config.TracingConfig, serviceName string, shutdown *service.GracefulShutdown) (*tracing.Tracer, error) {
+	switch tracingConfig.Exporter {
+	case config.TraceExporterStdout:
+		return tracing.NewTracer(serviceName, tracing.NewJSONExporter(os.Stdout)), nil
+	case config.TraceExporterFile:
+		exporter, err := tracing.OpenFileExporter(tracingConfig.File)
+		if err != nil {
+			return nil, err
+		}
+		shutdown.AddCloser(exporter)
+		return tracing.NewTracer(serviceName, exporter), nil
+	}
+	return nil, nil
+}
+
+// newLogger returns the logger of the server, the config has been validated
+func newLogger(logConfig config.LogConfig) *logging.Logger {
+	level, _ := logging.ParseLevel(logConfig.Level)
+	format, _ := logging.ParseFormat(logConfig.Format)
+	return logging.New(os.Stderr, format, level)
+}
+
+// incomingHeaderMatcher forwards the API key, tenant and request ID headers to gRPC as is, besides the default headers
+func incomingHeaderMatcher(key string) (string, bool) {
+	if strings.EqualFold(key, service.APIKeyHeader) {
+		return service.APIKeyHeader, true
+	}
+	if strings.EqualFold(key, service.TenantHeader) {
+		return service.TenantHeader, true
+	}
+	if strings.EqualFold(key, service.RequestIDHeader) {
+		return service.RequestIDHeader, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// serverFlags are the command line flags, they override the config file and the environment variables
+type serverFlags struct {
+	configFile     string
+	printConfig    bool
+	port           int
+	enableTLS      bool
+	serverType     string
+	endpoint       string
+	policyFile     string
+	trustedProxies string
+	bindTokens     bool
+	passwordHash   string
+	jwtKeys        string
+	imageFolder    string
+	auditLog       string
+	drainTimeout   config.Duration
+	metricsPort    int
+	traceExporter  string
+	traceFile      string
+	logLevel       string
+	logFormat      string
+	rateLimit      bool
+	deadlines      bool
+}
+
+func parseFlags() *serverFlags {
+	defaults := config.Default()
+	flags := &serverFlags{}
+	flag.StringVar(&flags.configFile, "config", os.Getenv(config.EnvPrefix+"_CONFIG"), "the JSON config file, overridden by PCBOOK_* environment variables and flags")
+	flag.BoolVar(&flags.printConfig, "print-config", false, "print the effective config with secrets redacted and exit")
+	flag.IntVar(&flags.port, "port", defaults.Server.Port, "the server port")
+	flag.BoolVar(&flags.enableTLS, "tls", defaults.TLS.Enabled, "enable SSL/TLS")
+	flag.StringVar(&flags.serverType, "type", defaults.Server.Type, "type of server (grpc/rest/combined), combined serves gRPC and REST on one port")
+	flag.StringVar(&flags.endpoint, "endpoint", defaults.Server.Endpoint, "gprc endpoint") // 改进
+	flag.StringVar(&flags.policyFile, "policy", defaults.Auth.PolicyFile, "the access policy file, reloaded on change")
+	flag.StringVar(&flags.trustedProxies, "trusted-proxies", strings.Join(defaults.Server.TrustedProxies, ","), "comma separated addresses or CIDRs of proxies (e.g. the REST gateway) whose x-forwarded-for is trusted")
+	flag.BoolVar(&flags.bindTokens, "cert-bound-tokens", defaults.Auth.CertBoundTokens, "bind the tokens issued over mTLS to the client certificate (RFC 8705)")
+	flag.StringVar(&flags.passwordHash, "password-hash", defaults.Password.Hash, "algorithm to hash passwords (bcrypt/argon2id), existing hashes are upgraded on login")
+	flag.StringVar(&flags.jwtKeys, "jwt-keys", defaults.Auth.JWTKeys, "folder of PEM private keys to sign tokens with RS256/ES256, use HS256 with the secret key if empty")
+	flag.StringVar(&flags.imageFolder, "image-folder", defaults.Storage.ImageFolder, "the folder to store laptop images")
+	flag.StringVar(&flags.auditLog, "audit-log", defaults.Storage.AuditLog, "the append-only, hash-chained file of audit records of mutating RPCs")
+	flags.drainTimeout = defaults.Server.DrainTimeout
+	flag.Var(&flags.drainTimeout, "drain-timeout", "how long to wait for the RPCs in progress on SIGTERM before stopping hard")
+	flag.IntVar(&flags.metricsPort, "metrics-port", defaults.Server.MetricsPort, "the port of the Prometheus metrics, 0 to disable them")
+	flag.StringVar(&flags.traceExporter, "trace-exporter", defaults.Tracing.Exporter, "where to write the spans of the requests (none/stdout/file)")
+	flag.StringVar(&flags.traceFile, "trace-file", defaults.Tracing.File, "the file of the spans, one JSON object per line, for the file exporter")
+	flag.StringVar(&flags.logLevel, "log-level", defaults.Log.Level, "the minimum level of the logs (debug/info/warn/error)")
+	flag.StringVar(&flags.logFormat, "log-format", defaults.Log.Format, "the format of the logs (logfmt/json)")
+	flag.BoolVar(&flags.rateLimit, "rate-limit", defaults.RateLimit.Enabled, "limit the rate of the RPCs and the open streams of each user, API key or client address")
+	flag.BoolVar(&flags.deadlines, "deadlines", defaults.Deadlines.Enabled, "enforce the server-side deadlines of the RPCs, the idle timeouts and the message limits of the streams")
 	flag.Parse()
-	log.Printf("start server on port = %d, TLS = %t", *port, *enableTLS)
+	return flags
+}
+
+// loadConfig builds the config from the config file, the environment variables and the flags, in that order
+func loadConfig(flags *serverFlags) (*config.Config, error) {
+	cfg, err := config.Load(flags.configFile, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	// 只有命令行中明确设置的参数才覆盖配置
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Server.Port = flags.port
+		case "tls":
+			cfg.TLS.Enabled = flags.enableTLS
+		case "type":
+			cfg.Server.Type = flags.serverType
+		case "endpoint":
+			cfg.Server.Endpoint = flags.endpoint
+		case "policy":
+			cfg.Auth.PolicyFile = flags.policyFile
+		case "trusted-proxies":
+			cfg.Server.TrustedProxies = strings.Split(flags.trustedProxies, ",")
+		case "cert-bound-tokens":
+			cfg.Auth.CertBoundTokens = flags.bindTokens
+		case "password-hash":
+			cfg.Password.Hash = flags.passwordHash
+		case "jwt-keys":
+			cfg.Auth.JWTKeys = flags.jwtKeys
+		case "image-folder":
+			cfg.Storage.ImageFolder = flags.imageFolder
+		case "audit-log":
+			cfg.Storage.AuditLog = flags.auditLog
+		case "drain-timeout":
+			cfg.Server.DrainTimeout = flags.drainTimeout
+		case "metrics-port":
+			cfg.Server.MetricsPort = flags.metricsPort
+		case "trace-exporter":
+			cfg.Tracing.Exporter = flags.traceExporter
+		case "trace-file":
+			cfg.Tracing.File = flags.traceFile
+		case "log-level":
+			cfg.Log.Level = flags.logLevel
+		case "log-format":
+			cfg.Log.Format = flags.logFormat
+		case "rate-limit":
+			cfg.RateLimit.Enabled = flags.rateLimit
+		case "deadlines":
+			cfg.Deadlines.Enabled = flags.deadlines
+		}
+	})
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func printConfig(cfg *config.Config) error {
+	data, err := json.MarshalIndent(cfg.Redacted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// reloadOnSIGHUP reloads the config on SIGHUP and applies the settings that can change at runtime:
+// the login limits, the rate limits, the deadlines and the password hashing. The access policy file is reloaded on change anyway.
+func reloadOnSIGHUP(
+	flags *serverFlags,
+	cfg *config.Config,
+	loginLimiter *service.LoginLimiter,
+	rateLimitInterceptor *service.RateLimitInterceptor,
+	deadlineInterceptor *service.DeadlineInterceptor,
+	authServer *service.AuthServer,
+) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			newConfig, err := loadConfig(flags)
+			if err != nil {
+				logging.Default().Error("keep the current config, cannot reload", "error", err)
+				continue
+			}
 
+			passwordHasher, err := newPasswordHasher(newConfig.Password)
+			if err != nil {
+				logging.Default().Error("keep the current config, cannot reload", "error", err)
+				continue
+			}
+			rateLimitPolicy, err := newRateLimitPolicy(newConfig.RateLimit)
+			if err != nil {
+				logging.Default().Error("keep the current config, cannot reload", "error", err)
+				continue
+			}
+			deadlinePolicy, err := newDeadlinePolicy(newConfig.Deadlines)
+			if err != nil {
+				logging.Default().Error("keep the current config, cannot reload", "error", err)
+				continue
+			}
+			authServer.SetPasswordHasher(passwordHasher)
+			rateLimitInterceptor.SetPolicy(rateLimitPolicy)
+			deadlineInterceptor.SetPolicy(deadlinePolicy)
+
+			login := newConfig.Login
+			loginLimiter.SetLimits(
+				login.MaxFailures,
+				time.Duration(login.BaseDelay),
+				time.Duration(login.MaxDelay),
+				time.Duration(login.LockoutDuration),
+			)
+
+			for _, section := range config.RestartRequired(cfg, newConfig) {
+				logging.Default().Warn("config has changed, restart the server to apply it", "section", section)
+			}
+			// 下次重新加载时与这次的配置比较，每个改动只警告一次
+			cfg = newConfig
+			logging.Default().Info("config reloaded")
+		}
+	}()
+}
+
+// newRateLimitPolicy returns the rate limit policy of the config, without rules if the rate limits are disabled
+func newRateLimitPolicy(rateLimitConfig config.RateLimitConfig) (*service.RateLimitPolicy, error) {
+	if !rateLimitConfig.Enabled {
+		return service.NewRateLimitPolicy(nil)
+	}
+	return rateLimitConfig.Policy()
+}
+
+// newDeadlinePolicy returns the deadline policy of the config, without rules if the deadlines are disabled
+func newDeadlinePolicy(deadlineConfig config.DeadlineConfig) (*service.DeadlinePolicy, error) {
+	if !deadlineConfig.Enabled {
+		return service.NewDeadlinePolicy(nil)
+	}
+	return deadlineConfig.Policy()
+}
+
+func main() {
+	flags := parseFlags()
+	cfg, err := loadConfig(flags)
+	if err != nil {
+		logging.Default().Fatal("cannot load config", "error", err)
+	}
+	if flags.printConfig {
+		err = printConfig(cfg)
+		if err != nil {
+			logging.Default().Fatal("cannot print config", "error", err)
+		}
+		return
+	}
+
+	// 日志的格式和级别来自配置，级别可以通过AdminService.SetLogLevel在运行时修改
+	logger := newLogger(cfg.Log)
+	logging.SetDefault(logger)
+	// 使用标准库log的依赖库也输出同样格式的日志
+	log.SetFlags(0)
+	log.SetOutput(logger.StdWriter(logging.LevelInfo))
+	logger.Info("start server", "port", cfg.Server.Port, "tls", cfg.TLS.Enabled, "type", cfg.Server.Type)
+
+	passwordHasher, err := newPasswordHasher(cfg.Password)
+	if err != nil {
+		logger.Fatal("cannot create password hasher", "error", err)
+	}
 	userStore := service.NewInMemoryUserStore()
-	err := seedUsers(userStore)
+	err = seedUsers(userStore, passwordHasher, cfg.Users)
+	if err != nil {
+		logger.Fatal("cannot seed users", "error", err)
+	}
+	jwtManager, err := newJWTManager(cfg.Auth)
 	if err != nil {
-		log.Fatal("cannot seed users")
+		logger.Fatal("cannot create JWT manager", "error", err)
 	}
-	jwtManager := service.NewJWTManager(secretKey, tokenDuration)
-	authServer := service.NewAuthServer(userStore, jwtManager)
+	jwtManager.SetCertificateBinding(cfg.Auth.CertBoundTokens)
+	sessionStore := service.NewInMemorySessionStore()
+	apiKeyStore := service.NewInMemoryAPIKeyStore()
+	loginLimiter := service.NewLoginLimiter(
+		cfg.Login.MaxFailures,
+		time.Duration(cfg.Login.BaseDelay),
+		time.Duration(cfg.Login.MaxDelay),
+		time.Duration(cfg.Login.LockoutDuration),
+	)
+	proxies, err := service.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		logger.Fatal("cannot parse trusted proxies", "error", err)
+	}
+	authServer := service.NewAuthServer(userStore, sessionStore, apiKeyStore, jwtManager, loginLimiter, proxies, passwordHasher)
 
 	laptopStore := service.NewInMemoryLaptopStore()
-	imageStore := service.NewDiskImageStore("img")
+	imageStore := service.NewDiskImageStore(cfg.Storage.ImageFolder)
 	ratingStore := service.NewInMemoryRatingStore()
 	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
 
-	address := fmt.Sprintf("0.0.0.0:%d", *port)
-	listener, err := net.Listen("tcp", address)
+	healthServer := health.NewServer()
+	shutdown := service.NewGracefulShutdown(healthServer)
+	if cfg.Server.Type != "rest" {
+		// 存储不可用时服务报告NOT_SERVING，状态变化会推送给Watch的客户端
+		healthChecker := service.NewHealthChecker(healthServer)
+		healthChecker.AddProbe(service.AuthServiceName, "user store", userStore)
+		healthChecker.AddProbe(service.LaptopServiceName, "laptop store", laptopStore)
+		healthChecker.AddProbe(service.LaptopServiceName, "image store", imageStore)
+		healthChecker.AddProbe(service.LaptopServiceName, "rating store", ratingStore)
+		stopHealthChecks := healthChecker.Run(time.Duration(cfg.Server.HealthCheckInterval))
+		defer stopHealthChecks()
+	}
+
+	var adminServer *service.AdminServer
+	var auditInterceptor *service.AuditInterceptor
+	if cfg.Server.Type != "rest" {
+		// 只有gRPC服务端记录审计日志，REST网关的请求也会经过它
+		auditLog, err := service.OpenFileAuditLog(cfg.Storage.AuditLog)
+		if err != nil {
+			logger.Fatal("cannot open audit log", "error", err)
+		}
+		shutdown.AddCloser(auditLog)
+		adminServer = service.NewAdminServer(auditLog, logger)
+		auditInterceptor = service.NewAuditInterceptor(auditLog, proxies)
+	}
+
+	tracer, err := newTracer(cfg.Tracing, "pcbook-"+cfg.Server.Type, shutdown)
 	if err != nil {
-		log.Fatal("cannot start server: ", err)
+		logger.Fatal("cannot create tracer", "error", err)
 	}
 
-	if *serverType == "grpc" {
-		err = runGRPCServer(authServer, laptopServer, jwtManager, *enableTLS, listener)
+	registry := metrics.NewRegistry()
+	metricsInterceptor := service.NewMetricsInterceptor(registry)
+	registry.NewGaugeFunc("pcbook_laptops", "Number of laptops in the store, of all tenants.", func() float64 {
+		return float64(laptopStore.Count())
+	})
+	registry.NewGaugeFunc("pcbook_images", "Number of images uploaded since the server started.", func() float64 {
+		return float64(imageStore.Count())
+	})
+	registry.NewGaugeFunc("pcbook_users", "Number of users in the store.", func() float64 {
+		return float64(userStore.Count())
+	})
+
+	rateLimitPolicy, err := newRateLimitPolicy(cfg.RateLimit)
+	if err != nil {
+		logger.Fatal("cannot create rate limit policy", "error", err)
 	}
-	err = runRESTServer(authServer, laptopServer, jwtManager, *enableTLS, listener, *endPoint)
+	rateLimitInterceptor := service.NewRateLimitInterceptor(service.NewInMemoryRateLimiter(), rateLimitPolicy, proxies)
+
+	deadlinePolicy, err := newDeadlinePolicy(cfg.Deadlines)
 	if err != nil {
-		log.Fatal("cannot start server: ", err)
+		logger.Fatal("cannot create deadline policy", "error", err)
+	}
+	deadlineInterceptor := service.NewDeadlineInterceptor(deadlinePolicy)
+
+	reloadOnSIGHUP(flags, cfg, loginLimiter, rateLimitInterceptor, deadlineInterceptor, authServer)
+
+	address := fmt.Sprintf("0.0.0.0:%d", cfg.Server.Port)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		logger.Fatal("cannot start server", "error", err)
+	}
+
+	services := &grpcServices{
+		authServer:           authServer,
+		laptopServer:         laptopServer,
+		adminServer:          adminServer,
+		jwtManager:           jwtManager,
+		sessionStore:         sessionStore,
+		apiKeyStore:          apiKeyStore,
+		tracer:               tracer,
+		requestIDInterceptor: service.NewRequestIDInterceptor(logger),
+		tracingInterceptor:   service.NewTracingInterceptor(tracer),
+		metricsInterceptor:   metricsInterceptor,
+		rateLimitInterceptor: rateLimitInterceptor,
+		auditInterceptor:     auditInterceptor,
+		deadlineInterceptor:  deadlineInterceptor,
+		healthServer:         healthServer,
+	}
+
+	serveErrors := make(chan error, 2)
+	if cfg.Server.MetricsPort != 0 {
+		go func() {
+			serveErrors <- runMetricsServer(registry, cfg.Server.MetricsPort, shutdown)
+		}()
+	}
+	go func() {
+		switch cfg.Server.Type {
+		case "grpc":
+			serveErrors <- runGRPCServer(services, cfg, listener, shutdown)
+		case "rest":
+			serveErrors <- runRESTServer(jwtManager, tracer, cfg.TLS, listener, cfg.Server.Endpoint, shutdown)
+		case "combined":
+			serveErrors <- runCombinedServer(services, cfg, listener, shutdown)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErrors:
+		logger.Fatal("cannot start server", "error", err)
+	case sig := <-signals:
+		drainTimeout := time.Duration(cfg.Server.DrainTimeout)
+		logger.Info("draining connections", "signal", sig, "drain_timeout", drainTimeout)
+
+		// 再次收到信号时立即退出
+		signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+		err := shutdown.Shutdown(drainTimeout)
+		if err != nil {
+			logger.Fatal("cannot shut down gracefully", "error", err)
+		}
+		logger.Info("server stopped")
 	}
 }
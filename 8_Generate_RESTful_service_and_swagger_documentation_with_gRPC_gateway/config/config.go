@@ -0,0 +1,336 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Config is the configuration of the pcbook server.
+// It is built from the defaults, then the config file, then the environment variables, then the command line flags.
+type Config struct {
+	Server    ServerConfig    `json:"server"`
+	TLS       TLSConfig       `json:"tls"`
+	Auth      AuthConfig      `json:"auth"`
+	Login     LoginConfig     `json:"login" reload:"true"`
+	RateLimit RateLimitConfig `json:"rate_limit" reload:"true"`
+	Deadlines DeadlineConfig  `json:"deadlines" reload:"true"`
+	Password  PasswordConfig  `json:"password" reload:"true"`
+	Storage   StorageConfig   `json:"storage"`
+	Tracing   TracingConfig   `json:"tracing"`
+	Log       LogConfig       `json:"log"`
+	// Users 是启动时创建的用户，只适用于内存中的用户存储
+	Users []UserConfig `json:"users"`
+}
+
+// ServerConfig configures how the server listens
+type ServerConfig struct {
+	Port     int    `json:"port"`
+	Type     string `json:"type"`     // grpc/rest/combined
+	Endpoint string `json:"endpoint"` // REST网关转发到的gRPC地址
+	// TrustedProxies 是可信代理（如REST网关）的地址或CIDR，信任它们传来的x-forwarded-for
+	TrustedProxies []string `json:"trusted_proxies"`
+	// DrainTimeout 是收到SIGTERM后等待进行中的RPC结束的时间，超时后强制关闭
+	DrainTimeout Duration `json:"drain_timeout"`
+	// HealthCheckInterval 是检查存储是否可用的间隔，结果通过gRPC健康检查服务和/readyz报告
+	HealthCheckInterval Duration `json:"health_check_interval"`
+	// MetricsPort 是Prometheus指标/metrics的端口，与API端口分开，0表示不提供指标
+	MetricsPort int `json:"metrics_port"`
+}
+
+// TLSConfig configures the server certificate and the verification of client certificates
+type TLSConfig struct {
+	Enabled    bool   `json:"enabled"`
+	CACert     string `json:"ca_cert"` // 签署客户端证书的CA
+	ServerCert string `json:"server_cert"`
+	ServerKey  string `json:"server_key"`
+	ClientAuth string `json:"client_auth"` // none/verify_if_given/require
+}
+
+// Client certificate modes of TLSConfig.ClientAuth
+const (
+	ClientAuthNone          = "none"
+	ClientAuthVerifyIfGiven = "verify_if_given"
+	ClientAuthRequire       = "require"
+)
+
+// AuthConfig configures the access tokens and the access policy
+type AuthConfig struct {
+	SecretKey     string   `json:"secret_key" secret:"true"` // 没有配置JWTKeys时用于HS256签名
+	TokenDuration Duration `json:"token_duration"`
+	JWTKeys       string   `json:"jwt_keys"` // PEM私钥目录，用于RS256/ES256签名
+	// CertBoundTokens 为true时，通过mTLS登录获得的令牌与客户端证书绑定
+	CertBoundTokens      bool     `json:"cert_bound_tokens"`
+	PolicyFile           string   `json:"policy_file"`
+	PolicyReloadInterval Duration `json:"policy_reload_interval"`
+}
+
+// LoginConfig configures the backoff and lockout after failed logins
+type LoginConfig struct {
+	MaxFailures     int      `json:"max_failures"`
+	BaseDelay       Duration `json:"base_delay"`
+	MaxDelay        Duration `json:"max_delay"`
+	LockoutDuration Duration `json:"lockout_duration"`
+}
+
+// RateLimitConfig configures the rate limits of the callers.
+// Authenticated callers are limited per user or API key, the others per client address.
+type RateLimitConfig struct {
+	Enabled bool                  `json:"enabled"`
+	Rules   []RateLimitRuleConfig `json:"rules"`
+}
+
+// RateLimitRuleConfig limits the callers of a method, the rules of "*" and of the called method both apply.
+// A rule with a role overrides the rule without role of the same method for the callers that have the role.
+type RateLimitRuleConfig struct {
+	Method     string  `json:"method"` // *或/package.Service/Method
+	Role       string  `json:"role"`
+	Rate       float64 `json:"rate"` // 每秒的请求数，0表示不限制速率
+	Burst      int     `json:"burst"`
+	MaxStreams int     `json:"max_streams"` // 同时打开的流的数量，0表示不限制
+}
+
+// DeadlineConfig configures the server-side deadlines of the RPCs and the limits of the streams,
+// they apply even if the clients set no deadline
+type DeadlineConfig struct {
+	Enabled bool                 `json:"enabled"`
+	Rules   []DeadlineRuleConfig `json:"rules"`
+}
+
+// DeadlineRuleConfig bounds the RPCs of a method, the rule of the called method replaces the rule of "*".
+// The zero values mean no limit.
+type DeadlineRuleConfig struct {
+	Method      string   `json:"method"`       // *或/package.Service/Method
+	Timeout     Duration `json:"timeout"`      // 调用的最长期限，对于流是总时长
+	IdleTimeout Duration `json:"idle_timeout"` // 流等待下一条消息的最长时间
+	MaxMessages int      `json:"max_messages"` // 流从客户端接收的最多消息数
+}
+
+// PasswordConfig configures how passwords are hashed, existing hashes are upgraded on login
+type PasswordConfig struct {
+	Hash       string         `json:"hash"` // bcrypt/argon2id
+	BcryptCost int            `json:"bcrypt_cost"`
+	Argon2id   Argon2idConfig `json:"argon2id"`
+}
+
+// Argon2idConfig are the parameters of argon2id
+type Argon2idConfig struct {
+	Time       uint32 `json:"time"`
+	Memory     uint32 `json:"memory"` // KiB
+	Threads    uint8  `json:"threads"`
+	SaltLength uint32 `json:"salt_length"`
+	KeyLength  uint32 `json:"key_length"`
+}
+
+// StorageConfig chooses the store backends
+type StorageConfig struct {
+	UserStore    string `json:"user_store"` // 目前只支持memory
+	SessionStore string `json:"session_store"`
+	APIKeyStore  string `json:"api_key_store"`
+	LaptopStore  string `json:"laptop_store"`
+	RatingStore  string `json:"rating_store"`
+	ImageStore   string `json:"image_store"` // 目前只支持disk
+	ImageFolder  string `json:"image_folder"`
+	AuditLog     string `json:"audit_log"`
+}
+
+// Store backends
+const (
+	StoreMemory = "memory"
+	StoreDisk   = "disk"
+)
+
+// TracingConfig chooses where the spans of the traced requests are written
+type TracingConfig struct {
+	Exporter string `json:"exporter"` // none/stdout/file
+	File     string `json:"file"`     // exporter为file时每行写入一个JSON格式的span
+}
+
+// Exporters of the spans
+const (
+	TraceExporterNone   = "none"
+	TraceExporterStdout = "stdout"
+	TraceExporterFile   = "file"
+)
+
+// LogConfig configures the server logs, the level can also be changed at runtime with AdminService.SetLogLevel
+type LogConfig struct {
+	Level  string `json:"level"`  // debug/info/warn/error
+	Format string `json:"format"` // logfmt/json
+}
+
+// UserConfig is a user created at startup
+type UserConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password" secret:"true"`
+	Role     string `json:"role"`
+	TenantID string `json:"tenant_id"`
+}
+
+// Default returns the default configuration, it is the same as the settings before the configuration was added
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Type:                "grpc",
+			TrustedProxies:      []string{"127.0.0.1", "::1"},
+			DrainTimeout:        Duration(30 * time.Second),
+			HealthCheckInterval: Duration(5 * time.Second),
+		},
+		TLS: TLSConfig{
+			CACert:     "cert/ca-cert.pem",
+			ServerCert: "cert/server-cert.pem",
+			ServerKey:  "cert/server-key.pem",
+			ClientAuth: ClientAuthVerifyIfGiven,
+		},
+		Auth: AuthConfig{
+			SecretKey:            "secret",
+			TokenDuration:        Duration(20 * time.Minute),
+			PolicyFile:           "policy/access_policy.json",
+			PolicyReloadInterval: Duration(5 * time.Second),
+		},
+		Login: LoginConfig{
+			MaxFailures:     5,
+			BaseDelay:       Duration(time.Second),
+			MaxDelay:        Duration(30 * time.Second),
+			LockoutDuration: Duration(15 * time.Minute),
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Rules: []RateLimitRuleConfig{
+				{Method: "*", Rate: 20, Burst: 40, MaxStreams: 10},
+				{Method: "*", Role: "admin", Rate: 100, Burst: 200, MaxStreams: 50},
+				{Method: "/pcbook.pbfiles.LaptopService/CreateLaptop", Rate: 2, Burst: 20},
+				{Method: "/pcbook.pbfiles.LaptopService/SearchLaptop", MaxStreams: 5},
+			},
+		},
+		Deadlines: DeadlineConfig{
+			Enabled: true,
+			Rules: []DeadlineRuleConfig{
+				{Method: "*", Timeout: Duration(30 * time.Second), IdleTimeout: Duration(10 * time.Second), MaxMessages: 1000},
+				// 1MB的图片按1KB分块上传，需要更多的消息和时间
+				{Method: "/pcbook.pbfiles.LaptopService/UploadImage", Timeout: Duration(2 * time.Minute), IdleTimeout: Duration(10 * time.Second), MaxMessages: 2048},
+			},
+		},
+		Password: PasswordConfig{
+			Hash:       "bcrypt",
+			BcryptCost: 10,
+			Argon2id: Argon2idConfig{
+				Time:       3,
+				Memory:     64 * 1024,
+				Threads:    4,
+				SaltLength: 16,
+				KeyLength:  32,
+			},
+		},
+		Storage: StorageConfig{
+			UserStore:    StoreMemory,
+			SessionStore: StoreMemory,
+			APIKeyStore:  StoreMemory,
+			LaptopStore:  StoreMemory,
+			RatingStore:  StoreMemory,
+			ImageStore:   StoreDisk,
+			ImageFolder:  "img",
+			AuditLog:     "audit.log",
+		},
+		Tracing: TracingConfig{
+			Exporter: TraceExporterNone,
+			File:     "traces.jsonl",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "logfmt",
+		},
+		Users: []UserConfig{
+			{Username: "admin", Password: "123", Role: "admin"},
+			{Username: "editor", Password: "123", Role: "editor"}, // 只能修改自己创建的笔记本
+			{Username: "pd", Password: "123", Role: "user"},
+			// acme租户的用户只能看到acme的笔记本。可以管理所有租户的超级管理员只能在配置文件中显式创建
+			{Username: "acme-editor", Password: "123", Role: "editor", TenantID: "acme"},
+		},
+	}
+}
+
+// Load returns the default configuration overridden by the config file and the environment variables.
+// The file is skipped if filename is empty, lookupEnv is usually os.LookupEnv.
+func Load(filename string, lookupEnv func(key string) (string, bool)) (*Config, error) {
+	config := Default()
+
+	if filename != "" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read config file: %w", err)
+		}
+
+		// 解码到已有的切片时会复用其中的元素，先清空，文件中没有users或rules时再恢复默认值
+		users := config.Users
+		config.Users = nil
+		rateLimitRules := config.RateLimit.Rules
+		config.RateLimit.Rules = nil
+		deadlineRules := config.Deadlines.Rules
+		config.Deadlines.Rules = nil
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(config)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse config file %s: %w", filename, err)
+		}
+		if config.Users == nil {
+			config.Users = users
+		}
+		if config.RateLimit.Rules == nil {
+			config.RateLimit.Rules = rateLimitRules
+		}
+		if config.Deadlines.Rules == nil {
+			config.Deadlines.Rules = deadlineRules
+		}
+	}
+
+	err := applyEnv(config, lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Clone returns a deep copy of the configuration
+func (config *Config) Clone() *Config {
+	other := *config
+	other.Server.TrustedProxies = append([]string(nil), config.Server.TrustedProxies...)
+	other.Users = append([]UserConfig(nil), config.Users...)
+	other.RateLimit.Rules = append([]RateLimitRuleConfig(nil), config.RateLimit.Rules...)
+	other.Deadlines.Rules = append([]DeadlineRuleConfig(nil), config.Deadlines.Rules...)
+	return &other
+}
+
+// Duration is a time.Duration written as a string like "20m" in the config file
+type Duration time.Duration
+
+func (duration Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(duration).String())
+}
+
+func (duration *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return fmt.Errorf("duration must be a string like \"20m\": %w", err)
+	}
+	return duration.Set(value)
+}
+
+// Set parses the duration, it makes Duration a flag.Value
+func (duration *Duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*duration = Duration(parsed)
+	return nil
+}
+
+func (duration Duration) String() string {
+	return time.Duration(duration).String()
+}
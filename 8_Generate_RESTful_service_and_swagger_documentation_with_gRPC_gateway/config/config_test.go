@@ -0,0 +1,172 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{
+		"PCBOOK_AUTH_SECRET_KEY":          "from-env",
+		"PCBOOK_LOGIN_MAX_FAILURES":       "7",
+		"PCBOOK_SERVER_TRUSTED_PROXIES":   "10.0.0.0/8, 127.0.0.1",
+		"PCBOOK_PASSWORD_ARGON2ID_MEMORY": "1024",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	cfg, err := Load("server.example.json", lookupEnv)
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	// 文件覆盖默认值，环境变量覆盖文件
+	require.Equal(t, 8080, cfg.Server.Port)
+	require.True(t, cfg.TLS.Enabled)
+	require.Equal(t, "argon2id", cfg.Password.Hash)
+	require.Equal(t, 10, cfg.Password.BcryptCost)
+	require.Equal(t, Duration(20*time.Minute), cfg.Auth.TokenDuration)
+	require.Equal(t, "from-env", cfg.Auth.SecretKey)
+	require.Equal(t, 7, cfg.Login.MaxFailures)
+	require.Equal(t, []string{"10.0.0.0/8", "127.0.0.1"}, cfg.Server.TrustedProxies)
+	require.EqualValues(t, 1024, cfg.Password.Argon2id.Memory)
+	require.Equal(t, []UserConfig{{Username: "admin", Password: "change-me-too", Role: "admin"}}, cfg.Users)
+
+	cfg, err = Load("", lookupEnv)
+	require.NoError(t, err)
+	require.Equal(t, Default().Users, cfg.Users)
+
+	// 默认配置中没有可以访问所有租户的超级管理员
+	for _, user := range cfg.Users {
+		require.NotEqual(t, "super-admin", user.Role, user.Username)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		file string
+		env  map[string]string
+	}{
+		{name: "unknown_key", file: `{"server": {"prot": 8080}}`},
+		{name: "invalid_duration", file: `{"auth": {"token_duration": 20}}`},
+		{name: "invalid_env", file: `{}`, env: map[string]string{"PCBOOK_SERVER_PORT": "http"}},
+		{name: "users_from_env", file: `{}`, env: map[string]string{"PCBOOK_USERS": "admin"}},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			filename := writeTestConfig(t, tc.file)
+			_, err := Load(filename, func(key string) (string, bool) {
+				value, ok := tc.env[key]
+				return value, ok
+			})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		modify func(cfg *Config)
+	}{
+		{name: "port", modify: func(cfg *Config) { cfg.Server.Port = 70000 }},
+		{name: "rest_without_endpoint", modify: func(cfg *Config) { cfg.Server.Type = "rest" }},
+		{name: "trusted_proxy", modify: func(cfg *Config) { cfg.Server.TrustedProxies = []string{"proxy"} }},
+		{name: "drain_timeout", modify: func(cfg *Config) { cfg.Server.DrainTimeout = 0 }},
+		{name: "health_check_interval", modify: func(cfg *Config) { cfg.Server.HealthCheckInterval = 0 }},
+		{name: "metrics_port", modify: func(cfg *Config) { cfg.Server.Port, cfg.Server.MetricsPort = 8080, 8080 }},
+		{name: "client_auth", modify: func(cfg *Config) { cfg.TLS.Enabled, cfg.TLS.ClientAuth = true, "maybe" }},
+		{name: "secret_key", modify: func(cfg *Config) { cfg.Auth.SecretKey = "" }},
+		{name: "token_duration", modify: func(cfg *Config) { cfg.Auth.TokenDuration = 0 }},
+		{name: "login_delays", modify: func(cfg *Config) { cfg.Login.MaxDelay = Duration(time.Millisecond) }},
+		{name: "bcrypt_cost", modify: func(cfg *Config) { cfg.Password.BcryptCost = 100 }},
+		{name: "password_hash", modify: func(cfg *Config) { cfg.Password.Hash = "md5" }},
+		{name: "store_backend", modify: func(cfg *Config) { cfg.Storage.LaptopStore = "postgres" }},
+		{name: "trace_exporter", modify: func(cfg *Config) { cfg.Tracing.Exporter = "jaeger" }},
+		{name: "trace_file", modify: func(cfg *Config) { cfg.Tracing.Exporter, cfg.Tracing.File = "file", "" }},
+		{name: "log_level", modify: func(cfg *Config) { cfg.Log.Level = "verbose" }},
+		{name: "log_format", modify: func(cfg *Config) { cfg.Log.Format = "xml" }},
+		{name: "rate_limit_method", modify: func(cfg *Config) { cfg.RateLimit.Rules[0].Method = "CreateLaptop" }},
+		{name: "rate_limit_burst", modify: func(cfg *Config) { cfg.RateLimit.Rules[0].Burst = 0 }},
+		{name: "duplicate_rate_limit", modify: func(cfg *Config) { cfg.RateLimit.Rules = append(cfg.RateLimit.Rules, cfg.RateLimit.Rules[0]) }},
+		{name: "deadline_method", modify: func(cfg *Config) { cfg.Deadlines.Rules[1].Method = "UploadImage" }},
+		{name: "deadline_timeout", modify: func(cfg *Config) { cfg.Deadlines.Rules[0].Timeout = Duration(-time.Second) }},
+		{name: "duplicate_deadline", modify: func(cfg *Config) { cfg.Deadlines.Rules = append(cfg.Deadlines.Rules, cfg.Deadlines.Rules[0]) }},
+		{name: "duplicate_user", modify: func(cfg *Config) { cfg.Users = append(cfg.Users, cfg.Users[0]) }},
+		{name: "user_tenant", modify: func(cfg *Config) { cfg.Users[0].TenantID = "../acme" }},
+	}
+
+	require.NoError(t, Default().Validate())
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := Default()
+			tc.modify(cfg)
+			require.Error(t, cfg.Validate())
+		})
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	t.Parallel()
+
+	cfg := Default()
+	cfg.Users = append(cfg.Users, UserConfig{Username: "nobody", Role: "user"})
+	redacted := cfg.Redacted()
+
+	require.Equal(t, RedactedValue, redacted.Auth.SecretKey)
+	for i, user := range redacted.Users {
+		require.Equal(t, cfg.Users[i].Username, user.Username)
+		if cfg.Users[i].Password != "" {
+			require.Equal(t, RedactedValue, user.Password)
+		} else {
+			require.Empty(t, user.Password)
+		}
+	}
+
+	// 原配置不受影响
+	require.Equal(t, "secret", cfg.Auth.SecretKey)
+	require.Equal(t, "123", cfg.Users[0].Password)
+}
+
+func TestRestartRequired(t *testing.T) {
+	t.Parallel()
+
+	oldConfig := Default()
+	newConfig := Default()
+	newConfig.Login.MaxFailures = 10
+	newConfig.Password.Hash = "argon2id"
+	require.Empty(t, RestartRequired(oldConfig, newConfig))
+
+	newConfig.Auth.TokenDuration = Duration(time.Hour)
+	newConfig.Server.TrustedProxies = []string{"10.0.0.1"}
+	require.ElementsMatch(t, []string{"server", "auth"}, RestartRequired(oldConfig, newConfig))
+}
+
+func writeTestConfig(t *testing.T, content string) string {
+	folder, err := ioutil.TempDir("", "pcbook-config")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(folder) })
+
+	filename := filepath.Join(folder, "config.json")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0600))
+	return filename
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the names of the environment variables that override the configuration.
+// The name of a setting is the path of its JSON keys in upper case, e.g. PCBOOK_AUTH_SECRET_KEY.
+const EnvPrefix = "PCBOOK"
+
+// applyEnv overrides the settings with the environment variables that are set.
+// Lists of strings are comma separated, lists of objects like users cannot be set from the environment.
+func applyEnv(config *Config, lookupEnv func(key string) (string, bool)) error {
+	return applyEnvToStruct(reflect.ValueOf(config).Elem(), EnvPrefix, lookupEnv)
+}
+
+func applyEnvToStruct(value reflect.Value, prefix string, lookupEnv func(key string) (string, bool)) error {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		name := prefix + "_" + strings.ToUpper(jsonName(field))
+
+		fieldValue := value.Field(i)
+		if fieldValue.Kind() == reflect.Struct {
+			err := applyEnvToStruct(fieldValue, name, lookupEnv)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		env, ok := lookupEnv(name)
+		if !ok {
+			continue
+		}
+		err := setValue(fieldValue, env)
+		if err != nil {
+			return fmt.Errorf("invalid environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setValue parses the string into the value according to its type
+func setValue(value reflect.Value, text string) error {
+	if setter, ok := value.Addr().Interface().(interface{ Set(string) error }); ok {
+		return setter.Set(text)
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(text, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(text, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(parsed)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("%s cannot be set from text", value.Type())
+		}
+		items := []string{}
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s cannot be set from text", value.Type())
+	}
+	return nil
+}
+
+// jsonName returns the key of the field in the config file
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
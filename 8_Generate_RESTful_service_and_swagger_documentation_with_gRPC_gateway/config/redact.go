@@ -0,0 +1,56 @@
+package config
+
+import (
+	"reflect"
+)
+
+// RedactedValue replaces the value of secret settings when the configuration is printed
+const RedactedValue = "REDACTED"
+
+// Redacted returns a copy of the configuration with the secrets, such as keys and passwords, replaced
+func (config *Config) Redacted() *Config {
+	other := config.Clone()
+	redact(reflect.ValueOf(other).Elem())
+	return other
+}
+
+func redact(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Struct:
+		valueType := value.Type()
+		for i := 0; i < valueType.NumField(); i++ {
+			fieldValue := value.Field(i)
+			if valueType.Field(i).Tag.Get("secret") == "true" && fieldValue.Kind() == reflect.String {
+				// 空值不需要隐藏，保留它可以看出没有配置
+				if fieldValue.String() != "" {
+					fieldValue.SetString(RedactedValue)
+				}
+				continue
+			}
+			redact(fieldValue)
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			redact(value.Index(i))
+		}
+	}
+}
+
+// RestartRequired returns the JSON keys of the changed sections that cannot be reloaded without a restart
+func RestartRequired(oldConfig *Config, newConfig *Config) []string {
+	changed := []string{}
+
+	oldValue := reflect.ValueOf(oldConfig).Elem()
+	newValue := reflect.ValueOf(newConfig).Elem()
+	configType := oldValue.Type()
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		if field.Tag.Get("reload") == "true" {
+			continue
+		}
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			changed = append(changed, jsonName(field))
+		}
+	}
+	return changed
+}
@@ -0,0 +1,253 @@
+package config
+
+import (
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"pcbook/logging"
+	"pcbook/service"
+	"time"
+)
+
+// Validate checks if the configuration is complete and consistent
+func (config *Config) Validate() error {
+	checks := []func() error{
+		config.Server.validate,
+		config.TLS.validate,
+		config.Auth.validate,
+		config.Login.validate,
+		config.RateLimit.validate,
+		config.Deadlines.validate,
+		config.Password.validate,
+		config.Storage.validate,
+		config.Tracing.validate,
+		config.Log.validate,
+		config.validateUsers,
+	}
+	for _, check := range checks {
+		err := check()
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	}
+	return nil
+}
+
+func (server *ServerConfig) validate() error {
+	if server.Port < 0 || server.Port > 65535 {
+		return fmt.Errorf("server.port %d is out of range", server.Port)
+	}
+	if server.MetricsPort < 0 || server.MetricsPort > 65535 {
+		return fmt.Errorf("server.metrics_port %d is out of range", server.MetricsPort)
+	}
+	if server.MetricsPort != 0 && server.MetricsPort == server.Port {
+		return fmt.Errorf("server.metrics_port must differ from server.port")
+	}
+
+	switch server.Type {
+	case "grpc", "combined":
+	case "rest":
+		if server.Endpoint == "" {
+			return fmt.Errorf("server.endpoint is required for the rest server")
+		}
+	default:
+		return fmt.Errorf("server.type must be grpc, rest or combined, not %q", server.Type)
+	}
+
+	if server.DrainTimeout <= 0 {
+		return fmt.Errorf("server.drain_timeout must be positive")
+	}
+	if server.HealthCheckInterval <= 0 {
+		return fmt.Errorf("server.health_check_interval must be positive")
+	}
+
+	_, err := service.ParseTrustedProxies(server.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("server.trusted_proxies: %w", err)
+	}
+	return nil
+}
+
+func (tls *TLSConfig) validate() error {
+	if !tls.Enabled {
+		return nil
+	}
+
+	if tls.ServerCert == "" || tls.ServerKey == "" {
+		return fmt.Errorf("tls.server_cert and tls.server_key are required when TLS is enabled")
+	}
+	switch tls.ClientAuth {
+	case ClientAuthNone:
+	case ClientAuthVerifyIfGiven, ClientAuthRequire:
+		if tls.CACert == "" {
+			return fmt.Errorf("tls.ca_cert is required to verify client certificates")
+		}
+	default:
+		return fmt.Errorf("tls.client_auth must be %s, %s or %s, not %q", ClientAuthNone, ClientAuthVerifyIfGiven, ClientAuthRequire, tls.ClientAuth)
+	}
+	return nil
+}
+
+func (auth *AuthConfig) validate() error {
+	if auth.JWTKeys == "" && auth.SecretKey == "" {
+		return fmt.Errorf("auth.secret_key is required without auth.jwt_keys")
+	}
+	if auth.TokenDuration <= 0 {
+		return fmt.Errorf("auth.token_duration must be positive")
+	}
+	if auth.PolicyFile == "" {
+		return fmt.Errorf("auth.policy_file is required")
+	}
+	if auth.PolicyReloadInterval <= 0 {
+		return fmt.Errorf("auth.policy_reload_interval must be positive")
+	}
+	return nil
+}
+
+func (login *LoginConfig) validate() error {
+	if login.MaxFailures <= 0 {
+		return fmt.Errorf("login.max_failures must be positive")
+	}
+	if login.BaseDelay < 0 || login.MaxDelay < login.BaseDelay {
+		return fmt.Errorf("login.base_delay must not be negative or greater than login.max_delay")
+	}
+	if login.LockoutDuration <= 0 {
+		return fmt.Errorf("login.lockout_duration must be positive")
+	}
+	return nil
+}
+
+func (rateLimit *RateLimitConfig) validate() error {
+	_, err := rateLimit.Policy()
+	if err != nil {
+		return fmt.Errorf("rate_limit.rules: %w", err)
+	}
+	return nil
+}
+
+// Policy returns the rate limit policy of the rules
+func (rateLimit *RateLimitConfig) Policy() (*service.RateLimitPolicy, error) {
+	rules := make([]service.RateLimitRule, 0, len(rateLimit.Rules))
+	for _, rule := range rateLimit.Rules {
+		rules = append(rules, service.RateLimitRule{
+			Method:     rule.Method,
+			Role:       rule.Role,
+			Rate:       rule.Rate,
+			Burst:      rule.Burst,
+			MaxStreams: rule.MaxStreams,
+		})
+	}
+	return service.NewRateLimitPolicy(rules)
+}
+
+func (deadlines *DeadlineConfig) validate() error {
+	_, err := deadlines.Policy()
+	if err != nil {
+		return fmt.Errorf("deadlines.rules: %w", err)
+	}
+	return nil
+}
+
+// Policy returns the deadline policy of the rules
+func (deadlines *DeadlineConfig) Policy() (*service.DeadlinePolicy, error) {
+	rules := make([]service.DeadlineRule, 0, len(deadlines.Rules))
+	for _, rule := range deadlines.Rules {
+		rules = append(rules, service.DeadlineRule{
+			Method:      rule.Method,
+			Timeout:     time.Duration(rule.Timeout),
+			IdleTimeout: time.Duration(rule.IdleTimeout),
+			MaxMessages: rule.MaxMessages,
+		})
+	}
+	return service.NewDeadlinePolicy(rules)
+}
+
+func (password *PasswordConfig) validate() error {
+	switch password.Hash {
+	case "bcrypt":
+		if password.BcryptCost < bcrypt.MinCost || password.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("password.bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case "argon2id":
+		params := password.Argon2id
+		if params.Time == 0 || params.Memory == 0 || params.Threads == 0 {
+			return fmt.Errorf("password.argon2id time, memory and threads must be positive")
+		}
+		if params.SaltLength < 8 || params.KeyLength < 16 {
+			return fmt.Errorf("password.argon2id salt_length must be at least 8 and key_length at least 16")
+		}
+	default:
+		return fmt.Errorf("password.hash must be bcrypt or argon2id, not %q", password.Hash)
+	}
+	return nil
+}
+
+func (storage *StorageConfig) validate() error {
+	// 目前只有内存中的存储，将来增加其他实现时在这里放开
+	memoryStores := map[string]string{
+		"storage.user_store":    storage.UserStore,
+		"storage.session_store": storage.SessionStore,
+		"storage.api_key_store": storage.APIKeyStore,
+		"storage.laptop_store":  storage.LaptopStore,
+		"storage.rating_store":  storage.RatingStore,
+	}
+	for name, backend := range memoryStores {
+		if backend != StoreMemory {
+			return fmt.Errorf("%s must be %s, not %q", name, StoreMemory, backend)
+		}
+	}
+
+	if storage.ImageStore != StoreDisk {
+		return fmt.Errorf("storage.image_store must be %s, not %q", StoreDisk, storage.ImageStore)
+	}
+	if storage.ImageFolder == "" {
+		return fmt.Errorf("storage.image_folder is required")
+	}
+	if storage.AuditLog == "" {
+		return fmt.Errorf("storage.audit_log is required")
+	}
+	return nil
+}
+
+func (tracing *TracingConfig) validate() error {
+	switch tracing.Exporter {
+	case TraceExporterNone, TraceExporterStdout:
+	case TraceExporterFile:
+		if tracing.File == "" {
+			return fmt.Errorf("tracing.file is required for the file exporter")
+		}
+	default:
+		return fmt.Errorf("tracing.exporter must be none, stdout or file, not %q", tracing.Exporter)
+	}
+	return nil
+}
+
+func (log *LogConfig) validate() error {
+	_, err := logging.ParseLevel(log.Level)
+	if err != nil {
+		return fmt.Errorf("log.level: %w", err)
+	}
+	_, err = logging.ParseFormat(log.Format)
+	if err != nil {
+		return fmt.Errorf("log.format: %w", err)
+	}
+	return nil
+}
+
+func (config *Config) validateUsers() error {
+	usernames := make(map[string]bool)
+	for i, user := range config.Users {
+		if user.Username == "" || user.Password == "" || user.Role == "" {
+			return fmt.Errorf("users[%d] needs a username, password and role", i)
+		}
+		if usernames[user.Username] {
+			return fmt.Errorf("users[%d]: duplicate username %q", i, user.Username)
+		}
+		usernames[user.Username] = true
+
+		err := service.CheckTenantID(user.TenantID)
+		if err != nil {
+			return fmt.Errorf("users[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is the severity of a log line, the lines below the level of the logger are dropped
+type Level int32
+
+// Levels from the most to the least verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+// String returns the name of the level as written in the log lines
+func (level Level) String() string {
+	if name, ok := levelNames[level]; ok {
+		return name
+	}
+	return fmt.Sprintf("level(%d)", int32(level))
+}
+
+// ParseLevel parses the name of a level, case insensitively, warning is accepted for warn
+func ParseLevel(text string) (Level, error) {
+	name := strings.ToLower(strings.TrimSpace(text))
+	if name == "warning" {
+		return LevelWarn, nil
+	}
+	for level, levelName := range levelNames {
+		if name == levelName {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, must be debug, info, warn or error", text)
+}
+
+// Format is the encoding of the log lines
+type Format string
+
+// Formats of the log lines
+const (
+	FormatLogfmt Format = "logfmt" // key=value，便于人阅读
+	FormatJSON   Format = "json"   // 每行一个JSON对象，便于日志系统解析
+)
+
+// ParseFormat parses the name of a format
+func ParseFormat(text string) (Format, error) {
+	switch format := Format(strings.ToLower(strings.TrimSpace(text))); format {
+	case FormatLogfmt, FormatJSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown log format %q, must be logfmt or json", text)
+}
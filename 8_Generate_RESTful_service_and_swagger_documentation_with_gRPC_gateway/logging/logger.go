@@ -0,0 +1,263 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// timeFormat is the format of the time of the log lines, with milliseconds
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Keys written before the fields of each line
+const (
+	TimeKey    = "time"
+	LevelKey   = "level"
+	MessageKey = "msg"
+)
+
+// Logger writes leveled, structured log lines.
+// The fields are key-value pairs, such as "laptop_id", id, added to the lines of the logger and of the loggers derived with With.
+// A logger and the loggers derived from it share the writer and the level.
+type Logger struct {
+	output *output
+	fields []interface{}
+}
+
+// output is the part shared by a logger and the loggers derived from it
+type output struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	format Format
+	level  int32 // 原子访问，运行时可以修改
+}
+
+// New returns a new logger that writes the lines of the level and above to the writer
+func New(writer io.Writer, format Format, level Level) *Logger {
+	return &Logger{
+		output: &output{
+			writer: writer,
+			format: format,
+			level:  int32(level),
+		},
+	}
+}
+
+// With returns a logger that adds the key-value pairs to each line, the keys should be snake_case strings
+func (logger *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(logger.fields)+len(keyvals))
+	fields = append(fields, logger.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{output: logger.output, fields: fields}
+}
+
+// Level returns the current level of the logger
+func (logger *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&logger.output.level))
+}
+
+// SetLevel changes the level of the logger and of all the loggers sharing its output
+func (logger *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&logger.output.level, int32(level))
+}
+
+// Enabled returns true if the lines of the level are written
+func (logger *Logger) Enabled(level Level) bool {
+	return level >= logger.Level()
+}
+
+// Debug writes a line for developers, it is dropped at the default level
+func (logger *Logger) Debug(msg string, keyvals ...interface{}) {
+	logger.log(LevelDebug, msg, keyvals)
+}
+
+// Info writes a line about the normal operation of the service
+func (logger *Logger) Info(msg string, keyvals ...interface{}) {
+	logger.log(LevelInfo, msg, keyvals)
+}
+
+// Warn writes a line about a problem that the service can handle, such as an invalid request
+func (logger *Logger) Warn(msg string, keyvals ...interface{}) {
+	logger.log(LevelWarn, msg, keyvals)
+}
+
+// Error writes a line about a failure of the service
+func (logger *Logger) Error(msg string, keyvals ...interface{}) {
+	logger.log(LevelError, msg, keyvals)
+}
+
+// Log writes a line of the level, when the level depends on the outcome, such as the status code of an RPC
+func (logger *Logger) Log(level Level, msg string, keyvals ...interface{}) {
+	logger.log(level, msg, keyvals)
+}
+
+// Fatal writes an error line and exits, it is only used by the commands at startup
+func (logger *Logger) Fatal(msg string, keyvals ...interface{}) {
+	logger.log(LevelError, msg, keyvals)
+	os.Exit(1)
+}
+
+func (logger *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !logger.Enabled(level) {
+		return
+	}
+
+	pairs := make([]interface{}, 0, 6+len(logger.fields)+len(keyvals))
+	pairs = append(pairs, TimeKey, time.Now().Format(timeFormat), LevelKey, level.String(), MessageKey, msg)
+	pairs = append(pairs, logger.fields...)
+	pairs = append(pairs, keyvals...)
+	if len(pairs)%2 != 0 {
+		// 缺少值的键，不丢弃它，方便发现错误的调用
+		pairs = append(pairs, nil)
+	}
+
+	var line bytes.Buffer
+	if logger.output.format == FormatJSON {
+		encodeJSON(&line, pairs)
+	} else {
+		encodeLogfmt(&line, pairs)
+	}
+
+	logger.output.mutex.Lock()
+	defer logger.output.mutex.Unlock()
+	logger.output.writer.Write(line.Bytes())
+}
+
+// StdWriter returns a writer that logs each write as a line of the level,
+// so that the standard log package, used by some libraries, writes through the logger
+func (logger *Logger) StdWriter(level Level) io.Writer {
+	return stdWriter{logger: logger, level: level}
+}
+
+type stdWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (writer stdWriter) Write(data []byte) (int, error) {
+	writer.logger.log(writer.level, strings.TrimRight(string(data), "\n"), nil)
+	return len(data), nil
+}
+
+func encodeJSON(line *bytes.Buffer, pairs []interface{}) {
+	line.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		key, _ := json.Marshal(keyString(pairs[i]))
+		line.Write(key)
+		line.WriteByte(':')
+
+		value, err := json.Marshal(normalize(pairs[i+1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(pairs[i+1]))
+		}
+		line.Write(value)
+	}
+	line.WriteString("}\n")
+}
+
+func encodeLogfmt(line *bytes.Buffer, pairs []interface{}) {
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(keyString(pairs[i]))
+		line.WriteByte('=')
+
+		value := normalize(pairs[i+1])
+		if value == nil {
+			continue
+		}
+		text := fmt.Sprint(value)
+		if needsQuote(text) {
+			text = strconv.Quote(text)
+		}
+		line.WriteString(text)
+	}
+	line.WriteByte('\n')
+}
+
+// keyString returns the key as a string without spaces, '=' or quotes, which would break logfmt
+func keyString(key interface{}) string {
+	text, ok := key.(string)
+	if !ok {
+		text = fmt.Sprint(key)
+	}
+	text = strings.Map(func(r rune) rune {
+		if r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return '_'
+		}
+		return r
+	}, text)
+	if text == "" {
+		return "_"
+	}
+	return text
+}
+
+// normalize converts the errors and the values that have a String method, such as durations, to text
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+func needsQuote(text string) bool {
+	if text == "" {
+		return true
+	}
+	for _, r := range text {
+		if r == '=' || r == '"' || r == '\\' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+type loggerKey struct{}
+
+// NewContext returns a new context that carries the logger, such as a logger with the request ID
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger of the context, or the default logger
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return logger
+	}
+	return Default()
+}
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(New(os.Stderr, FormatLogfmt, LevelInfo))
+}
+
+// Default returns the logger of the process, it writes logfmt to stderr until SetDefault is called
+func Default() *Logger {
+	return defaultLogger.Load().(*Logger)
+}
+
+// SetDefault replaces the logger of the process, it should be called at startup
+func SetDefault(logger *Logger) {
+	defaultLogger.Store(logger)
+}
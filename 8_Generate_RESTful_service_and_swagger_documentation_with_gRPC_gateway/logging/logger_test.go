@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggerFormats(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		format Format
+		want   string
+	}{
+		{
+			name:   "logfmt",
+			format: FormatLogfmt,
+			want:   `level=warn msg="cannot save laptop" request_id=abc laptop_id=1 error="disk is full" retry_in=1.5s quote="say \"hi\"" empty="" missing=`,
+		},
+		{
+			name:   "json",
+			format: FormatJSON,
+			want:   `"level":"warn","msg":"cannot save laptop","request_id":"abc","laptop_id":1,"error":"disk is full","retry_in":"1.5s","quote":"say \"hi\"","empty":"","missing":null}`,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var buffer bytes.Buffer
+			logger := New(&buffer, tc.format, LevelInfo).With("request_id", "abc")
+			logger.Warn(
+				"cannot save laptop",
+				"laptop_id", 1,
+				"error", errors.New("disk is full"),
+				"retry_in", 1500*time.Millisecond,
+				"quote", `say "hi"`,
+				"empty", "",
+				"missing",
+			)
+
+			line := buffer.String()
+			require.True(t, strings.HasSuffix(line, tc.want+"\n"), line)
+			if tc.format == FormatJSON {
+				fields := make(map[string]interface{})
+				require.NoError(t, json.Unmarshal(buffer.Bytes(), &fields))
+				require.NotEmpty(t, fields[TimeKey])
+			} else {
+				require.True(t, strings.HasPrefix(line, "time="), line)
+			}
+		})
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	t.Parallel()
+
+	var buffer bytes.Buffer
+	logger := New(&buffer, FormatLogfmt, LevelInfo)
+	requestLogger := logger.With("request_id", "abc")
+
+	requestLogger.Debug("dropped")
+	require.Zero(t, buffer.Len())
+
+	// 派生的日志器共享级别
+	logger.SetLevel(LevelDebug)
+	require.Equal(t, LevelDebug, requestLogger.Level())
+	requestLogger.Debug("written")
+	require.Contains(t, buffer.String(), "msg=written request_id=abc")
+
+	buffer.Reset()
+	logger.SetLevel(LevelError)
+	requestLogger.Warn("dropped")
+	requestLogger.Log(LevelError, "written")
+	require.Contains(t, buffer.String(), "level=error msg=written")
+	require.NotContains(t, buffer.String(), "dropped")
+}
+
+func TestParseLevel(t *testing.T) {
+	t.Parallel()
+
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		parsed, err := ParseLevel(strings.ToUpper(level.String()))
+		require.NoError(t, err)
+		require.Equal(t, level, parsed)
+	}
+
+	level, err := ParseLevel("warning")
+	require.NoError(t, err)
+	require.Equal(t, LevelWarn, level)
+
+	_, err = ParseLevel("verbose")
+	require.Error(t, err)
+}
+
+func TestLoggerContext(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, Default(), FromContext(context.Background()))
+
+	var buffer bytes.Buffer
+	logger := New(&buffer, FormatLogfmt, LevelInfo)
+	ctx := NewContext(context.Background(), logger)
+	require.Equal(t, logger, FromContext(ctx))
+
+	// 标准库log的输出也成为结构化日志
+	stdLogger := log.New(logger.StdWriter(LevelWarn), "", 0)
+	stdLogger.Printf("library message")
+	require.Contains(t, buffer.String(), `level=warn msg="library message"`+"\n")
+}
@@ -0,0 +1,296 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"pcbook/logging"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Path is where the metrics are served
+const Path = "/metrics"
+
+// ContentType is the content type of the Prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds of the latency histograms in seconds, the same as the Prometheus client
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics of the server and writes them in the Prometheus text format.
+// It only supports what the server records: the label values are method names and status codes, which need no escaping.
+type Registry struct {
+	mutex   sync.Mutex
+	writers []writeFunc
+}
+
+// writeFunc writes the samples of a metric
+type writeFunc func(w *bufio.Writer)
+
+// NewRegistry returns a new empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) add(name string, help string, metricType string, write writeFunc) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.writers = append(registry.writers, func(w *bufio.Writer) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+		write(w)
+	})
+}
+
+// NewCounterVec registers a new counter with the labels
+func (registry *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	vec := newVec(name, labels, nil)
+	registry.add(name, help, "counter", vec.writeValues)
+	return &CounterVec{vec: vec}
+}
+
+// NewGaugeVec registers a new gauge with the labels
+func (registry *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	vec := newVec(name, labels, nil)
+	registry.add(name, help, "gauge", vec.writeValues)
+	return &GaugeVec{vec: vec}
+}
+
+// NewHistogramVec registers a new histogram with the increasing upper bounds of the buckets and the labels
+func (registry *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	vec := newVec(name, labels, buckets)
+	registry.add(name, help, "histogram", vec.writeHistograms)
+	return &HistogramVec{vec: vec}
+}
+
+// NewGaugeFunc registers a gauge whose value is read from the function when the metrics are written
+func (registry *Registry) NewGaugeFunc(name string, help string, value func() float64) {
+	registry.add(name, help, "gauge", func(w *bufio.Writer) {
+		writeSample(w, name, "", value())
+	})
+}
+
+// WriteText writes all metrics in the Prometheus text format, in the order they were registered
+func (registry *Registry) WriteText(writer io.Writer) error {
+	registry.mutex.Lock()
+	writers := append([]writeFunc(nil), registry.writers...)
+	registry.mutex.Unlock()
+
+	w := bufio.NewWriter(writer)
+	for _, write := range writers {
+		write(w)
+	}
+	return w.Flush()
+}
+
+// Handler returns an HTTP handler that serves the metrics to Prometheus
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		err := registry.WriteText(w)
+		if err != nil {
+			logging.Default().Error("cannot write metrics", "error", err)
+		}
+	})
+}
+
+// vec is a metric with a series for each combination of label values
+type vec struct {
+	name    string
+	labels  []string
+	buckets []float64 // 直方图区间的上界
+
+	mutex  sync.Mutex
+	series map[string]*series // 键为格式化后的标签，如 method="/a",code="OK"
+}
+
+// series is the value of a metric for some label values
+type series struct {
+	value   float64  // 计数器或仪表的值
+	buckets []uint64 // 直方图每个区间的计数，不累加
+	sum     float64
+	count   uint64
+}
+
+func newVec(name string, labels []string, buckets []float64) *vec {
+	return &vec{
+		name:    name,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+}
+
+// key formats the labels of the series, it must be called with the mutex locked
+func (vec *vec) key(labelValues []string) string {
+	if len(labelValues) != len(vec.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", vec.name, len(vec.labels), len(labelValues)))
+	}
+
+	pairs := make([]string, len(labelValues))
+	for i, value := range labelValues {
+		pairs[i] = vec.labels[i] + `="` + value + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+// get returns the series of the label values, it must be called with the mutex locked
+func (vec *vec) get(labelValues []string) *series {
+	key := vec.key(labelValues)
+	s, ok := vec.series[key]
+	if !ok {
+		s = &series{buckets: make([]uint64, len(vec.buckets))}
+		vec.series[key] = s
+	}
+	return s
+}
+
+// lookup returns the series of the label values without creating it, so that reading a value does not add a series.
+// It must be called with the mutex locked.
+func (vec *vec) lookup(labelValues []string) *series {
+	if s, ok := vec.series[vec.key(labelValues)]; ok {
+		return s
+	}
+	return &series{}
+}
+
+// sortedKeys returns the keys of the series in order, so that the output is stable.
+// It must be called with the mutex locked.
+func (vec *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(vec.series))
+	for key := range vec.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (vec *vec) writeValues(w *bufio.Writer) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	for _, key := range vec.sortedKeys() {
+		writeSample(w, vec.name, key, vec.series[key].value)
+	}
+}
+
+func (vec *vec) writeHistograms(w *bufio.Writer) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	for _, key := range vec.sortedKeys() {
+		s := vec.series[key]
+		prefix := key
+		if prefix != "" {
+			prefix += ","
+		}
+
+		// 文本格式中的区间计数是累加的
+		var cumulative uint64
+		for i, upperBound := range vec.buckets {
+			cumulative += s.buckets[i]
+			writeSample(w, vec.name+"_bucket", prefix+`le="`+formatFloat(upperBound)+`"`, float64(cumulative))
+		}
+		writeSample(w, vec.name+"_bucket", prefix+`le="+Inf"`, float64(s.count))
+		writeSample(w, vec.name+"_sum", key, s.sum)
+		writeSample(w, vec.name+"_count", key, float64(s.count))
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	w.WriteString(name + " " + formatFloat(value) + "\n")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// CounterVec is a counter with labels, it can only go up
+type CounterVec struct {
+	vec *vec
+}
+
+// Add adds a non-negative value to the counter of the label values
+func (counter *CounterVec) Add(value float64, labelValues ...string) {
+	counter.vec.mutex.Lock()
+	defer counter.vec.mutex.Unlock()
+
+	counter.vec.get(labelValues).value += value
+}
+
+// Inc adds one to the counter of the label values
+func (counter *CounterVec) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+// Value returns the counter of the label values
+func (counter *CounterVec) Value(labelValues ...string) float64 {
+	counter.vec.mutex.Lock()
+	defer counter.vec.mutex.Unlock()
+
+	return counter.vec.lookup(labelValues).value
+}
+
+// GaugeVec is a gauge with labels, it can go up and down
+type GaugeVec struct {
+	vec *vec
+}
+
+// Add adds the value, which may be negative, to the gauge of the label values
+func (gauge *GaugeVec) Add(value float64, labelValues ...string) {
+	gauge.vec.mutex.Lock()
+	defer gauge.vec.mutex.Unlock()
+
+	gauge.vec.get(labelValues).value += value
+}
+
+// Value returns the gauge of the label values
+func (gauge *GaugeVec) Value(labelValues ...string) float64 {
+	gauge.vec.mutex.Lock()
+	defer gauge.vec.mutex.Unlock()
+
+	return gauge.vec.lookup(labelValues).value
+}
+
+// HistogramVec counts the observed values, such as latencies, in buckets, with labels
+type HistogramVec struct {
+	vec *vec
+}
+
+// Observe adds a value to the histogram of the label values
+func (histogram *HistogramVec) Observe(value float64, labelValues ...string) {
+	histogram.vec.mutex.Lock()
+	defer histogram.vec.mutex.Unlock()
+
+	s := histogram.vec.get(labelValues)
+	// 大于所有上界的值只计入+Inf
+	index := sort.SearchFloat64s(histogram.vec.buckets, value)
+	if index < len(histogram.vec.buckets) {
+		s.buckets[index]++
+	}
+	s.sum += value
+	s.count++
+}
+
+// Count returns the number of values observed for the label values
+func (histogram *HistogramVec) Count(labelValues ...string) uint64 {
+	histogram.vec.mutex.Lock()
+	defer histogram.vec.mutex.Unlock()
+
+	return histogram.vec.lookup(labelValues).count
+}
+
+// Sum returns the sum of the values observed for the label values
+func (histogram *HistogramVec) Sum(labelValues ...string) float64 {
+	histogram.vec.mutex.Lock()
+	defer histogram.vec.mutex.Unlock()
+
+	return histogram.vec.lookup(labelValues).sum
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	t.Parallel()
+
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("test_requests_total", "Number of requests.", "method", "code")
+	active := registry.NewGaugeVec("test_active", "Requests in progress.", "method")
+	duration := registry.NewHistogramVec("test_duration_seconds", "Latency.", []float64{0.1, 1}, "method")
+	registry.NewGaugeFunc("test_size", "Size of the store.", func() float64 { return 42 })
+
+	requests.Inc("/b", "OK")
+	requests.Add(2, "/a", "OK")
+	requests.Inc("/a", "NotFound")
+	active.Add(3, "/a")
+	active.Add(-1, "/a")
+	duration.Observe(0.05, "/a")
+	duration.Observe(0.1, "/a")
+	duration.Observe(0.5, "/a")
+	duration.Observe(7, "/a")
+
+	// 读取不存在的值不会增加输出
+	require.Equal(t, 0.0, requests.Value("/c", "OK"))
+	require.Equal(t, 2.0, requests.Value("/a", "OK"))
+	require.Equal(t, 2.0, active.Value("/a"))
+	require.EqualValues(t, 4, duration.Count("/a"))
+	require.Equal(t, 7.65, duration.Sum("/a"))
+
+	var buffer bytes.Buffer
+	require.NoError(t, registry.WriteText(&buffer))
+	require.Equal(t, `# HELP test_requests_total Number of requests.
+# TYPE test_requests_total counter
+test_requests_total{method="/a",code="NotFound"} 1
+test_requests_total{method="/a",code="OK"} 2
+test_requests_total{method="/b",code="OK"} 1
+# HELP test_active Requests in progress.
+# TYPE test_active gauge
+test_active{method="/a"} 2
+# HELP test_duration_seconds Latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{method="/a",le="0.1"} 2
+test_duration_seconds_bucket{method="/a",le="1"} 3
+test_duration_seconds_bucket{method="/a",le="+Inf"} 4
+test_duration_seconds_sum{method="/a"} 7.65
+test_duration_seconds_count{method="/a"} 4
+# HELP test_size Size of the store.
+# TYPE test_size gauge
+test_size 42
+`, buffer.String())
+}
+
+func TestRegistryHandler(t *testing.T) {
+	t.Parallel()
+
+	registry := NewRegistry()
+	registry.NewCounterVec("test_total", "Test.").Inc()
+	handler := registry.Handler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, Path, nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, ContentType, recorder.Header().Get("Content-Type"))
+	require.Contains(t, recorder.Body.String(), "test_total 1\n")
+}
@@ -0,0 +1,193 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.23.0
+// 	protoc        v3.12.4
+// source: error_msg.proto
+
+package pb
+
+import (
+	proto "github.com/golang/protobuf/proto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// This is a compile-time assertion that a sufficiently up-to-date version
+// of the legacy proto package is being used.
+const _ = proto.ProtoPackageIsVersion4
+
+// ErrorReason is the reason of the google.rpc.ErrorInfo detail of the errors, in the domain "pcbook".
+// The reasons are stable, the clients check them instead of parsing the messages.
+type ErrorReason int32
+
+const (
+	ErrorReason_ERROR_REASON_UNSPECIFIED ErrorReason = 0
+	ErrorReason_INVALID_LAPTOP_ID        ErrorReason = 1
+	ErrorReason_INVALID_TENANT           ErrorReason = 2
+	ErrorReason_TENANT_REQUIRED          ErrorReason = 3 // 超级管理员创建笔记本时需要选择租户
+	ErrorReason_LAPTOP_NOT_FOUND         ErrorReason = 4
+	ErrorReason_LAPTOP_ALREADY_EXISTS    ErrorReason = 5
+	ErrorReason_LOGIN_REQUIRED           ErrorReason = 6
+	ErrorReason_NOT_LAPTOP_OWNER         ErrorReason = 7
+	ErrorReason_IMAGE_TOO_LARGE          ErrorReason = 8
+	ErrorReason_RATE_LIMIT_EXCEEDED      ErrorReason = 9
+	ErrorReason_TOO_MANY_STREAMS         ErrorReason = 10
+	ErrorReason_TOO_MANY_LOGIN_ATTEMPTS  ErrorReason = 11
+	ErrorReason_INVALID_FIELDS           ErrorReason = 12 // 请求违反了proto中的校验规则，BadRequest详情列出了所有字段
+	ErrorReason_STREAM_IDLE_TIMEOUT      ErrorReason = 13 // 流在空闲超时内没有收到消息
+	ErrorReason_TOO_MANY_MESSAGES        ErrorReason = 14 // 流收到的消息超过了上限
+)
+
+// Enum value maps for ErrorReason.
+var (
+	ErrorReason_name = map[int32]string{
+		0:  "ERROR_REASON_UNSPECIFIED",
+		1:  "INVALID_LAPTOP_ID",
+		2:  "INVALID_TENANT",
+		3:  "TENANT_REQUIRED",
+		4:  "LAPTOP_NOT_FOUND",
+		5:  "LAPTOP_ALREADY_EXISTS",
+		6:  "LOGIN_REQUIRED",
+		7:  "NOT_LAPTOP_OWNER",
+		8:  "IMAGE_TOO_LARGE",
+		9:  "RATE_LIMIT_EXCEEDED",
+		10: "TOO_MANY_STREAMS",
+		11: "TOO_MANY_LOGIN_ATTEMPTS",
+		12: "INVALID_FIELDS",
+		13: "STREAM_IDLE_TIMEOUT",
+		14: "TOO_MANY_MESSAGES",
+	}
+	ErrorReason_value = map[string]int32{
+		"ERROR_REASON_UNSPECIFIED": 0,
+		"INVALID_LAPTOP_ID":        1,
+		"INVALID_TENANT":           2,
+		"TENANT_REQUIRED":          3,
+		"LAPTOP_NOT_FOUND":         4,
+		"LAPTOP_ALREADY_EXISTS":    5,
+		"LOGIN_REQUIRED":           6,
+		"NOT_LAPTOP_OWNER":         7,
+		"IMAGE_TOO_LARGE":          8,
+		"RATE_LIMIT_EXCEEDED":      9,
+		"TOO_MANY_STREAMS":         10,
+		"TOO_MANY_LOGIN_ATTEMPTS":  11,
+		"INVALID_FIELDS":           12,
+		"STREAM_IDLE_TIMEOUT":      13,
+		"TOO_MANY_MESSAGES":        14,
+	}
+)
+
+func (x ErrorReason) Enum() *ErrorReason {
+	p := new(ErrorReason)
+	*p = x
+	return p
+}
+
+func (x ErrorReason) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ErrorReason) Descriptor() protoreflect.EnumDescriptor {
+	return file_error_msg_proto_enumTypes[0].Descriptor()
+}
+
+func (ErrorReason) Type() protoreflect.EnumType {
+	return &file_error_msg_proto_enumTypes[0]
+}
+
+func (x ErrorReason) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ErrorReason.Descriptor instead.
+func (ErrorReason) EnumDescriptor() ([]byte, []int) {
+	return file_error_msg_proto_rawDescGZIP(), []int{0}
+}
+
+var File_error_msg_proto protoreflect.FileDescriptor
+
+var file_error_msg_proto_rawDesc = []byte{
+	0x0a, 0x0f, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x5f, 0x6d, 0x73, 0x67, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x12, 0x0e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x70, 0x62, 0x66, 0x69, 0x6c, 0x65,
+	0x73, 0x2a, 0xeb, 0x02, 0x0a, 0x0b, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x52, 0x65, 0x61, 0x73, 0x6f,
+	0x6e, 0x12, 0x1c, 0x0a, 0x18, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f,
+	0x4e, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12,
+	0x15, 0x0a, 0x11, 0x49, 0x4e, 0x56, 0x41, 0x4c, 0x49, 0x44, 0x5f, 0x4c, 0x41, 0x50, 0x54, 0x4f,
+	0x50, 0x5f, 0x49, 0x44, 0x10, 0x01, 0x12, 0x12, 0x0a, 0x0e, 0x49, 0x4e, 0x56, 0x41, 0x4c, 0x49,
+	0x44, 0x5f, 0x54, 0x45, 0x4e, 0x41, 0x4e, 0x54, 0x10, 0x02, 0x12, 0x13, 0x0a, 0x0f, 0x54, 0x45,
+	0x4e, 0x41, 0x4e, 0x54, 0x5f, 0x52, 0x45, 0x51, 0x55, 0x49, 0x52, 0x45, 0x44, 0x10, 0x03, 0x12,
+	0x14, 0x0a, 0x10, 0x4c, 0x41, 0x50, 0x54, 0x4f, 0x50, 0x5f, 0x4e, 0x4f, 0x54, 0x5f, 0x46, 0x4f,
+	0x55, 0x4e, 0x44, 0x10, 0x04, 0x12, 0x19, 0x0a, 0x15, 0x4c, 0x41, 0x50, 0x54, 0x4f, 0x50, 0x5f,
+	0x41, 0x4c, 0x52, 0x45, 0x41, 0x44, 0x59, 0x5f, 0x45, 0x58, 0x49, 0x53, 0x54, 0x53, 0x10, 0x05,
+	0x12, 0x12, 0x0a, 0x0e, 0x4c, 0x4f, 0x47, 0x49, 0x4e, 0x5f, 0x52, 0x45, 0x51, 0x55, 0x49, 0x52,
+	0x45, 0x44, 0x10, 0x06, 0x12, 0x14, 0x0a, 0x10, 0x4e, 0x4f, 0x54, 0x5f, 0x4c, 0x41, 0x50, 0x54,
+	0x4f, 0x50, 0x5f, 0x4f, 0x57, 0x4e, 0x45, 0x52, 0x10, 0x07, 0x12, 0x13, 0x0a, 0x0f, 0x49, 0x4d,
+	0x41, 0x47, 0x45, 0x5f, 0x54, 0x4f, 0x4f, 0x5f, 0x4c, 0x41, 0x52, 0x47, 0x45, 0x10, 0x08, 0x12,
+	0x17, 0x0a, 0x13, 0x52, 0x41, 0x54, 0x45, 0x5f, 0x4c, 0x49, 0x4d, 0x49, 0x54, 0x5f, 0x45, 0x58,
+	0x43, 0x45, 0x45, 0x44, 0x45, 0x44, 0x10, 0x09, 0x12, 0x14, 0x0a, 0x10, 0x54, 0x4f, 0x4f, 0x5f,
+	0x4d, 0x41, 0x4e, 0x59, 0x5f, 0x53, 0x54, 0x52, 0x45, 0x41, 0x4d, 0x53, 0x10, 0x0a, 0x12, 0x1b,
+	0x0a, 0x17, 0x54, 0x4f, 0x4f, 0x5f, 0x4d, 0x41, 0x4e, 0x59, 0x5f, 0x4c, 0x4f, 0x47, 0x49, 0x4e,
+	0x5f, 0x41, 0x54, 0x54, 0x45, 0x4d, 0x50, 0x54, 0x53, 0x10, 0x0b, 0x12, 0x12, 0x0a, 0x0e, 0x49,
+	0x4e, 0x56, 0x41, 0x4c, 0x49, 0x44, 0x5f, 0x46, 0x49, 0x45, 0x4c, 0x44, 0x53, 0x10, 0x0c, 0x12,
+	0x17, 0x0a, 0x13, 0x53, 0x54, 0x52, 0x45, 0x41, 0x4d, 0x5f, 0x49, 0x44, 0x4c, 0x45, 0x5f, 0x54,
+	0x49, 0x4d, 0x45, 0x4f, 0x55, 0x54, 0x10, 0x0d, 0x12, 0x15, 0x0a, 0x11, 0x54, 0x4f, 0x4f, 0x5f,
+	0x4d, 0x41, 0x4e, 0x59, 0x5f, 0x4d, 0x45, 0x53, 0x53, 0x41, 0x47, 0x45, 0x53, 0x10, 0x0e, 0x42,
+	0x06, 0x5a, 0x04, 0x2e, 0x3b, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_error_msg_proto_rawDescOnce sync.Once
+	file_error_msg_proto_rawDescData = file_error_msg_proto_rawDesc
+)
+
+func file_error_msg_proto_rawDescGZIP() []byte {
+	file_error_msg_proto_rawDescOnce.Do(func() {
+		file_error_msg_proto_rawDescData = protoimpl.X.CompressGZIP(file_error_msg_proto_rawDescData)
+	})
+	return file_error_msg_proto_rawDescData
+}
+
+var file_error_msg_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_error_msg_proto_goTypes = []interface{}{
+	(ErrorReason)(0), // 0: pcbook.pbfiles.ErrorReason
+}
+var file_error_msg_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_error_msg_proto_init() }
+func file_error_msg_proto_init() {
+	if File_error_msg_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_error_msg_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   0,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_error_msg_proto_goTypes,
+		DependencyIndexes: file_error_msg_proto_depIdxs,
+		EnumInfos:         file_error_msg_proto_enumTypes,
+	}.Build()
+	File_error_msg_proto = out.File
+	file_error_msg_proto_rawDesc = nil
+	file_error_msg_proto_goTypes = nil
+	file_error_msg_proto_depIdxs = nil
+}
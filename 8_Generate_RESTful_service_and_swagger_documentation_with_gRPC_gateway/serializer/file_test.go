@@ -3,6 +3,9 @@ package serializer
 import (
 	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"pcbook/pb"
 	"pcbook/sample"
 	"testing"
@@ -11,11 +14,15 @@ import (
 func TestFileSerializer(t *testing.T) {
 	t.Parallel()
 
-	binaryFile := "../tmp/laptop.bin"
-	jsonFile := "../tmp/laptop.json"
+	folder, err := ioutil.TempDir("", "pcbook-serializer")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+
+	binaryFile := filepath.Join(folder, "laptop.bin")
+	jsonFile := filepath.Join(folder, "laptop.json")
 
 	laptop1 := sample.NewLaptop()
-	err := WriteProtobufToBinaryFile(laptop1, binaryFile)
+	err = WriteProtobufToBinaryFile(laptop1, binaryFile)
 	require.NoError(t, err)
 
 	laptop2 := &pb.Laptop{}
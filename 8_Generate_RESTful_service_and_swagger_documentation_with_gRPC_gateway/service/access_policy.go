@@ -0,0 +1,377 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"pcbook/logging"
+	"strings"
+	"time"
+)
+
+// Authentication methods an access rule can require
+const (
+	// AuthnAny accepts either a token (an access token or an API key) or a client certificate, it's the default
+	AuthnAny = "any"
+	// AuthnToken accepts only an access token or an API key
+	AuthnToken = "token"
+	// AuthnCert accepts only a verified client certificate
+	AuthnCert = "cert"
+	// AuthnBoth requires both a token and a client certificate whose roles are allowed
+	AuthnBoth = "both"
+)
+
+const (
+	// DefaultAccessPublic lets everyone call the methods that no rule matches
+	DefaultAccessPublic = "public"
+	// DefaultAccessDeny rejects the methods that no rule matches
+	DefaultAccessDeny = "deny"
+)
+
+// AccessPolicy is a role based access control policy for the RPC methods
+type AccessPolicy struct {
+	Default string                    `json:"default"`
+	Roles   map[string]RoleDefinition `json:"roles"`
+	Rules   []*AccessRule             `json:"rules"`
+	// ClientCertificates 按顺序匹配，第一个匹配的决定证书的角色
+	ClientCertificates []*ClientCertificate `json:"client_certificates"`
+
+	methodRules  map[string]*AccessRule
+	serviceRules map[string]*AccessRule
+	globalRule   *AccessRule
+	grantedRoles map[string]map[string]bool // 每个角色及其继承的所有角色
+}
+
+// RoleDefinition defines a role and the roles it inherits the permissions of
+type RoleDefinition struct {
+	Inherits []string `json:"inherits"`
+	// RequireTOTP 要求该角色（及继承它的角色）的用户启用两步验证
+	RequireTOTP bool `json:"require_totp"`
+}
+
+// AccessRule defines who can access the matched methods.
+// Method is a full method name like "/pcbook.pbfiles.LaptopService/CreateLaptop",
+// a service wildcard like "/pcbook.pbfiles.LaptopService/*" or "*" for all methods.
+// The most specific rule wins.
+// Authn is one of "any" (default), "token", "cert" and "both".
+type AccessRule struct {
+	Method string   `json:"method"`
+	Public bool     `json:"public"`
+	Roles  []string `json:"roles"`
+	Authn  string   `json:"authn"`
+}
+
+// AuthnMethod returns the authentication method required by the rule
+func (rule *AccessRule) AuthnMethod() string {
+	if rule == nil || rule.Authn == "" {
+		return AuthnAny
+	}
+	return rule.Authn
+}
+
+// LoadAccessPolicy loads an access policy from a JSON file
+func LoadAccessPolicy(filename string) (*AccessPolicy, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read access policy: %w", err)
+	}
+
+	policy, err := ParseAccessPolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access policy %s: %w", filename, err)
+	}
+	return policy, nil
+}
+
+// ParseAccessPolicy parses and checks an access policy in JSON format
+func ParseAccessPolicy(data []byte) (*AccessPolicy, error) {
+	policy := &AccessPolicy{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = policy.compile()
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (policy *AccessPolicy) compile() error {
+	if policy.Default != DefaultAccessPublic && policy.Default != DefaultAccessDeny {
+		return fmt.Errorf("default must be %q or %q, got %q", DefaultAccessPublic, DefaultAccessDeny, policy.Default)
+	}
+
+	policy.grantedRoles = make(map[string]map[string]bool)
+	for role := range policy.Roles {
+		granted := make(map[string]bool)
+		err := policy.collectRoles(role, granted, map[string]bool{})
+		if err != nil {
+			return err
+		}
+		policy.grantedRoles[role] = granted
+	}
+
+	for _, mapping := range policy.ClientCertificates {
+		err := mapping.check(policy.Roles)
+		if err != nil {
+			return err
+		}
+	}
+
+	policy.methodRules = make(map[string]*AccessRule)
+	policy.serviceRules = make(map[string]*AccessRule)
+	for _, rule := range policy.Rules {
+		err := policy.addRule(rule)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (policy *AccessPolicy) collectRoles(role string, granted map[string]bool, visiting map[string]bool) error {
+	definition, ok := policy.Roles[role]
+	if !ok {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	if visiting[role] {
+		return fmt.Errorf("role %q inherits itself", role)
+	}
+
+	visiting[role] = true
+	defer delete(visiting, role)
+
+	granted[role] = true
+	for _, inherited := range definition.Inherits {
+		err := policy.collectRoles(inherited, granted, visiting)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (policy *AccessPolicy) addRule(rule *AccessRule) error {
+	if rule.Public == (len(rule.Roles) > 0) {
+		return fmt.Errorf("rule %q must either be public or list some roles", rule.Method)
+	}
+	for _, role := range rule.Roles {
+		if _, ok := policy.Roles[role]; !ok {
+			return fmt.Errorf("rule %q refers to unknown role %q", rule.Method, role)
+		}
+	}
+	switch rule.Authn {
+	case "", AuthnAny, AuthnToken, AuthnCert, AuthnBoth:
+	default:
+		return fmt.Errorf("rule %q has unknown authn %q", rule.Method, rule.Authn)
+	}
+	if rule.Public && rule.Authn != "" {
+		return fmt.Errorf("public rule %q cannot require authn", rule.Method)
+	}
+
+	if rule.Method == "*" {
+		if policy.globalRule != nil {
+			return fmt.Errorf("duplicate rule %q", rule.Method)
+		}
+		policy.globalRule = rule
+		return nil
+	}
+
+	service, method, err := splitMethodName(rule.Method)
+	if err != nil {
+		return err
+	}
+
+	rules := policy.methodRules
+	key := rule.Method
+	if method == "*" {
+		rules = policy.serviceRules
+		key = service
+	}
+	if rules[key] != nil {
+		return fmt.Errorf("duplicate rule %q", rule.Method)
+	}
+	rules[key] = rule
+	return nil
+}
+
+// splitMethodName splits "/package.Service/Method" into service and method name
+func splitMethodName(fullMethod string) (string, string, error) {
+	parts := strings.Split(fullMethod, "/")
+	if len(parts) != 3 || parts[0] != "" || parts[1] == "" || parts[2] == "" {
+		return "", "", fmt.Errorf("invalid method %q, expect /package.Service/Method", fullMethod)
+	}
+	return parts[1], parts[2], nil
+}
+
+// Rule returns the most specific rule matching the full method name, or nil if no rule matches
+func (policy *AccessPolicy) Rule(fullMethod string) *AccessRule {
+	if rule := policy.methodRules[fullMethod]; rule != nil {
+		return rule
+	}
+	if service, _, err := splitMethodName(fullMethod); err == nil {
+		if rule := policy.serviceRules[service]; rule != nil {
+			return rule
+		}
+	}
+	return policy.globalRule
+}
+
+// IsPublic checks if the method can be called without authentication
+func (policy *AccessPolicy) IsPublic(fullMethod string) bool {
+	rule := policy.Rule(fullMethod)
+	if rule == nil {
+		return policy.Default == DefaultAccessPublic
+	}
+	return rule.Public
+}
+
+// Allows checks if a user with the role can call the method
+func (policy *AccessPolicy) Allows(role string, fullMethod string) bool {
+	rule := policy.Rule(fullMethod)
+	if rule == nil {
+		return policy.Default == DefaultAccessPublic
+	}
+	if rule.Public {
+		return true
+	}
+
+	granted := policy.grantedRoles[role]
+	for _, required := range rule.Roles {
+		if granted[required] {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchCertificate returns the first client certificate mapping that matches the certificate
+func (policy *AccessPolicy) MatchCertificate(cert *x509.Certificate) (*ClientCertificate, bool) {
+	for _, mapping := range policy.ClientCertificates {
+		if mapping.Matches(cert) {
+			return mapping, true
+		}
+	}
+	return nil, false
+}
+
+// RequiresTOTP checks if the role or any role it inherits requires two-factor authentication
+func (policy *AccessPolicy) RequiresTOTP(role string) bool {
+	for grantedRole := range policy.grantedRoles[role] {
+		if policy.Roles[grantedRole].RequireTOTP {
+			return true
+		}
+	}
+	return false
+}
+
+// DefinesRole checks if the role is defined by the policy
+func (policy *AccessPolicy) DefinesRole(role string) bool {
+	_, ok := policy.Roles[role]
+	return ok
+}
+
+// GrantedRoles returns the role and all the roles it inherits
+func (policy *AccessPolicy) GrantedRoles(role string) map[string]bool {
+	granted := make(map[string]bool, len(policy.grantedRoles[role]))
+	for grantedRole := range policy.grantedRoles[role] {
+		granted[grantedRole] = true
+	}
+	return granted
+}
+
+// CheckMethods makes sure every rule refers to a service or method registered on the gRPC server
+func (policy *AccessPolicy) CheckMethods(services map[string]grpc.ServiceInfo) error {
+	for _, rule := range policy.Rules {
+		if rule.Method == "*" {
+			continue
+		}
+
+		service, method, err := splitMethodName(rule.Method)
+		if err != nil {
+			return err
+		}
+
+		info, ok := services[service]
+		if !ok {
+			return fmt.Errorf("rule %q refers to unknown service %q", rule.Method, service)
+		}
+		if method == "*" || hasMethod(info, method) {
+			continue
+		}
+		return fmt.Errorf("rule %q refers to unknown method %q of service %q", rule.Method, method, service)
+	}
+	return nil
+}
+
+func hasMethod(info grpc.ServiceInfo, method string) bool {
+	for _, methodInfo := range info.Methods {
+		if methodInfo.Name == method {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchAccessPolicy reloads the policy file when its content changes and passes the new policy to apply.
+// A policy that cannot be loaded or fails the check is logged and ignored, so the previous one stays in effect.
+// It returns a function to stop watching.
+func WatchAccessPolicy(
+	filename string,
+	interval time.Duration,
+	check func(policy *AccessPolicy) error,
+	apply func(policy *AccessPolicy),
+) func() {
+	done := make(chan struct{})
+	lastSum := fileChecksum(filename)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			sum := fileChecksum(filename)
+			if sum == lastSum {
+				continue
+			}
+			lastSum = sum
+
+			policy, err := LoadAccessPolicy(filename)
+			if err == nil {
+				err = check(policy)
+			}
+			if err != nil {
+				logging.Default().Error("keep the current access policy, cannot reload", "file", filename, "error", err)
+				continue
+			}
+
+			apply(policy)
+			logging.Default().Info("access policy reloaded", "file", filename)
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func fileChecksum(filename string) [sha256.Size]byte {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(data)
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testAccessPolicy = `{
+	"default": "deny",
+	"roles": {
+		"user": {},
+		"editor": {"inherits": ["user"]},
+		"admin": {"inherits": ["editor"]}
+	},
+	"rules": [
+		{"method": "/pcbook.pbfiles.AuthService/Login", "public": true},
+		{"method": "/pcbook.pbfiles.LaptopService/*", "roles": ["user"]},
+		{"method": "/pcbook.pbfiles.LaptopService/CreateLaptop", "roles": ["editor"]},
+		{"method": "/pcbook.pbfiles.LaptopService/UploadImage", "roles": ["admin"]}
+	]
+}`
+
+func TestAccessPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := ParseAccessPolicy([]byte(testAccessPolicy))
+	require.NoError(t, err)
+
+	const laptopService = "/pcbook.pbfiles.LaptopService/"
+
+	testCases := []struct {
+		name    string
+		role    string
+		method  string
+		allowed bool
+	}{
+		{"public_method", "", "/pcbook.pbfiles.AuthService/Login", true},
+		{"deny_by_default", "admin", "/pcbook.pbfiles.AuthService/Logout", false},
+		{"service_wildcard", "user", laptopService + "SearchLaptop", true},
+		{"method_overrides_wildcard", "user", laptopService + "CreateLaptop", false},
+		{"direct_role", "editor", laptopService + "CreateLaptop", true},
+		{"inherited_role", "admin", laptopService + "CreateLaptop", true},
+		{"inherited_through_chain", "admin", laptopService + "RateLaptop", true},
+		{"child_role_not_granted", "editor", laptopService + "UploadImage", false},
+		{"unknown_role", "guest", laptopService + "SearchLaptop", false},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tc.allowed, policy.Allows(tc.role, tc.method))
+		})
+	}
+}
+
+func TestAccessPolicyInvalid(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		policy string
+	}{
+		{"missing_default", `{"roles": {}, "rules": []}`},
+		{"unknown_field", `{"default": "deny", "role": {}}`},
+		{"inheritance_cycle", `{"default": "deny", "roles": {"a": {"inherits": ["b"]}, "b": {"inherits": ["a"]}}}`},
+		{"unknown_inherited_role", `{"default": "deny", "roles": {"a": {"inherits": ["b"]}}}`},
+		{"unknown_rule_role", `{"default": "deny", "roles": {}, "rules": [{"method": "/a.B/C", "roles": ["x"]}]}`},
+		{"public_with_roles", `{"default": "deny", "roles": {"x": {}}, "rules": [{"method": "/a.B/C", "public": true, "roles": ["x"]}]}`},
+		{"invalid_method", `{"default": "deny", "roles": {}, "rules": [{"method": " /a.B/C", "public": true}]}`},
+		{"duplicate_rule", `{"default": "deny", "roles": {}, "rules": [{"method": "/a.B/*", "public": true}, {"method": "/a.B/*", "public": true}]}`},
+		{"unknown_authn", `{"default": "deny", "roles": {"x": {}}, "rules": [{"method": "/a.B/C", "roles": ["x"], "authn": "password"}]}`},
+		{"public_with_authn", `{"default": "deny", "roles": {}, "rules": [{"method": "/a.B/C", "public": true, "authn": "cert"}]}`},
+		{"certificate_without_selector", `{"default": "deny", "roles": {"x": {}}, "client_certificates": [{"role": "x"}]}`},
+		{"certificate_unknown_role", `{"default": "deny", "roles": {}, "client_certificates": [{"common_name": "a", "role": "x"}]}`},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := ParseAccessPolicy([]byte(tc.policy))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestAccessPolicyCheckMethods(t *testing.T) {
+	t.Parallel()
+
+	services := map[string]grpc.ServiceInfo{
+		"pcbook.pbfiles.AuthService":   {Methods: []grpc.MethodInfo{{Name: "Login"}}},
+		"pcbook.pbfiles.LaptopService": {Methods: []grpc.MethodInfo{{Name: "CreateLaptop"}, {Name: "UploadImage"}}},
+	}
+
+	policy, err := ParseAccessPolicy([]byte(testAccessPolicy))
+	require.NoError(t, err)
+	require.NoError(t, policy.CheckMethods(services))
+
+	policy, err = ParseAccessPolicy([]byte(`{
+		"default": "deny",
+		"roles": {"admin": {}},
+		"rules": [{"method": "/pcbook.pbfiles.LaptopService/CreateLaptops", "roles": ["admin"]}]
+	}`))
+	require.NoError(t, err)
+	require.Error(t, policy.CheckMethods(services))
+}
+
+func TestWatchAccessPolicy(t *testing.T) {
+	t.Parallel()
+
+	folder, err := ioutil.TempDir("", "policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+
+	filename := filepath.Join(folder, "access_policy.json")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(testAccessPolicy), 0644))
+
+	reloaded := make(chan *AccessPolicy, 1)
+	stop := WatchAccessPolicy(
+		filename,
+		10*time.Millisecond,
+		func(policy *AccessPolicy) error { return nil },
+		func(policy *AccessPolicy) { reloaded <- policy },
+	)
+	defer stop()
+
+	// 无效的策略会被忽略
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`{"default": "maybe"}`), 0644))
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, reloaded)
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`{"default": "public", "roles": {}}`), 0644))
+	select {
+	case policy := <-reloaded:
+		require.True(t, policy.IsPublic("/pcbook.pbfiles.LaptopService/UploadImage"))
+	case <-time.After(time.Second):
+		require.FailNow(t, "policy is not reloaded")
+	}
+}
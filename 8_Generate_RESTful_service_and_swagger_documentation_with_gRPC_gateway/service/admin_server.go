@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"pcbook/logging"
+	"pcbook/pb"
+)
+
+// AdminServer is the server for administration
+type AdminServer struct {
+	auditLog AuditLog
+	logger   *logging.Logger
+}
+
+// NewAdminServer returns a new admin server, SetLogLevel changes the level of the logger
+func NewAdminServer(auditLog AuditLog, logger *logging.Logger) *AdminServer {
+	return &AdminServer{
+		auditLog: auditLog,
+		logger:   logger,
+	}
+}
+
+// QueryAuditLog is a unary RPC to query the audit records of the caller's tenant
+func (server *AdminServer) QueryAuditLog(
+	ctx context.Context,
+	req *pb.QueryAuditLogRequest,
+) (*pb.QueryAuditLogResponse, error) {
+	tenantID, err := TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := AuditFilter{
+		Username: req.GetUsername(),
+		TenantID: tenantID,
+	}
+	if req.GetSince() != nil {
+		filter.Since, err = ptypes.Timestamp(req.GetSince())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid since: %v", err)
+		}
+	}
+	if req.GetUntil() != nil {
+		filter.Until, err = ptypes.Timestamp(req.GetUntil())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid until: %v", err)
+		}
+	}
+
+	records, err := server.auditLog.Query(filter)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot query audit log: %v", err)
+	}
+
+	resp := &pb.QueryAuditLogResponse{}
+	for _, record := range records {
+		pbRecord, err := toPBAuditRecord(record)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot convert audit record: %v", err)
+		}
+		resp.Records = append(resp.Records, pbRecord)
+	}
+	return resp, nil
+}
+
+// SetLogLevel is a unary RPC to change the level of the server logs at runtime, e.g. to debug a problem in production.
+// The level is shared by all the tenants, so the access policy grants it to super admins only.
+func (server *AdminServer) SetLogLevel(
+	ctx context.Context,
+	req *pb.SetLogLevelRequest,
+) (*pb.SetLogLevelResponse, error) {
+	level, err := logging.ParseLevel(req.GetLevel())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	previous := server.logger.Level()
+	server.logger.SetLevel(level)
+	logging.FromContext(ctx).Info("log level changed", "previous_level", previous, "level", level)
+
+	resp := &pb.SetLogLevelResponse{
+		PreviousLevel: previous.String(),
+		Level:         level.String(),
+	}
+	return resp, nil
+}
+
+func toPBAuditRecord(record *AuditRecord) (*pb.AuditRecord, error) {
+	recordTime, err := ptypes.TimestampProto(record.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	pbRecord := &pb.AuditRecord{
+		Sequence: record.Sequence,
+		Time:     recordTime,
+		Username: record.Username,
+		Role:     record.Role,
+		TenantId: record.TenantID,
+		Method:   record.Method,
+		Targets:  record.Targets,
+		Peer:     record.Peer,
+		Code:     record.Code,
+		PrevHash: record.PrevHash,
+		Hash:     record.Hash,
+	}
+	return pbRecord, nil
+}
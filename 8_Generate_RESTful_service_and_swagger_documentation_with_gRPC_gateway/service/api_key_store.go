@@ -0,0 +1,86 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// APIKeyStore is an interface to store API keys
+type APIKeyStore interface {
+	// Save saves a new API key to the store
+	Save(apiKey *APIKey) error
+	// Find finds an API key by ID
+	Find(id string) (*APIKey, error)
+	// List returns all API keys, including the revoked ones
+	List() ([]*APIKey, error)
+	// Revoke revokes an API key by ID
+	Revoke(id string, revokedAt time.Time) error
+}
+
+// InMemoryAPIKeyStore stores API keys in memory
+type InMemoryAPIKeyStore struct {
+	mutex   sync.RWMutex
+	apiKeys map[string]*APIKey
+}
+
+// NewInMemoryAPIKeyStore returns a new in-memory API key store
+func NewInMemoryAPIKeyStore() *InMemoryAPIKeyStore {
+	return &InMemoryAPIKeyStore{
+		apiKeys: make(map[string]*APIKey),
+	}
+}
+
+func (store *InMemoryAPIKeyStore) Save(apiKey *APIKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.apiKeys[apiKey.ID] != nil {
+		return ErrAlreadyExists
+	}
+
+	store.apiKeys[apiKey.ID] = apiKey.Clone()
+	return nil
+}
+
+func (store *InMemoryAPIKeyStore) Find(id string) (*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	apiKey := store.apiKeys[id]
+	if apiKey == nil {
+		return nil, nil
+	}
+
+	return apiKey.Clone(), nil
+}
+
+func (store *InMemoryAPIKeyStore) List() ([]*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	apiKeys := make([]*APIKey, 0, len(store.apiKeys))
+	for _, apiKey := range store.apiKeys {
+		apiKeys = append(apiKeys, apiKey.Clone())
+	}
+
+	sort.Slice(apiKeys, func(i, j int) bool {
+		return apiKeys[i].CreatedAt.Before(apiKeys[j].CreatedAt)
+	})
+	return apiKeys, nil
+}
+
+func (store *InMemoryAPIKeyStore) Revoke(id string, revokedAt time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	apiKey := store.apiKeys[id]
+	if apiKey == nil {
+		return ErrNotFound
+	}
+
+	if !apiKey.IsRevoked() {
+		apiKey.RevokedAt = revokedAt
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"pcbook/logging"
+	"pcbook/pb"
+	"sync"
+	"time"
+)
+
+// auditedMethods are the mutating RPCs recorded in the audit log
+var auditedMethods = map[string]bool{
+	"/pcbook.pbfiles.LaptopService/CreateLaptop":     true,
+	"/pcbook.pbfiles.LaptopService/UpdateLaptop":     true,
+	"/pcbook.pbfiles.LaptopService/DeleteLaptop":     true,
+	"/pcbook.pbfiles.LaptopService/UploadImage":      true,
+	"/pcbook.pbfiles.LaptopService/RateLaptop":       true,
+	"/pcbook.pbfiles.AuthService/RevokeUserSessions": true,
+	"/pcbook.pbfiles.AuthService/UnlockAccount":      true,
+	"/pcbook.pbfiles.AuthService/CreateApiKey":       true,
+	"/pcbook.pbfiles.AuthService/RevokeApiKey":       true,
+	"/pcbook.pbfiles.AuthService/EnrollTotp":         true,
+	"/pcbook.pbfiles.AuthService/ConfirmTotp":        true,
+	"/pcbook.pbfiles.AdminService/SetLogLevel":       true,
+}
+
+// AuditInterceptor is a server interceptor that records the mutating RPCs in the audit log.
+// It runs before the auth interceptor, so that the calls rejected by authentication, authorization
+// or rate limiting are recorded too; the auth interceptor reports the caller it has authenticated.
+type AuditInterceptor struct {
+	auditLog       AuditLog
+	trustedProxies TrustedProxies
+}
+
+// NewAuditInterceptor returns a new audit interceptor
+func NewAuditInterceptor(auditLog AuditLog, trustedProxies TrustedProxies) *AuditInterceptor {
+	return &AuditInterceptor{
+		auditLog:       auditLog,
+		trustedProxies: trustedProxies,
+	}
+}
+
+// Unary returns a server interceptor function to audit unary RPC
+func (interceptor *AuditInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if !auditedMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		caller := &auditCaller{}
+		targets := &auditTargets{}
+		targets.add(req)
+		res, err := handler(context.WithValue(ctx, auditCallerKey{}, caller), req)
+		targets.add(res)
+
+		interceptor.record(ctx, info.FullMethod, caller, targets, err)
+		return res, err
+	}
+}
+
+// Stream returns a server interceptor function to audit stream RPC
+func (interceptor *AuditInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if !auditedMethods[info.FullMethod] {
+			return handler(srv, stream)
+		}
+
+		// 流式RPC的目标来自收发的每一条消息，如一次RateLaptop可以评价多台笔记本
+		caller := &auditCaller{}
+		auditStream := &auditServerStream{
+			ServerStream: stream,
+			ctx:          context.WithValue(stream.Context(), auditCallerKey{}, caller),
+			targets:      &auditTargets{},
+		}
+		err := handler(srv, auditStream)
+
+		interceptor.record(stream.Context(), info.FullMethod, caller, auditStream.targets, err)
+		return err
+	}
+}
+
+func (interceptor *AuditInterceptor) record(ctx context.Context, method string, caller *auditCaller, targets *auditTargets, err error) {
+	record := &AuditRecord{
+		Time:    time.Now(),
+		Method:  method,
+		Targets: targets.list(),
+		Peer:    ClientAddress(ctx, interceptor.trustedProxies),
+		Code:    status.Code(err).String(),
+	}
+	principal, ok := caller.get()
+	if !ok {
+		principal, ok = PrincipalFromContext(ctx)
+	}
+	if ok {
+		record.Username = principal.Username
+		record.Role = principal.Role
+		record.TenantID = principal.TenantID
+	}
+
+	// 操作已经完成，写入失败时不能再改变结果，只能记录下来
+	appendErr := interceptor.auditLog.Append(record)
+	if appendErr != nil {
+		logging.FromContext(ctx).Error("cannot append audit record", "error", appendErr)
+	}
+}
+
+type auditCallerKey struct{}
+
+// auditCaller receives the caller authenticated by the later auth stage, even if it is then denied
+type auditCaller struct {
+	mutex     sync.Mutex
+	principal *Principal
+}
+
+func (caller *auditCaller) get() (*Principal, bool) {
+	caller.mutex.Lock()
+	defer caller.mutex.Unlock()
+
+	return caller.principal, caller.principal != nil
+}
+
+// reportAuditCaller tells the audit stage of the RPC who the caller is, if the RPC is audited
+func reportAuditCaller(ctx context.Context, principal *Principal) {
+	caller, ok := ctx.Value(auditCallerKey{}).(*auditCaller)
+	if !ok {
+		return
+	}
+
+	caller.mutex.Lock()
+	defer caller.mutex.Unlock()
+
+	caller.principal = principal
+}
+
+// auditServerStream collects the targets of the messages of a server stream
+type auditServerStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	targets *auditTargets
+}
+
+func (stream *auditServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *auditServerStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err == nil {
+		stream.targets.add(m)
+	}
+	return err
+}
+
+func (stream *auditServerStream) SendMsg(m interface{}) error {
+	err := stream.ServerStream.SendMsg(m)
+	if err == nil {
+		stream.targets.add(m)
+	}
+	return err
+}
+
+// auditTargets is the set of resources an RPC acts on, in the order they appear
+type auditTargets struct {
+	mutex   sync.Mutex
+	targets []string
+	seen    map[string]bool
+}
+
+// add adds the resources referred to by a request or response message
+func (targets *auditTargets) add(message interface{}) {
+	switch message := message.(type) {
+	case *pb.CreateLaptopRequest:
+		targets.addTarget("laptop", message.GetLaptop().GetId())
+	case *pb.CreateLaptopResponse:
+		targets.addTarget("laptop", message.GetId())
+	case *pb.UpdateLaptopRequest:
+		targets.addTarget("laptop", message.GetLaptop().GetId())
+	case *pb.DeleteLaptopRequest:
+		targets.addTarget("laptop", message.GetId())
+	case *pb.UploadImageRequest:
+		targets.addTarget("laptop", message.GetInfo().GetLaptopId())
+	case *pb.UploadImageResponse:
+		targets.addTarget("image", message.GetId())
+	case *pb.RateLaptopRequest:
+		targets.addTarget("laptop", message.GetLaptopId())
+	case *pb.RevokeUserSessionsRequest:
+		targets.addTarget("user", message.GetUsername())
+	case *pb.UnlockAccountRequest:
+		targets.addTarget("user", message.GetUsername())
+	case *pb.CreateApiKeyResponse:
+		targets.addTarget("api-key", message.GetApiKey().GetId())
+	case *pb.RevokeApiKeyRequest:
+		targets.addTarget("api-key", message.GetId())
+	}
+}
+
+func (targets *auditTargets) addTarget(kind string, id string) {
+	if id == "" {
+		return
+	}
+
+	targets.mutex.Lock()
+	defer targets.mutex.Unlock()
+
+	target := kind + ":" + id
+	if targets.seen[target] {
+		return
+	}
+	if targets.seen == nil {
+		targets.seen = make(map[string]bool)
+	}
+	targets.seen[target] = true
+	targets.targets = append(targets.targets, target)
+}
+
+func (targets *auditTargets) list() []string {
+	targets.mutex.Lock()
+	defer targets.mutex.Unlock()
+
+	return append([]string(nil), targets.targets...)
+}
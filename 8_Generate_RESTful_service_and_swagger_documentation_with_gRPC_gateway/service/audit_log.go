@@ -0,0 +1,311 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord records who called a mutating RPC, on what and with which outcome
+type AuditRecord struct {
+	Sequence uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Username string    `json:"username,omitempty"` // 匿名调用时为空
+	Role     string    `json:"role,omitempty"`
+	TenantID string    `json:"tenant_id,omitempty"`
+	Method   string    `json:"method"`
+	Targets  []string  `json:"targets,omitempty"` // 如 laptop:<id>、image:<id>
+	Peer     string    `json:"peer,omitempty"`
+	Code     string    `json:"code"`
+	// PrevHash 是上一条记录的哈希值，修改或删除任何一条记录都会使之后的链条断开
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// computeHash returns the SHA-256 of the record without its own hash, the previous hash included
+func (record *AuditRecord) computeHash() (string, error) {
+	other := *record
+	other.Hash = ""
+
+	data, err := json.Marshal(&other)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal audit record: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditFilter selects audit records, the zero value selects all records
+type AuditFilter struct {
+	Since    time.Time // 为零值时不限制
+	Until    time.Time // 为零值时不限制，不包含该时刻
+	Username string
+	TenantID string // 可以是AllTenants
+}
+
+func (filter *AuditFilter) matches(record *AuditRecord) bool {
+	if !filter.Since.IsZero() && record.Time.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && !record.Time.Before(filter.Until) {
+		return false
+	}
+	if filter.Username != "" && record.Username != filter.Username {
+		return false
+	}
+	return inTenant(filter.TenantID, record.TenantID)
+}
+
+// AuditLog is an append-only log of audit records
+type AuditLog interface {
+	// Append assigns the sequence number and hashes of the record, then appends it to the log
+	Append(record *AuditRecord) error
+	// Query returns the records selected by the filter, in the order they were appended
+	Query(filter AuditFilter) ([]*AuditRecord, error)
+}
+
+// FileAuditLog stores audit records in a file, one JSON record per line, chained by their hashes.
+// The last record is also kept in a head file next to the log, so that a log whose tail has been cut off,
+// which is still a valid chain, is detected when it is opened.
+type FileAuditLog struct {
+	mutex        sync.Mutex
+	filename     string
+	file         *os.File
+	lastSequence uint64
+	lastHash     string
+}
+
+// OpenFileAuditLog opens the audit log file, creating it if it doesn't exist.
+// The existing records are verified, a broken chain or a chain that doesn't reach the head means the file has been tampered with.
+func OpenFileAuditLog(filename string) (*FileAuditLog, error) {
+	head, err := readAuditLogHead(auditLogHeadFilename(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log: %w", err)
+	}
+
+	auditLog := &FileAuditLog{
+		filename: filename,
+		file:     file,
+	}
+	headFound := false
+	err = readAuditLog(file, func(record *AuditRecord) {
+		auditLog.lastSequence = record.Sequence
+		auditLog.lastHash = record.Hash
+		if head != nil && record.Sequence == head.Sequence && record.Hash == head.Hash {
+			headFound = true
+		}
+	})
+	if err == nil {
+		err = auditLog.checkHead(head, headFound)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("invalid audit log %s: %w", filename, err)
+	}
+
+	return auditLog, nil
+}
+
+// checkHead checks that the records reach the head, and moves the head to the last record.
+// The log can be ahead of its head by the records appended just before a crash.
+func (auditLog *FileAuditLog) checkHead(head *auditLogHead, headFound bool) error {
+	switch {
+	case head == nil && auditLog.lastSequence > 0:
+		return fmt.Errorf("head file %s is missing", auditLogHeadFilename(auditLog.filename))
+	case head != nil && !headFound && auditLog.lastSequence < head.Sequence:
+		return fmt.Errorf("audit log ends at #%d but its head is #%d, the records after #%d have been removed",
+			auditLog.lastSequence, head.Sequence, auditLog.lastSequence)
+	case head != nil && !headFound:
+		return fmt.Errorf("audit record #%d doesn't match the head", head.Sequence)
+	case head != nil && head.Sequence == auditLog.lastSequence:
+		return nil
+	}
+	return auditLog.writeHead()
+}
+
+// writeHead replaces the head file with the last record, the caller must hold the lock
+func (auditLog *FileAuditLog) writeHead() error {
+	data, err := json.Marshal(&auditLogHead{Sequence: auditLog.lastSequence, Hash: auditLog.lastHash})
+	if err != nil {
+		return fmt.Errorf("cannot marshal audit log head: %w", err)
+	}
+
+	// 先写入临时文件再重命名，崩溃时不会留下写了一半的头文件
+	headFilename := auditLogHeadFilename(auditLog.filename)
+	tmpFilename := headFilename + ".tmp"
+	file, err := os.OpenFile(tmpFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot create audit log head: %w", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("cannot write audit log head: %w", err)
+	}
+
+	err = os.Rename(tmpFilename, headFilename)
+	if err != nil {
+		return fmt.Errorf("cannot write audit log head: %w", err)
+	}
+	return nil
+}
+
+func (auditLog *FileAuditLog) Append(record *AuditRecord) error {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	record.Sequence = auditLog.lastSequence + 1
+	record.Time = record.Time.UTC()
+	record.PrevHash = auditLog.lastHash
+	hash, err := record.computeHash()
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("cannot marshal audit record: %w", err)
+	}
+
+	// 记录写入磁盘后才算成功，否则崩溃时可能丢失
+	_, err = auditLog.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("cannot write audit record: %w", err)
+	}
+	err = auditLog.file.Sync()
+	if err != nil {
+		return fmt.Errorf("cannot sync audit log: %w", err)
+	}
+
+	auditLog.lastSequence = record.Sequence
+	auditLog.lastHash = record.Hash
+	return auditLog.writeHead()
+}
+
+func (auditLog *FileAuditLog) Query(filter AuditFilter) ([]*AuditRecord, error) {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	file, err := os.Open(auditLog.filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log: %w", err)
+	}
+	defer file.Close()
+
+	records := []*AuditRecord{}
+	err = readAuditLog(file, func(record *AuditRecord) {
+		if filter.matches(record) {
+			records = append(records, record)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Close closes the audit log file
+func (auditLog *FileAuditLog) Close() error {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	return auditLog.file.Close()
+}
+
+// VerifyAuditLog checks the hash chain of an audit log, it returns the number of valid records.
+// It cannot tell if the tail of the log has been removed, OpenFileAuditLog checks it against the head file.
+func VerifyAuditLog(reader io.Reader) (uint64, error) {
+	var count uint64
+	err := readAuditLog(reader, func(record *AuditRecord) {
+		count++
+	})
+	return count, err
+}
+
+// readAuditLog reads the records one by one, verifying the sequence numbers and the hash chain
+func readAuditLog(reader io.Reader, found func(record *AuditRecord)) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lastSequence uint64
+	lastHash := ""
+	for scanner.Scan() {
+		record := &AuditRecord{}
+		err := json.Unmarshal(scanner.Bytes(), record)
+		if err != nil {
+			return fmt.Errorf("cannot parse audit record after #%d: %w", lastSequence, err)
+		}
+
+		if record.Sequence != lastSequence+1 {
+			return fmt.Errorf("audit record #%d follows #%d", record.Sequence, lastSequence)
+		}
+		if record.PrevHash != lastHash {
+			return fmt.Errorf("audit record #%d doesn't follow the previous record", record.Sequence)
+		}
+		hash, err := record.computeHash()
+		if err != nil {
+			return err
+		}
+		if hash != record.Hash {
+			return fmt.Errorf("audit record #%d has been modified", record.Sequence)
+		}
+
+		lastSequence = record.Sequence
+		lastHash = record.Hash
+		found(record)
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return fmt.Errorf("cannot read audit log: %w", err)
+	}
+	return nil
+}
+
+// auditLogHead is the last record of an audit log, kept in a separate file
+type auditLogHead struct {
+	Sequence uint64 `json:"seq"`
+	Hash     string `json:"hash"`
+}
+
+func auditLogHeadFilename(filename string) string {
+	return filename + ".head"
+}
+
+// readAuditLogHead reads the head file, it returns nil if the file doesn't exist
+func readAuditLogHead(filename string) (*auditLogHead, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read audit log head: %w", err)
+	}
+
+	head := &auditLogHead{}
+	err = json.Unmarshal(data, head)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit log head %s: %w", filename, err)
+	}
+	return head, nil
+}
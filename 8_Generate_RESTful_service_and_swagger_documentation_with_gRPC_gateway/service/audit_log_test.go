@@ -0,0 +1,343 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"pcbook/logging"
+	"pcbook/pb"
+	"pcbook/sample"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileAuditLog(t *testing.T) {
+	t.Parallel()
+
+	filename := newTestAuditLogFile(t)
+	auditLog, err := OpenFileAuditLog(filename)
+	require.NoError(t, err)
+
+	start := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i, username := range []string{"alice", "bob", "alice"} {
+		record := &AuditRecord{
+			Time:     start.Add(time.Duration(i) * time.Hour),
+			Username: username,
+			Method:   "/pcbook.pbfiles.LaptopService/CreateLaptop",
+			Code:     codes.OK.String(),
+		}
+		require.NoError(t, auditLog.Append(record))
+		require.EqualValues(t, i+1, record.Sequence)
+	}
+	require.NoError(t, auditLog.Close())
+
+	// 重新打开后继续链接在最后一条记录之后
+	auditLog, err = OpenFileAuditLog(filename)
+	require.NoError(t, err)
+	defer auditLog.Close()
+	require.NoError(t, auditLog.Append(&AuditRecord{Time: start.Add(3 * time.Hour), Username: "bob", Method: "m", Code: "OK"}))
+
+	records, err := auditLog.Query(AuditFilter{TenantID: AllTenants})
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	require.Equal(t, records[2].Hash, records[3].PrevHash)
+
+	records, err = auditLog.Query(AuditFilter{Username: "alice", TenantID: AllTenants})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	records, err = auditLog.Query(AuditFilter{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour), TenantID: AllTenants})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.EqualValues(t, 2, records[0].Sequence)
+	require.EqualValues(t, 3, records[1].Sequence)
+
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	count, err := VerifyAuditLog(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.EqualValues(t, 4, count)
+	require.NoError(t, auditLog.Close())
+
+	// 删除头文件后，无法确认日志是否完整
+	require.NoError(t, os.Remove(filename+".head"))
+	_, err = OpenFileAuditLog(filename)
+	require.Error(t, err)
+}
+
+func TestFileAuditLogTampering(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		tamper func(lines []string) []string
+	}{
+		{
+			name: "modified",
+			tamper: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], `"username":"bob"`, `"username":"eve"`, 1)
+				return lines
+			},
+		},
+		{
+			name: "deleted",
+			tamper: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+		},
+		{
+			name: "truncated",
+			tamper: func(lines []string) []string {
+				return lines[:2]
+			},
+		},
+		{
+			name: "reordered",
+			tamper: func(lines []string) []string {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			filename := newTestAuditLogFile(t)
+			auditLog, err := OpenFileAuditLog(filename)
+			require.NoError(t, err)
+			for _, username := range []string{"alice", "bob", "carol"} {
+				require.NoError(t, auditLog.Append(&AuditRecord{Time: time.Now(), Username: username, Method: "m", Code: "OK"}))
+			}
+			require.NoError(t, auditLog.Close())
+
+			data, err := ioutil.ReadFile(filename)
+			require.NoError(t, err)
+			lines := tc.tamper(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"))
+			require.NoError(t, ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+
+			_, err = OpenFileAuditLog(filename)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	t.Parallel()
+
+	auditLog, err := OpenFileAuditLog(newTestAuditLogFile(t))
+	require.NoError(t, err)
+	defer auditLog.Close()
+	interceptor := NewAuditInterceptor(auditLog, nil)
+
+	editor := &Principal{Username: "alice", Role: "editor", TenantID: "a"}
+	ctx := ContextWithPrincipal(context.Background(), editor)
+
+	// 创建笔记本的ID来自响应
+	create := &grpc.UnaryServerInfo{FullMethod: "/pcbook.pbfiles.LaptopService/CreateLaptop"}
+	_, err = interceptor.Unary()(ctx, &pb.CreateLaptopRequest{Laptop: &pb.Laptop{}}, create, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &pb.CreateLaptopResponse{Id: "laptop1"}, nil
+	})
+	require.NoError(t, err)
+
+	remove := &grpc.UnaryServerInfo{FullMethod: "/pcbook.pbfiles.LaptopService/DeleteLaptop"}
+	_, err = interceptor.Unary()(ctx, &pb.DeleteLaptopRequest{Id: "laptop2"}, remove, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Errorf(codes.PermissionDenied, "not the owner")
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// 只读的RPC不被记录
+	search := &grpc.UnaryServerInfo{FullMethod: "/pcbook.pbfiles.LaptopService/SearchLaptop"}
+	_, err = interceptor.Unary()(ctx, &pb.SearchLaptopRequest{}, search, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	upload := &grpc.StreamServerInfo{FullMethod: "/pcbook.pbfiles.LaptopService/UploadImage"}
+	stream := &fakeServerStream{
+		ctx: ctx,
+		requests: []proto.Message{
+			&pb.UploadImageRequest{Data: &pb.UploadImageRequest_Info{Info: &pb.ImageInfo{LaptopId: "laptop1"}}},
+			&pb.UploadImageRequest{Data: &pb.UploadImageRequest_ChunkData{ChunkData: []byte("image")}},
+		},
+	}
+	err = interceptor.Stream()(nil, stream, upload, func(srv interface{}, stream grpc.ServerStream) error {
+		for {
+			req := &pb.UploadImageRequest{}
+			if stream.RecvMsg(req) != nil {
+				break
+			}
+		}
+		return stream.SendMsg(&pb.UploadImageResponse{Id: "image1"})
+	})
+	require.NoError(t, err)
+
+	records, err := auditLog.Query(AuditFilter{TenantID: AllTenants})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	require.Equal(t, "alice", records[0].Username)
+	require.Equal(t, "editor", records[0].Role)
+	require.Equal(t, "a", records[0].TenantID)
+	require.Equal(t, create.FullMethod, records[0].Method)
+	require.Equal(t, []string{"laptop:laptop1"}, records[0].Targets)
+	require.Equal(t, "OK", records[0].Code)
+
+	require.Equal(t, []string{"laptop:laptop2"}, records[1].Targets)
+	require.Equal(t, "PermissionDenied", records[1].Code)
+
+	require.Equal(t, upload.FullMethod, records[2].Method)
+	require.Equal(t, []string{"laptop:laptop1", "image:image1"}, records[2].Targets)
+}
+
+func TestAuditInterceptorBeforeAuth(t *testing.T) {
+	t.Parallel()
+
+	auditLog, err := OpenFileAuditLog(newTestAuditLogFile(t))
+	require.NoError(t, err)
+	defer auditLog.Close()
+
+	policy, err := ParseAccessPolicy([]byte(`{
+		"default": "deny",
+		"roles": {"user": {}, "admin": {"inherits": ["user"]}},
+		"rules": [{"method": "/pcbook.pbfiles.LaptopService/DeleteLaptop", "roles": ["admin"]}]
+	}`))
+	require.NoError(t, err)
+	jwtManager := NewJWTManager("secret", time.Minute)
+	chain := NewInterceptorChain().
+		Add(StageAudit, NewAuditInterceptor(auditLog, nil)).
+		Add(StageAuth, NewAuthInterceptor(jwtManager, NewInMemorySessionStore(), NewInMemoryAPIKeyStore(), policy))
+
+	user, err := NewUser("mallory", "secret", "user")
+	require.NoError(t, err)
+	userToken, _, err := jwtManager.Generate(user)
+	require.NoError(t, err)
+	admin, err := NewUser("root", "secret", "admin")
+	require.NoError(t, err)
+	adminToken, _, err := jwtManager.Generate(admin)
+	require.NoError(t, err)
+
+	remove := &grpc.UnaryServerInfo{FullMethod: "/pcbook.pbfiles.LaptopService/DeleteLaptop"}
+	deleteLaptop := func(token string) error {
+		ctx := context.Background()
+		if token != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", token))
+		}
+		_, err := chain.Unary()(ctx, &pb.DeleteLaptopRequest{Id: "laptop1"}, remove, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return &pb.DeleteLaptopResponse{}, nil
+		})
+		return err
+	}
+
+	// 被认证或授权拒绝的调用也被记录，没有权限时记录了调用者
+	require.Equal(t, codes.Unauthenticated, status.Code(deleteLaptop("")))
+	require.Equal(t, codes.PermissionDenied, status.Code(deleteLaptop(userToken)))
+	require.NoError(t, deleteLaptop(adminToken))
+
+	records, err := auditLog.Query(AuditFilter{TenantID: AllTenants})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	require.Equal(t, "Unauthenticated", records[0].Code)
+	require.Empty(t, records[0].Username)
+	require.Equal(t, []string{"laptop:laptop1"}, records[0].Targets)
+
+	require.Equal(t, "PermissionDenied", records[1].Code)
+	require.Equal(t, "mallory", records[1].Username)
+	require.Equal(t, "user", records[1].Role)
+
+	require.Equal(t, "OK", records[2].Code)
+	require.Equal(t, "root", records[2].Username)
+}
+
+func TestAdminServerQueryAuditLog(t *testing.T) {
+	t.Parallel()
+
+	auditLog, err := OpenFileAuditLog(newTestAuditLogFile(t))
+	require.NoError(t, err)
+	defer auditLog.Close()
+
+	start := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i, tenantID := range []string{"a", "b", "a"} {
+		record := &AuditRecord{
+			Time:     start.Add(time.Duration(i) * time.Hour),
+			Username: "user-" + tenantID,
+			TenantID: tenantID,
+			Method:   "/pcbook.pbfiles.LaptopService/DeleteLaptop",
+			Targets:  []string{"laptop:" + sample.NewLaptop().GetId()},
+			Code:     codes.OK.String(),
+		}
+		require.NoError(t, auditLog.Append(record))
+	}
+
+	server := NewAdminServer(auditLog, logging.New(ioutil.Discard, logging.FormatLogfmt, logging.LevelInfo))
+	adminA := &Principal{Username: "admin-a", Role: RoleAdmin, TenantID: "a"}
+	superAdmin := &Principal{Username: "root", Role: RoleSuperAdmin}
+
+	// 租户管理员只能看到自己租户的记录
+	res, err := server.QueryAuditLog(ContextWithPrincipal(context.Background(), adminA), &pb.QueryAuditLogRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.GetRecords(), 2)
+	for _, record := range res.GetRecords() {
+		require.Equal(t, "a", record.GetTenantId())
+	}
+
+	res, err = server.QueryAuditLog(ContextWithPrincipal(context.Background(), superAdmin), &pb.QueryAuditLogRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.GetRecords(), 3)
+
+	since, err := ptypes.TimestampProto(start.Add(time.Hour))
+	require.NoError(t, err)
+	res, err = server.QueryAuditLog(ContextWithPrincipal(context.Background(), superAdmin), &pb.QueryAuditLogRequest{Since: since, Username: "user-a"})
+	require.NoError(t, err)
+	require.Len(t, res.GetRecords(), 1)
+	require.EqualValues(t, 3, res.GetRecords()[0].GetSequence())
+	require.NotEmpty(t, res.GetRecords()[0].GetHash())
+}
+
+// fakeServerStream replays the requests to the handler and discards the responses
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	requests []proto.Message
+}
+
+func (stream *fakeServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *fakeServerStream) RecvMsg(m interface{}) error {
+	if len(stream.requests) == 0 {
+		return io.EOF
+	}
+	req := stream.requests[0]
+	stream.requests = stream.requests[1:]
+
+	proto.Merge(m.(proto.Message), req)
+	return nil
+}
+
+func (stream *fakeServerStream) SendMsg(m interface{}) error {
+	return nil
+}
+
+func newTestAuditLogFile(t *testing.T) string {
+	folder, err := ioutil.TempDir("", "pcbook-audit")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(folder) })
+
+	return filepath.Join(folder, "audit.log")
+}
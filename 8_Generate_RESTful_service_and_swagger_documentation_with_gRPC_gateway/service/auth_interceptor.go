@@ -6,27 +6,68 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
-	"log"
+	"pcbook/logging"
+	"pcbook/tracing"
+	"sync"
 )
 
+// APIKeyHeader is the metadata key of API keys, callers send either an API key or an access token
+const APIKeyHeader = "x-api-key"
+
+// 区分API密钥、客户端证书与用户名的身份
+const (
+	apiKeyUsernamePrefix = "apikey:"
+	certUsernamePrefix   = "cert:"
+)
+
+// totpEnrolmentMethods can still be called by users who must enable two-factor authentication but haven't yet
+var totpEnrolmentMethods = map[string]bool{
+	"/pcbook.pbfiles.AuthService/EnrollTotp":  true,
+	"/pcbook.pbfiles.AuthService/ConfirmTotp": true,
+	"/pcbook.pbfiles.AuthService/Logout":      true,
+}
+
 // AuthInterceptor is a server interceptor for authentication and authorization
 type AuthInterceptor struct {
-	jwtManager *JWTManager
-	// 为每个rpc方法定义可以访问它的角色列表
-	accessibleRoles map[string][]string
+	jwtManager   *JWTManager
+	sessionStore SessionStore // 用于检查令牌是否已被吊销
+	apiKeyStore  APIKeyStore
+	mutex        sync.RWMutex
+	// 为每个rpc方法定义可以访问它的角色，可以在运行时替换
+	accessPolicy *AccessPolicy
 }
 
 // NewAuthInterceptor returns a new auth interceptor
 func NewAuthInterceptor(
 	jwtManager *JWTManager,
-	accessibleRoles map[string][]string,
+	sessionStore SessionStore,
+	apiKeyStore APIKeyStore,
+	accessPolicy *AccessPolicy,
 ) *AuthInterceptor {
 	return &AuthInterceptor{
-		jwtManager:      jwtManager,
-		accessibleRoles: accessibleRoles,
+		jwtManager:   jwtManager,
+		sessionStore: sessionStore,
+		apiKeyStore:  apiKeyStore,
+		accessPolicy: accessPolicy,
 	}
 }
 
+// AccessPolicy returns the access policy in effect
+func (interceptor *AuthInterceptor) AccessPolicy() *AccessPolicy {
+	interceptor.mutex.RLock()
+	defer interceptor.mutex.RUnlock()
+
+	return interceptor.accessPolicy
+}
+
+// SetAccessPolicy replaces the access policy, it takes effect from the next RPC
+func (interceptor *AuthInterceptor) SetAccessPolicy(accessPolicy *AccessPolicy) {
+	interceptor.mutex.Lock()
+	defer interceptor.mutex.Unlock()
+
+	interceptor.accessPolicy = accessPolicy
+}
+
 // 将新的Unary()方法添加到auth拦截器对象中
 // Unary returns a server interceptor function to authentication and authorize unary RPC
 func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
@@ -36,9 +77,9 @@ func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
-		log.Println("--> unary interceptor: ", info.FullMethod) // 被调用的rpc的完整方法名
+		logging.FromContext(ctx).Debug("authorizing unary RPC")
 
-		err := interceptor.authorize(ctx, info.FullMethod)
+		ctx, err := interceptor.authorize(ctx, info.FullMethod)
 		if err != nil {
 			return nil, err
 		}
@@ -55,45 +96,239 @@ func (interceptor *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
 		info *grpc.StreamServerInfo,
 		handler grpc.StreamHandler,
 	) error {
-		log.Println("--> stream interceptor: ", info.FullMethod)
+		logging.FromContext(stream.Context()).Debug("authorizing stream RPC")
 
-		err := interceptor.authorize(stream.Context(), info.FullMethod)
+		ctx, err := interceptor.authorize(stream.Context(), info.FullMethod)
 		if err != nil {
 			return err
 		}
 
-		return handler(srv, stream) // 使用原始服务器和流参数处理程序
+		// 使用原始服务器和流参数处理程序，流的上下文中带有调用者的身份
+		return handler(srv, &serverStreamWithContext{ServerStream: stream, ctx: ctx})
 	}
 }
 
-func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) error {
-	accessibleRoles, ok := interceptor.accessibleRoles[method]
-	if !ok {
+// authorize checks if the caller can access the method, and returns a context carrying the caller's principal
+func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) (context.Context, error) {
+	// 返回的上下文不带这个span，之后的span仍然是RPC的子span
+	_, span := tracing.StartSpan(ctx, "AuthInterceptor.authorize")
+	defer span.End()
+
+	accessPolicy := interceptor.AccessPolicy()
+	if accessPolicy.IsPublic(method) {
 		// everyone can access
-		return nil
+		return ctx, nil
 	}
 
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return status.Errorf(codes.Unauthenticated, "metadata is not provides")
+	principal, err := interceptor.authenticate(ctx, accessPolicy, method)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
 	}
+	span.SetAttribute("auth.role", principal.Role)
+	// 审计阶段在认证之前运行，没有权限的调用也要记录调用者
+	reportAuditCaller(ctx, principal)
 
-	values := md["authorization"]
-	if len(values) == 0 {
-		return status.Errorf(codes.Unauthenticated, "authorization token is not provided")
+	if !accessPolicy.Allows(principal.Role, method) {
+		err = status.Errorf(codes.PermissionDenied, "no permission to access this RPC")
+		span.SetError(err)
+		return nil, err
+	}
+
+	principal.Roles = accessPolicy.GrantedRoles(principal.Role)
+	return ContextWithPrincipal(ctx, principal), nil
+}
+
+// authenticate identifies the caller with the credentials required by the rule of the method
+func (interceptor *AuthInterceptor) authenticate(
+	ctx context.Context,
+	accessPolicy *AccessPolicy,
+	method string,
+) (*Principal, error) {
+	certPrincipal := certificatePrincipal(ctx, accessPolicy)
+
+	switch accessPolicy.Rule(method).AuthnMethod() {
+	case AuthnToken:
+		return interceptor.authenticateBearer(ctx, accessPolicy, method)
+
+	case AuthnCert:
+		if certPrincipal == nil {
+			return nil, status.Errorf(codes.Unauthenticated, "a verified client certificate is required")
+		}
+		return certPrincipal, nil
+
+	case AuthnBoth:
+		if certPrincipal == nil {
+			return nil, status.Errorf(codes.Unauthenticated, "a verified client certificate is required")
+		}
+		principal, err := interceptor.authenticateBearer(ctx, accessPolicy, method)
+		if err != nil {
+			return nil, err
+		}
+
+		// 令牌决定调用者的身份，证书的角色也必须被允许
+		if !accessPolicy.Allows(certPrincipal.Role, method) {
+			return nil, status.Errorf(codes.PermissionDenied, "client certificate has no permission to access this RPC")
+		}
+		if certPrincipal.TenantID != principal.TenantID {
+			return nil, status.Errorf(codes.PermissionDenied, "client certificate belongs to a different tenant")
+		}
+		principal.Certificate = certPrincipal.Certificate
+		return principal, nil
+
+	default:
+		// 有令牌时优先使用令牌，没有时才使用证书
+		if !hasBearer(ctx) && certPrincipal != nil {
+			return certPrincipal, nil
+		}
+		return interceptor.authenticateBearer(ctx, accessPolicy, method)
+	}
+}
+
+// authenticateBearer verifies the API key or the access token of the request
+func (interceptor *AuthInterceptor) authenticateBearer(
+	ctx context.Context,
+	accessPolicy *AccessPolicy,
+	method string,
+) (*Principal, error) {
+	// 带有API密钥的请求只用API密钥认证，不会再回退到访问令牌
+	if apiKey, ok := apiKeyFromContext(ctx); ok {
+		return interceptor.authenticateAPIKey(apiKey, method)
+	}
+	return interceptor.authenticateToken(ctx, accessPolicy, method)
+}
+
+// authenticateToken verifies the access token of the request
+func (interceptor *AuthInterceptor) authenticateToken(
+	ctx context.Context,
+	accessPolicy *AccessPolicy,
+	method string,
+) (*Principal, error) {
+	accessToken, err := accessTokenFromContext(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	accessToken := values[0]
 	claims, err := interceptor.jwtManager.Verify(accessToken)
 	if err != nil {
 		// accessToken is invalid
-		return status.Errorf(codes.Unauthenticated, "access token is invalid: %v", err)
+		return nil, status.Errorf(codes.Unauthenticated, "access token is invalid: %v", err)
+	}
+
+	revoked, err := interceptor.sessionStore.IsRevoked(claims.Id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot check access token: %v", err)
+	}
+	if revoked {
+		return nil, status.Errorf(codes.Unauthenticated, "access token has been revoked")
 	}
 
-	for _, role := range accessibleRoles {
-		if role == claims.Role {
-			return nil
+	if claims.Confirmation != nil {
+		// 绑定证书的令牌只能在出示同一证书的连接上使用
+		cert, ok := verifiedPeerCertificate(ctx)
+		if !ok || CertificateThumbprint(cert) != claims.Confirmation.X5TS256 {
+			return nil, status.Errorf(codes.Unauthenticated, "access token is bound to a different client certificate")
 		}
 	}
-	return status.Errorf(codes.PermissionDenied, "no permission to access this RPC")
+
+	// 角色要求两步验证时，未启用两步验证的用户只能先完成登记，再带验证码重新登录
+	if accessPolicy.RequiresTOTP(claims.Role) && !claims.HasAuthMethod(AuthMethodOTP) && !totpEnrolmentMethods[method] {
+		return nil, status.Errorf(codes.PermissionDenied, "role %s requires two-factor authentication, enroll TOTP and login again", claims.Role)
+	}
+
+	principal := &Principal{
+		Username: claims.Username,
+		Role:     claims.Role,
+		TenantID: claims.TenantID,
+	}
+	return principal, nil
+}
+
+// authenticateAPIKey verifies the API key and checks if its scopes cover the method
+func (interceptor *AuthInterceptor) authenticateAPIKey(key string, method string) (*Principal, error) {
+	id, err := ParseAPIKeyID(key)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "API key is invalid: %v", err)
+	}
+
+	apiKey, err := interceptor.apiKeyStore.Find(id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find API key: %v", err)
+	}
+	if apiKey == nil || !apiKey.IsCorrectKey(key) {
+		return nil, status.Errorf(codes.Unauthenticated, "API key is invalid")
+	}
+	if apiKey.IsRevoked() {
+		return nil, status.Errorf(codes.Unauthenticated, "API key has been revoked")
+	}
+
+	if !apiKey.AllowsMethod(method) {
+		return nil, status.Errorf(codes.PermissionDenied, "API key is not scoped for this RPC")
+	}
+
+	principal := &Principal{
+		// 密钥的名称不唯一，用ID区分不同密钥的所有权和审计记录
+		Username: apiKeyUsernamePrefix + apiKey.ID,
+		Role:     apiKey.Role,
+		APIKeyID: apiKey.ID,
+		TenantID: apiKey.TenantID,
+	}
+	return principal, nil
+}
+
+// certificatePrincipal returns the principal of the verified client certificate,
+// or nil if there is no such certificate or no mapping matches it
+func certificatePrincipal(ctx context.Context, accessPolicy *AccessPolicy) *Principal {
+	cert, ok := verifiedPeerCertificate(ctx)
+	if !ok {
+		return nil
+	}
+
+	mapping, ok := accessPolicy.MatchCertificate(cert)
+	if !ok {
+		return nil
+	}
+
+	identity := certificateIdentity(cert)
+	return &Principal{
+		Username:    certUsernamePrefix + identity,
+		Role:        mapping.Role,
+		Certificate: identity,
+		TenantID:    mapping.TenantID,
+	}
+}
+
+func hasBearer(ctx context.Context) bool {
+	if _, ok := apiKeyFromContext(ctx); ok {
+		return true
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return len(md["authorization"]) > 0
+}
+
+func apiKeyFromContext(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	values := md[APIKeyHeader]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+func accessTokenFromContext(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", status.Errorf(codes.Unauthenticated, "metadata is not provides")
+	}
+
+	values := md["authorization"]
+	if len(values) == 0 {
+		return "", status.Errorf(codes.Unauthenticated, "authorization token is not provided")
+	}
+
+	return values[0], nil
 }
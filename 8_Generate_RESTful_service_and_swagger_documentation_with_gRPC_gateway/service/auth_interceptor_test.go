@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAuthInterceptorClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	policy, err := ParseAccessPolicy([]byte(`{
+		"default": "deny",
+		"roles": {"user": {}, "batch": {}, "admin": {"inherits": ["user"]}},
+		"client_certificates": [
+			{"uri": "spiffe://pcbook/batch", "role": "batch"},
+			{"common_name": "ops", "role": "admin"}
+		],
+		"rules": [
+			{"method": "/test/Any", "roles": ["user", "batch"]},
+			{"method": "/test/Token", "roles": ["user", "batch"], "authn": "token"},
+			{"method": "/test/Cert", "roles": ["batch"], "authn": "cert"},
+			{"method": "/test/Both", "roles": ["admin"], "authn": "both"}
+		]
+	}`))
+	require.NoError(t, err)
+
+	sessionStore := NewInMemorySessionStore()
+	jwtManager := NewJWTManager("secret", time.Minute)
+	interceptor := NewAuthInterceptor(jwtManager, sessionStore, NewInMemoryAPIKeyStore(), policy)
+
+	user, err := NewUser("alice", "secret", "user")
+	require.NoError(t, err)
+	userToken, _, err := jwtManager.Generate(user)
+	require.NoError(t, err)
+
+	admin, err := NewUser("root", "secret", "admin")
+	require.NoError(t, err)
+	adminToken, _, err := jwtManager.Generate(admin)
+	require.NoError(t, err)
+
+	batchCert := &x509.Certificate{SerialNumber: big.NewInt(1), URIs: []*url.URL{{Scheme: "spiffe", Host: "pcbook", Path: "/batch"}}}
+	opsCert := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "ops"}}
+	unknownCert := &x509.Certificate{SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "guest"}}
+
+	testCases := []struct {
+		name     string
+		method   string
+		token    string
+		cert     *x509.Certificate
+		verified bool
+		username string
+		code     codes.Code
+	}{
+		{"any_with_token", "/test/Any", userToken, nil, false, "alice", codes.OK},
+		{"any_with_cert", "/test/Any", "", batchCert, true, "cert:spiffe://pcbook/batch", codes.OK},
+		{"any_prefers_token", "/test/Any", userToken, batchCert, true, "alice", codes.OK},
+		{"any_with_unverified_cert", "/test/Any", "", batchCert, false, "", codes.Unauthenticated},
+		{"any_with_unmapped_cert", "/test/Any", "", unknownCert, true, "", codes.Unauthenticated},
+		{"token_rejects_cert", "/test/Token", "", batchCert, true, "", codes.Unauthenticated},
+		{"cert_rejects_token", "/test/Cert", userToken, nil, false, "", codes.Unauthenticated},
+		{"cert_with_cert", "/test/Cert", userToken, batchCert, true, "cert:spiffe://pcbook/batch", codes.OK},
+		{"both", "/test/Both", adminToken, opsCert, true, "root", codes.OK},
+		{"both_without_cert", "/test/Both", adminToken, nil, false, "", codes.Unauthenticated},
+		{"both_without_token", "/test/Both", "", opsCert, true, "", codes.Unauthenticated},
+		{"both_cert_not_allowed", "/test/Both", adminToken, batchCert, true, "", codes.PermissionDenied},
+		{"both_token_not_allowed", "/test/Both", userToken, opsCert, true, "", codes.PermissionDenied},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			if tc.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tc.token))
+			}
+			if tc.cert != nil {
+				ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: newTestTLSInfo(tc.cert, tc.verified)})
+			}
+
+			var principal *Principal
+			_, err := interceptor.Unary()(
+				ctx,
+				nil,
+				&grpc.UnaryServerInfo{FullMethod: tc.method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					principal, _ = PrincipalFromContext(ctx)
+					return nil, nil
+				},
+			)
+			require.Equal(t, tc.code, status.Code(err))
+			if tc.code == codes.OK {
+				require.Equal(t, tc.username, principal.Username)
+			}
+		})
+	}
+}
+
+func newTestTLSInfo(cert *x509.Certificate, verified bool) credentials.TLSInfo {
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if verified {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return credentials.TLSInfo{State: state}
+}
@@ -2,38 +2,571 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/ptypes"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"pcbook/logging"
 	"pcbook/pb"
+	"pcbook/tracing"
+	"sync"
+	"time"
 )
 
 // AuthServer is the server for authentication
 type AuthServer struct {
 	userStore UserStore
+	sessionStore SessionStore
+	apiKeyStore APIKeyStore
 	jwtManager *JWTManager
+	loginLimiter *LoginLimiter
+	trustedProxies TrustedProxies // 信任这些地址（如REST网关）传来的x-forwarded-for
+	passwordHasher PasswordHasher // 登录时，用旧算法或旧参数计算的密码哈希会被重新计算
+	hasherMutex    sync.RWMutex   // 保护passwordHasher，配置重新加载时会替换它
+	accessPolicy   *AccessPolicy  // API密钥的角色必须是其中定义的角色
+	policyMutex    sync.RWMutex   // 保护accessPolicy，策略文件重新加载时会替换它
+	// userMutex 保护对用户的读取-修改-写入，如记录一次性验证码已被使用，防止并发登录重复使用同一验证码，
+	// 或并发的登记覆盖刚确认的密钥
+	userMutex sync.Mutex
 }
 
 // NewAuthServer returns a new auth server
-func NewAuthServer(userStore UserStore, jwtManager *JWTManager) *AuthServer {
-	return &AuthServer{userStore: userStore, jwtManager: jwtManager}
+func NewAuthServer(
+	userStore UserStore,
+	sessionStore SessionStore,
+	apiKeyStore APIKeyStore,
+	jwtManager *JWTManager,
+	loginLimiter *LoginLimiter,
+	trustedProxies TrustedProxies,
+	passwordHasher PasswordHasher,
+) *AuthServer {
+	return &AuthServer{
+		userStore:      userStore,
+		sessionStore:   sessionStore,
+		apiKeyStore:    apiKeyStore,
+		jwtManager:     jwtManager,
+		loginLimiter:   loginLimiter,
+		trustedProxies: trustedProxies,
+		passwordHasher: passwordHasher,
+	}
 }
 
 // Login is a unary RPC to login user
 func (server *AuthServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	// 同时按用户名和客户端地址限制尝试次数，在比较密码（bcrypt很慢）之前就拒绝。
+	// 允许的尝试先计为失败，成功后再退还，并发的猜测不能在第一次失败被记录之前全部通过
+	limiterKeys := []string{UserKey(req.GetUsername())}
+	if address := ClientAddress(ctx, server.trustedProxies); address != "" {
+		limiterKeys = append(limiterKeys, AddressKey(address))
+	}
+
+	if ok, retryAfter := server.loginLimiter.Allow(limiterKeys...); !ok {
+		return nil, tooManyLoginAttempts(retryAfter)
+	}
+
+	_, span := tracing.StartSpan(ctx, "UserStore.Find")
 	user, err := server.userStore.Find(req.GetUsername())
+	span.SetError(err)
+	span.End()
 	if err != nil {
+		server.loginLimiter.Refund(limiterKeys...)
 		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
 	}
 
-	if user == nil || !user.IsCorrectPassword(req.GetPassword()) {
+	// 密码哈希故意很慢，它通常是登录中最耗时的一步
+	_, span = tracing.StartSpan(ctx, "User.IsCorrectPassword")
+	correctPassword := user != nil && user.IsCorrectPassword(req.GetPassword())
+	span.End()
+
+	if !correctPassword {
 		return nil, status.Errorf(codes.NotFound, "incorrect username/password")
 	}
 
-	token, err := server.jwtManager.Generate(user)
+	if user.TOTPEnabled {
+		// 缺少和错误的验证码返回相同的错误，并且都计为失败，否则可以绕过锁定来确认密码是否正确
+		ok := false
+		if req.GetOtpCode() != "" {
+			ok, err = server.useOneTimeCode(user.Username, req.GetOtpCode())
+			if err != nil {
+				server.loginLimiter.Refund(limiterKeys...)
+				return nil, status.Errorf(codes.Internal, "cannot verify one-time code: %v", err)
+			}
+		}
+		if !ok {
+			return nil, status.Errorf(codes.Unauthenticated, "missing or incorrect one-time code")
+		}
+	}
+
+	// 只重置用户名的计数，否则攻击者可以用自己的账户登录来重置其地址的计数，地址只退还这次尝试
+	server.loginLimiter.Succeed(limiterKeys[0])
+	server.loginLimiter.Refund(limiterKeys[1:]...)
+
+	if passwordHasher := server.currentPasswordHasher(); passwordHasher.NeedsRehash(user.HashedPassword) {
+		server.rehashPassword(ctx, passwordHasher, user.Username, req.GetPassword())
+	}
+
+	// 通过mTLS登录时，令牌可以与客户端证书绑定，被盗用的令牌无法在其他机器上使用
+	clientCert, _ := verifiedPeerCertificate(ctx)
+	token, claims, err := server.jwtManager.GenerateForCertificate(user, clientCert)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot generate access token")
 	}
 
+	err = server.sessionStore.Add(NewSession(claims))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save session: %v", err)
+	}
+
 	resp := &pb.LoginResponse{AccessToken: token}
 	return resp, nil
 }
+
+// Logout is a unary RPC to revoke the access token of the current request
+func (server *AuthServer) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	accessToken, err := accessTokenFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := server.jwtManager.Verify(accessToken)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "access token is invalid: %v", err)
+	}
+
+	err = server.sessionStore.Revoke(claims.Id, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot revoke access token: %v", err)
+	}
+
+	return &pb.LogoutResponse{}, nil
+}
+
+// ListUserSessions is a unary RPC to list the active sessions of a user
+func (server *AuthServer) ListUserSessions(
+	ctx context.Context,
+	req *pb.ListUserSessionsRequest,
+) (*pb.ListUserSessionsResponse, error) {
+	_, err := server.tenantUser(ctx, req.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := server.sessionStore.FindByUser(req.GetUsername())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find sessions: %v", err)
+	}
+
+	resp := &pb.ListUserSessionsResponse{}
+	for _, session := range sessions {
+		pbSession, err := toPBSession(session)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot convert session: %v", err)
+		}
+		resp.Sessions = append(resp.Sessions, pbSession)
+	}
+	return resp, nil
+}
+
+// RevokeUserSessions is a unary RPC to revoke all active sessions of a user
+func (server *AuthServer) RevokeUserSessions(
+	ctx context.Context,
+	req *pb.RevokeUserSessionsRequest,
+) (*pb.RevokeUserSessionsResponse, error) {
+	_, err := server.tenantUser(ctx, req.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := server.sessionStore.RevokeUser(req.GetUsername())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot revoke sessions: %v", err)
+	}
+
+	resp := &pb.RevokeUserSessionsResponse{RevokedCount: uint32(count)}
+	return resp, nil
+}
+
+// UnlockAccount is a unary RPC to clear the failed login attempts of a user
+func (server *AuthServer) UnlockAccount(
+	ctx context.Context,
+	req *pb.UnlockAccountRequest,
+) (*pb.UnlockAccountResponse, error) {
+	_, err := server.tenantUser(ctx, req.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+
+	locked := server.loginLimiter.Unlock(req.GetUsername())
+	return &pb.UnlockAccountResponse{Locked: locked}, nil
+}
+
+// tenantUser finds the user targeted by an admin RPC.
+// The users of other tenants, and the super admins for the other callers, do not exist for the caller.
+func (server *AuthServer) tenantUser(ctx context.Context, username string) (*User, error) {
+	tenantID, err := TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := server.userStore.Find(username)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+	if user == nil || !inTenant(tenantID, user.TenantID) || (user.Role == RoleSuperAdmin && tenantID != AllTenants) {
+		return nil, status.Errorf(codes.NotFound, "user %s doesn't exist", username)
+	}
+	return user, nil
+}
+
+// CreateApiKey is a unary RPC to create an API key for a service-to-service caller
+func (server *AuthServer) CreateApiKey(
+	ctx context.Context,
+	req *pb.CreateApiKeyRequest,
+) (*pb.CreateApiKeyResponse, error) {
+	if req.GetName() == "" || req.GetRole() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "name and role of the API key are required")
+	}
+	if accessPolicy := server.currentAccessPolicy(); accessPolicy == nil || !accessPolicy.DefinesRole(req.GetRole()) {
+		return nil, status.Errorf(codes.InvalidArgument, "role %q is not defined in the access policy", req.GetRole())
+	}
+
+	tenantID, err := apiKeyTenant(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey, key, err := NewAPIKey(req.GetName(), req.GetRole(), req.GetScopes())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot create API key: %v", err)
+	}
+	apiKey.TenantID = tenantID
+
+	err = server.apiKeyStore.Save(apiKey)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save API key: %v", err)
+	}
+
+	pbAPIKey, err := toPBAPIKey(apiKey)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot convert API key: %v", err)
+	}
+
+	resp := &pb.CreateApiKeyResponse{ApiKey: pbAPIKey, Key: key}
+	return resp, nil
+}
+
+// ListApiKeys is a unary RPC to list all API keys, without the keys themselves
+func (server *AuthServer) ListApiKeys(
+	ctx context.Context,
+	req *pb.ListApiKeysRequest,
+) (*pb.ListApiKeysResponse, error) {
+	tenantID, err := TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := server.apiKeyStore.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list API keys: %v", err)
+	}
+
+	resp := &pb.ListApiKeysResponse{}
+	for _, apiKey := range apiKeys {
+		if !inTenant(tenantID, apiKey.TenantID) {
+			continue
+		}
+
+		pbAPIKey, err := toPBAPIKey(apiKey)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot convert API key: %v", err)
+		}
+		resp.ApiKeys = append(resp.ApiKeys, pbAPIKey)
+	}
+	return resp, nil
+}
+
+// RevokeApiKey is a unary RPC to revoke an API key, it takes effect from the next RPC
+func (server *AuthServer) RevokeApiKey(
+	ctx context.Context,
+	req *pb.RevokeApiKeyRequest,
+) (*pb.RevokeApiKeyResponse, error) {
+	tenantID, err := TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 其他租户的密钥对调用者来说不存在
+	apiKey, err := server.apiKeyStore.Find(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find API key: %v", err)
+	}
+	if apiKey == nil || !inTenant(tenantID, apiKey.TenantID) {
+		return nil, status.Errorf(codes.NotFound, "API key %s doesn't exist", req.GetId())
+	}
+
+	err = server.apiKeyStore.Revoke(req.GetId(), time.Now())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "API key %s doesn't exist", req.GetId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot revoke API key: %v", err)
+	}
+
+	return &pb.RevokeApiKeyResponse{}, nil
+}
+
+// EnrollTotp is a unary RPC to start enabling two-factor authentication for the current user,
+// it takes effect after the first code is confirmed with ConfirmTotp
+func (server *AuthServer) EnrollTotp(ctx context.Context, req *pb.EnrollTotpRequest) (*pb.EnrollTotpResponse, error) {
+	server.userMutex.Lock()
+	defer server.userMutex.Unlock()
+
+	user, err := server.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "two-factor authentication is already enabled")
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate TOTP secret: %v", err)
+	}
+
+	// 重新登记会替换尚未确认的密钥
+	user.TOTPSecret = secret
+	_, span := tracing.StartSpan(ctx, "UserStore.Update")
+	err = server.userStore.Update(user)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save user: %v", err)
+	}
+
+	resp := &pb.EnrollTotpResponse{
+		Secret:     secret,
+		OtpauthUri: TOTPURI(user.Username, secret),
+	}
+	return resp, nil
+}
+
+// ConfirmTotp is a unary RPC to verify the first code of an enrolled secret and enable two-factor authentication
+func (server *AuthServer) ConfirmTotp(ctx context.Context, req *pb.ConfirmTotpRequest) (*pb.ConfirmTotpResponse, error) {
+	server.userMutex.Lock()
+	defer server.userMutex.Unlock()
+
+	user, err := server.currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "two-factor authentication is already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "TOTP is not enrolled")
+	}
+
+	step, ok := VerifyTOTP(user.TOTPSecret, req.GetCode(), time.Now(), 0)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "incorrect one-time code")
+	}
+
+	recoveryCodes, hashedCodes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate recovery codes: %v", err)
+	}
+
+	user.TOTPEnabled = true
+	user.LastTOTPStep = step
+	user.RecoveryCodes = hashedCodes
+	_, span := tracing.StartSpan(ctx, "UserStore.Update")
+	err = server.userStore.Update(user)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save user: %v", err)
+	}
+
+	return &pb.ConfirmTotpResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+// currentUser finds the user who calls the RPC
+func (server *AuthServer) currentUser(ctx context.Context) (*User, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "caller is not authenticated")
+	}
+
+	_, span := tracing.StartSpan(ctx, "UserStore.Find")
+	user, err := server.userStore.Find(principal.Username)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+	if user == nil {
+		// 如API密钥或客户端证书，它们没有对应的用户
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is not a user", principal.Username)
+	}
+	return user, nil
+}
+
+// SetPasswordHasher replaces the hasher of new password hashes, the existing hashes are upgraded on login
+func (server *AuthServer) SetPasswordHasher(passwordHasher PasswordHasher) {
+	server.hasherMutex.Lock()
+	defer server.hasherMutex.Unlock()
+
+	server.passwordHasher = passwordHasher
+}
+
+// SetAccessPolicy replaces the access policy whose roles can be given to new API keys,
+// no API key can be created until a policy is set
+func (server *AuthServer) SetAccessPolicy(accessPolicy *AccessPolicy) {
+	server.policyMutex.Lock()
+	defer server.policyMutex.Unlock()
+
+	server.accessPolicy = accessPolicy
+}
+
+func (server *AuthServer) currentAccessPolicy() *AccessPolicy {
+	server.policyMutex.RLock()
+	defer server.policyMutex.RUnlock()
+
+	return server.accessPolicy
+}
+
+func (server *AuthServer) currentPasswordHasher() PasswordHasher {
+	server.hasherMutex.RLock()
+	defer server.hasherMutex.RUnlock()
+
+	return server.passwordHasher
+}
+
+// rehashPassword hashes the correct password of a user again with the hasher,
+// a failure is only logged because the user has logged in anyway
+func (server *AuthServer) rehashPassword(ctx context.Context, passwordHasher PasswordHasher, username string, password string) {
+	logger := logging.FromContext(ctx).With("username", username)
+	hashedPassword, err := passwordHasher.Hash(password)
+	if err != nil {
+		logger.Error("cannot rehash password", "error", err)
+		return
+	}
+
+	server.userMutex.Lock()
+	defer server.userMutex.Unlock()
+
+	// 重新读取用户，避免覆盖登录期间对用户的其他修改
+	user, err := server.userStore.Find(username)
+	if err != nil || user == nil {
+		logger.Error("cannot rehash password", "error", err)
+		return
+	}
+
+	user.HashedPassword = hashedPassword
+	err = server.userStore.Update(user)
+	if err != nil {
+		logger.Error("cannot rehash password", "error", err)
+		return
+	}
+	logger.Info("rehashed password")
+}
+
+// useOneTimeCode verifies a TOTP code or a recovery code of the user, and marks it as used
+func (server *AuthServer) useOneTimeCode(username string, code string) (bool, error) {
+	server.userMutex.Lock()
+	defer server.userMutex.Unlock()
+
+	user, err := server.userStore.Find(username)
+	if err != nil || user == nil {
+		return false, err
+	}
+
+	if step, ok := VerifyTOTP(user.TOTPSecret, code, time.Now(), user.LastTOTPStep); ok {
+		user.LastTOTPStep = step
+	} else if !user.UseRecoveryCode(code) {
+		return false, nil
+	}
+
+	err = server.userStore.Update(user)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func tooManyLoginAttempts(retryAfter time.Duration) error {
+	return throttled(
+		pb.ErrorReason_TOO_MANY_LOGIN_ATTEMPTS,
+		retryAfter,
+		fmt.Sprintf("too many failed login attempts, retry after %v", retryAfter.Round(time.Second)),
+	)
+}
+
+func toPBSession(session *Session) (*pb.Session, error) {
+	issuedAt, err := ptypes.TimestampProto(session.IssuedAt)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := ptypes.TimestampProto(session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.Session{
+		Id:        session.ID,
+		Username:  session.Username,
+		IssuedAt:  issuedAt,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// apiKeyTenant returns the tenant of a new API key: the tenant of the caller,
+// or the tenant in the request if the caller is a super admin
+func apiKeyTenant(ctx context.Context, req *pb.CreateApiKeyRequest) (string, error) {
+	tenantID, err := TenantFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if tenantID == AllTenants {
+		err = CheckTenantID(req.GetTenantId())
+		if err != nil {
+			return "", status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		return req.GetTenantId(), nil
+	}
+
+	// 租户管理员不能为其他租户创建密钥，也不能创建跨租户的超级管理员密钥
+	if req.GetTenantId() != "" && req.GetTenantId() != tenantID {
+		return "", status.Errorf(codes.PermissionDenied, "cannot create API key for another tenant")
+	}
+	if principal, ok := PrincipalFromContext(ctx); req.GetRole() == RoleSuperAdmin && (!ok || !principal.IsSuperAdmin()) {
+		return "", status.Errorf(codes.PermissionDenied, "only super admins can create %s API keys", RoleSuperAdmin)
+	}
+	return tenantID, nil
+}
+
+func toPBAPIKey(apiKey *APIKey) (*pb.ApiKey, error) {
+	createdAt, err := ptypes.TimestampProto(apiKey.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	pbAPIKey := &pb.ApiKey{
+		Id:        apiKey.ID,
+		Name:      apiKey.Name,
+		Role:      apiKey.Role,
+		Scopes:    apiKey.Scopes,
+		CreatedAt: createdAt,
+		TenantId:  apiKey.TenantID,
+	}
+	if apiKey.IsRevoked() {
+		pbAPIKey.RevokedAt, err = ptypes.TimestampProto(apiKey.RevokedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pbAPIKey, nil
+}
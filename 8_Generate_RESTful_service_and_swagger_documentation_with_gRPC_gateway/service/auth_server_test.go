@@ -0,0 +1,599 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"net"
+	"pcbook/pb"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthServerLogout(t *testing.T) {
+	t.Parallel()
+
+	userStore := NewInMemoryUserStore()
+	user, err := NewUser("alice", "secret", "user")
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(user))
+
+	sessionStore := NewInMemorySessionStore()
+	jwtManager := NewJWTManager("secret", time.Minute)
+	server := NewAuthServer(userStore, sessionStore, NewInMemoryAPIKeyStore(), jwtManager, newTestLoginLimiter(), nil, DefaultPasswordHasher)
+	interceptor := NewAuthInterceptor(jwtManager, sessionStore, NewInMemoryAPIKeyStore(), newTestAccessPolicy(t))
+
+	res, err := server.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: "secret"})
+	require.NoError(t, err)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", res.GetAccessToken()))
+	require.NoError(t, callProtected(ctx, interceptor))
+
+	sessions, err := server.ListUserSessions(context.Background(), &pb.ListUserSessionsRequest{Username: "alice"})
+	require.NoError(t, err)
+	require.Len(t, sessions.GetSessions(), 1)
+
+	_, err = server.Logout(ctx, &pb.LogoutRequest{})
+	require.NoError(t, err)
+
+	// 注销后，令牌不能再使用
+	err = callProtected(ctx, interceptor)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	sessions, err = server.ListUserSessions(context.Background(), &pb.ListUserSessionsRequest{Username: "alice"})
+	require.NoError(t, err)
+	require.Empty(t, sessions.GetSessions())
+}
+
+func TestAuthServerRevokeUserSessions(t *testing.T) {
+	t.Parallel()
+
+	userStore := NewInMemoryUserStore()
+	user, err := NewUser("bob", "secret", "user")
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(user))
+
+	sessionStore := NewInMemorySessionStore()
+	jwtManager := NewJWTManager("secret", time.Minute)
+	server := NewAuthServer(userStore, sessionStore, NewInMemoryAPIKeyStore(), jwtManager, newTestLoginLimiter(), nil, DefaultPasswordHasher)
+	interceptor := NewAuthInterceptor(jwtManager, sessionStore, NewInMemoryAPIKeyStore(), newTestAccessPolicy(t))
+
+	var contexts []context.Context
+	for i := 0; i < 2; i++ {
+		res, err := server.Login(context.Background(), &pb.LoginRequest{Username: "bob", Password: "secret"})
+		require.NoError(t, err)
+		contexts = append(contexts, metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", res.GetAccessToken())))
+	}
+
+	res, err := server.RevokeUserSessions(context.Background(), &pb.RevokeUserSessionsRequest{Username: "bob"})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, res.GetRevokedCount())
+
+	for _, ctx := range contexts {
+		err := callProtected(ctx, interceptor)
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+}
+
+func TestInMemorySessionStoreExpiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	store := NewInMemorySessionStore()
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Revoke("token-id", now.Add(time.Minute)))
+	revoked, err := store.IsRevoked("token-id")
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	// 令牌过期后，吊销记录也随之被清除
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, store.Add(&Session{ID: "other", ExpiresAt: now.Add(time.Minute)}))
+	require.Nil(t, store.sessions["token-id"])
+}
+
+func TestAuthServerLoginLockout(t *testing.T) {
+	t.Parallel()
+
+	userStore := NewInMemoryUserStore()
+	user, err := NewUser("carol", "secret", "user")
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(user))
+
+	now := time.Now()
+	loginLimiter := NewLoginLimiter(3, time.Second, 10*time.Second, time.Minute)
+	loginLimiter.now = func() time.Time { return now }
+	server := NewAuthServer(userStore, NewInMemorySessionStore(), NewInMemoryAPIKeyStore(), NewJWTManager("secret", time.Minute), loginLimiter, nil, DefaultPasswordHasher)
+
+	wrong := &pb.LoginRequest{Username: "carol", Password: "wrong"}
+	right := &pb.LoginRequest{Username: "carol", Password: "secret"}
+
+	_, err = server.Login(context.Background(), wrong)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// 退避期间，即使密码正确也会被拒绝
+	_, err = server.Login(context.Background(), right)
+	require.Equal(t, time.Second, requireRetryDelay(t, err))
+
+	now = now.Add(time.Second)
+	_, err = server.Login(context.Background(), wrong)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = server.Login(context.Background(), wrong)
+	require.Equal(t, 2*time.Second, requireRetryDelay(t, err))
+
+	now = now.Add(2 * time.Second)
+	_, err = server.Login(context.Background(), wrong)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// 失败次数达到上限，账户被锁定
+	now = now.Add(30 * time.Second)
+	_, err = server.Login(context.Background(), right)
+	require.Equal(t, 30*time.Second, requireRetryDelay(t, err))
+
+	res, err := server.UnlockAccount(context.Background(), &pb.UnlockAccountRequest{Username: "carol"})
+	require.NoError(t, err)
+	require.True(t, res.GetLocked())
+
+	_, err = server.Login(context.Background(), right)
+	require.NoError(t, err)
+}
+
+func TestAuthServerLoginConcurrentAttempts(t *testing.T) {
+	t.Parallel()
+
+	userStore := NewInMemoryUserStore()
+	for _, username := range []string{"dave", "frank"} {
+		user, err := NewUser(username, "secret", "user")
+		require.NoError(t, err)
+		require.NoError(t, userStore.Save(user))
+	}
+
+	loginLimiter := NewLoginLimiter(3, time.Minute, time.Hour, time.Hour)
+	server := NewAuthServer(userStore, NewInMemorySessionStore(), NewInMemoryAPIKeyStore(), NewJWTManager("secret", time.Minute), loginLimiter, nil, DefaultPasswordHasher)
+
+	// 并发的猜测中只有第一个被允许，其他的等待它的退避
+	const attempts = 10
+	errs := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		go func() {
+			_, err := server.Login(context.Background(), &pb.LoginRequest{Username: "dave", Password: "wrong"})
+			errs <- err
+		}()
+	}
+
+	tried := 0
+	for i := 0; i < attempts; i++ {
+		err := <-errs
+		if status.Code(err) == codes.NotFound {
+			tried++
+			continue
+		}
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	}
+	require.Equal(t, 1, tried)
+
+	// 成功的登录退还地址的尝试，同一地址的下一次登录不需要等待
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.7"), Port: 50000}})
+	for i := 0; i < 2; i++ {
+		_, err := server.Login(ctx, &pb.LoginRequest{Username: "frank", Password: "secret"})
+		require.NoError(t, err)
+	}
+}
+
+func TestAuthServerAdminTenantIsolation(t *testing.T) {
+	t.Parallel()
+
+	userStore := NewInMemoryUserStore()
+	for _, user := range []struct{ username, role, tenantID string }{
+		{"alice", "user", "a"},
+		{"bob", "user", "b"},
+		{"root", RoleSuperAdmin, DefaultTenantID},
+	} {
+		u, err := NewUser(user.username, "secret", user.role)
+		require.NoError(t, err)
+		u.TenantID = user.tenantID
+		require.NoError(t, userStore.Save(u))
+	}
+	server := NewAuthServer(userStore, NewInMemorySessionStore(), NewInMemoryAPIKeyStore(), NewJWTManager("secret", time.Minute), newTestLoginLimiter(), nil, DefaultPasswordHasher)
+
+	adminRoles := map[string]bool{"admin": true, "user": true}
+	adminA := ContextWithPrincipal(context.Background(), &Principal{Username: "admin-a", Role: "admin", Roles: adminRoles, TenantID: "a"})
+	adminDefault := ContextWithPrincipal(context.Background(), &Principal{Username: "admin", Role: "admin", Roles: adminRoles})
+	superAdmin := ContextWithPrincipal(context.Background(), &Principal{Username: "root", Role: RoleSuperAdmin, Roles: map[string]bool{RoleSuperAdmin: true, "admin": true, "user": true}})
+
+	_, err := server.Login(context.Background(), &pb.LoginRequest{Username: "bob", Password: "secret"})
+	require.NoError(t, err)
+
+	_, err = server.ListUserSessions(adminA, &pb.ListUserSessionsRequest{Username: "alice"})
+	require.NoError(t, err)
+
+	// 其他租户的用户和超级管理员对租户管理员来说不存在
+	for _, username := range []string{"bob", "root", "unknown"} {
+		_, err = server.ListUserSessions(adminA, &pb.ListUserSessionsRequest{Username: username})
+		require.Equal(t, codes.NotFound, status.Code(err), username)
+		_, err = server.RevokeUserSessions(adminA, &pb.RevokeUserSessionsRequest{Username: username})
+		require.Equal(t, codes.NotFound, status.Code(err), username)
+		_, err = server.UnlockAccount(adminA, &pb.UnlockAccountRequest{Username: username})
+		require.Equal(t, codes.NotFound, status.Code(err), username)
+	}
+	_, err = server.RevokeUserSessions(adminDefault, &pb.RevokeUserSessionsRequest{Username: "root"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// bob的会话没有被其他租户吊销
+	sessions, err := server.ListUserSessions(superAdmin, &pb.ListUserSessionsRequest{Username: "bob"})
+	require.NoError(t, err)
+	require.Len(t, sessions.GetSessions(), 1)
+	res, err := server.RevokeUserSessions(withTenantHeader(superAdmin, "b"), &pb.RevokeUserSessionsRequest{Username: "bob"})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, res.GetRevokedCount())
+	_, err = server.UnlockAccount(superAdmin, &pb.UnlockAccountRequest{Username: "root"})
+	require.NoError(t, err)
+}
+
+func TestAuthServerAPIKey(t *testing.T) {
+	t.Parallel()
+
+	sessionStore := NewInMemorySessionStore()
+	apiKeyStore := NewInMemoryAPIKeyStore()
+	jwtManager := NewJWTManager("secret", time.Minute)
+	server := NewAuthServer(NewInMemoryUserStore(), sessionStore, apiKeyStore, jwtManager, newTestLoginLimiter(), nil, DefaultPasswordHasher)
+	accessPolicy := newTestAccessPolicy(t)
+	interceptor := NewAuthInterceptor(jwtManager, sessionStore, apiKeyStore, accessPolicy)
+
+	// 没有策略时无法确认角色，不能创建密钥
+	_, err := server.CreateApiKey(context.Background(), &pb.CreateApiKeyRequest{Name: "batch", Role: "user"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	server.SetAccessPolicy(accessPolicy)
+	_, err = server.CreateApiKey(context.Background(), &pb.CreateApiKeyRequest{Name: "batch", Role: "root"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	res, err := server.CreateApiKey(context.Background(), &pb.CreateApiKeyRequest{Name: "batch", Role: "user", Scopes: []string{"/test/*"}})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(res.GetKey(), APIKeyPrefix))
+
+	// 只保存哈希值，不保存密钥明文
+	stored, err := apiKeyStore.Find(res.GetApiKey().GetId())
+	require.NoError(t, err)
+	require.NotContains(t, stored.HashedKey, res.GetKey())
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyHeader, res.GetKey()))
+	var principal *Principal
+	_, err = interceptor.Unary()(
+		ctx,
+		nil,
+		&grpc.UnaryServerInfo{FullMethod: "/test/Protected"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			principal, _ = PrincipalFromContext(ctx)
+			return nil, nil
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, "apikey:"+res.GetApiKey().GetId(), principal.Username)
+	require.True(t, principal.HasRole("user"))
+	require.Equal(t, res.GetApiKey().GetId(), principal.APIKeyID)
+
+	scoped, err := server.CreateApiKey(context.Background(), &pb.CreateApiKeyRequest{Name: "other", Role: "user", Scopes: []string{"/other/*"}})
+	require.NoError(t, err)
+	err = callProtected(metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyHeader, scoped.GetKey())), interceptor)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	wrongKey := res.GetKey()[:len(res.GetKey())-1] + "x"
+	err = callProtected(metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyHeader, wrongKey)), interceptor)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = server.RevokeApiKey(context.Background(), &pb.RevokeApiKeyRequest{Id: res.GetApiKey().GetId()})
+	require.NoError(t, err)
+	err = callProtected(ctx, interceptor)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	list, err := server.ListApiKeys(context.Background(), &pb.ListApiKeysRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetApiKeys(), 2)
+	require.NotNil(t, list.GetApiKeys()[0].GetRevokedAt())
+	require.Nil(t, list.GetApiKeys()[1].GetRevokedAt())
+
+	_, err = server.RevokeApiKey(context.Background(), &pb.RevokeApiKeyRequest{Id: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAuthServerCertificateBoundToken(t *testing.T) {
+	t.Parallel()
+
+	userStore := NewInMemoryUserStore()
+	user, err := NewUser("dave", "secret", "user")
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(user))
+
+	policy, err := ParseAccessPolicy([]byte(`{
+		"default": "deny",
+		"roles": {"user": {}},
+		"rules": [
+			{"method": "/pcbook.pbfiles.AuthService/Login", "public": true},
+			{"method": "/pcbook.pbfiles.AuthService/*", "roles": ["user"]}
+		]
+	}`))
+	require.NoError(t, err)
+
+	sessionStore := NewInMemorySessionStore()
+	jwtManager := NewJWTManager("secret", time.Minute)
+	jwtManager.SetCertificateBinding(true)
+	server := NewAuthServer(userStore, sessionStore, NewInMemoryAPIKeyStore(), jwtManager, newTestLoginLimiter(), nil, DefaultPasswordHasher)
+	interceptor := NewAuthInterceptor(jwtManager, sessionStore, NewInMemoryAPIKeyStore(), policy)
+
+	serverAddress := startTestMTLSAuthServer(t, server, interceptor)
+	mtlsClient := newTestTLSAuthClient(t, serverAddress, true)
+	tlsClient := newTestTLSAuthClient(t, serverAddress, false)
+
+	login := &pb.LoginRequest{Username: "dave", Password: "secret"}
+	listSessions := &pb.ListUserSessionsRequest{Username: "dave"}
+
+	res, err := mtlsClient.Login(context.Background(), login)
+	require.NoError(t, err)
+
+	claims, err := jwtManager.Verify(res.GetAccessToken())
+	require.NoError(t, err)
+	require.NotNil(t, claims.Confirmation)
+	require.Equal(t, CertificateThumbprint(loadTestCertificate(t, "../cert/client-cert.pem")), claims.Confirmation.X5TS256)
+
+	boundCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", res.GetAccessToken())
+	_, err = mtlsClient.ListUserSessions(boundCtx, listSessions)
+	require.NoError(t, err)
+
+	// 在没有出示该证书的连接上重放令牌会被拒绝
+	_, err = tlsClient.ListUserSessions(boundCtx, listSessions)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// 没有客户端证书时签发的令牌不绑定证书
+	res, err = tlsClient.Login(context.Background(), login)
+	require.NoError(t, err)
+	claims, err = jwtManager.Verify(res.GetAccessToken())
+	require.NoError(t, err)
+	require.Nil(t, claims.Confirmation)
+
+	unboundCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", res.GetAccessToken())
+	_, err = tlsClient.ListUserSessions(unboundCtx, listSessions)
+	require.NoError(t, err)
+}
+
+func TestAuthServerTOTP(t *testing.T) {
+	t.Parallel()
+
+	userStore := NewInMemoryUserStore()
+	user, err := NewUser("erin", "secret", "admin")
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(user))
+
+	policy, err := ParseAccessPolicy([]byte(`{
+		"default": "deny",
+		"roles": {"user": {}, "admin": {"inherits": ["user"], "require_totp": true}},
+		"rules": [
+			{"method": "/test/Protected", "roles": ["user"]},
+			{"method": "/pcbook.pbfiles.AuthService/*", "roles": ["user"]}
+		]
+	}`))
+	require.NoError(t, err)
+
+	sessionStore := NewInMemorySessionStore()
+	jwtManager := NewJWTManager("secret", time.Minute)
+	// 不退避，以便连续测试错误的验证码
+	loginLimiter := NewLoginLimiter(10, 0, 0, time.Minute)
+	server := NewAuthServer(userStore, sessionStore, NewInMemoryAPIKeyStore(), jwtManager, loginLimiter, nil, DefaultPasswordHasher)
+	interceptor := NewAuthInterceptor(jwtManager, sessionStore, NewInMemoryAPIKeyStore(), policy)
+
+	login := func(otpCode string) (context.Context, error) {
+		req := &pb.LoginRequest{Username: "erin", Password: "secret", OtpCode: otpCode}
+		res, err := server.Login(context.Background(), req)
+		if err != nil {
+			return nil, err
+		}
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", res.GetAccessToken())), nil
+	}
+
+	// 必须启用两步验证的角色，在登记之前只能调用登记相关的方法
+	ctx, err := login("")
+	require.NoError(t, err)
+	require.Equal(t, codes.PermissionDenied, status.Code(callProtected(ctx, interceptor)))
+
+	var enrollment *pb.EnrollTotpResponse
+	err = callMethod(ctx, interceptor, "/pcbook.pbfiles.AuthService/EnrollTotp", func(ctx context.Context) (err error) {
+		enrollment, err = server.EnrollTotp(ctx, &pb.EnrollTotpRequest{})
+		return err
+	})
+	require.NoError(t, err)
+	require.Contains(t, enrollment.GetOtpauthUri(), enrollment.GetSecret())
+
+	var confirmation *pb.ConfirmTotpResponse
+	confirm := func(code string) error {
+		return callMethod(ctx, interceptor, "/pcbook.pbfiles.AuthService/ConfirmTotp", func(ctx context.Context) (err error) {
+			confirmation, err = server.ConfirmTotp(ctx, &pb.ConfirmTotpRequest{Code: code})
+			return err
+		})
+	}
+	require.Equal(t, codes.InvalidArgument, status.Code(confirm("000000")))
+
+	code, err := TOTPCode(enrollment.GetSecret(), time.Now())
+	require.NoError(t, err)
+	require.NoError(t, confirm(code))
+	recoveryCodes := confirmation.GetRecoveryCodes()
+	require.Len(t, recoveryCodes, recoveryCodeCount)
+
+	stored, err := userStore.Find("erin")
+	require.NoError(t, err)
+	require.NotContains(t, stored.RecoveryCodes, recoveryCodes[0])
+
+	// 缺少验证码与错误的验证码无法区分，都计为失败
+	_, err = login("")
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, wrongCodeErr := login("000000")
+	require.Equal(t, err.Error(), wrongCodeErr.Error())
+	require.Equal(t, 2, loginLimiter.attempts[UserKey("erin")].failures)
+
+	// 确认时已使用了当前的验证码，用下一个时间步长的验证码登录
+	code, err = TOTPCode(enrollment.GetSecret(), time.Now().Add(totpPeriod))
+	require.NoError(t, err)
+	ctx, err = login(code)
+	require.NoError(t, err)
+	require.NoError(t, callProtected(ctx, interceptor))
+
+	_, err = login(code)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// 恢复码只能使用一次
+	_, err = login(recoveryCodes[0])
+	require.NoError(t, err)
+	_, err = login(recoveryCodes[0])
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestClientAddress(t *testing.T) {
+	t.Parallel()
+
+	proxies, err := ParseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	require.NoError(t, err)
+
+	md := metadata.Pairs("x-forwarded-for", "1.1.1.1, 2.2.2.2")
+	fromGateway := peer.NewContext(
+		metadata.NewIncomingContext(context.Background(), md),
+		&peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 50000}},
+	)
+	require.Equal(t, "2.2.2.2", ClientAddress(fromGateway, proxies))
+
+	// 不受信任的调用方伪造的x-forwarded-for会被忽略
+	direct := peer.NewContext(
+		metadata.NewIncomingContext(context.Background(), md),
+		&peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.7"), Port: 50000}},
+	)
+	require.Equal(t, "192.168.1.7", ClientAddress(direct, proxies))
+}
+
+func requireRetryDelay(t *testing.T, err error) time.Duration {
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.ResourceExhausted, st.Code())
+
+	for _, detail := range st.Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok {
+			delay, err := ptypes.Duration(retryInfo.GetRetryDelay())
+			require.NoError(t, err)
+			return delay
+		}
+	}
+	require.FailNow(t, "no retry info in error details")
+	return 0
+}
+
+// testCertTime is within the validity period of the certificates in the cert folder
+var testCertTime = time.Date(2020, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+func startTestMTLSAuthServer(t *testing.T, authServer pb.AuthServiceServer, interceptor *AuthInterceptor) string {
+	serverCert, err := tls.LoadX509KeyPair("../cert/server-cert.pem", "../cert/server-key.pem")
+	require.NoError(t, err)
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    newTestCertPool(t),
+		Time:         func() time.Time { return testCertTime },
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(config)),
+		grpc.UnaryInterceptor(interceptor.Unary()),
+	)
+	pb.RegisterAuthServiceServer(grpcServer, authServer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	return listener.Addr().String()
+}
+
+func newTestTLSAuthClient(t *testing.T, serverAddress string, withClientCert bool) pb.AuthServiceClient {
+	config := &tls.Config{
+		RootCAs:    newTestCertPool(t),
+		ServerName: "pcbook.ezzz.com",
+		Time:       func() time.Time { return testCertTime },
+	}
+	if withClientCert {
+		clientCert, err := tls.LoadX509KeyPair("../cert/client-cert.pem", "../cert/client-key.pem")
+		require.NoError(t, err)
+		config.Certificates = []tls.Certificate{clientCert}
+	}
+
+	conn, err := grpc.Dial(serverAddress, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewAuthServiceClient(conn)
+}
+
+func newTestCertPool(t *testing.T) *x509.CertPool {
+	pemCA, err := ioutil.ReadFile("../cert/ca-cert.pem")
+	require.NoError(t, err)
+
+	certPool := x509.NewCertPool()
+	require.True(t, certPool.AppendCertsFromPEM(pemCA))
+	return certPool
+}
+
+func loadTestCertificate(t *testing.T, filename string) *x509.Certificate {
+	cert, err := tls.LoadX509KeyPair(filename, strings.Replace(filename, "-cert.pem", "-key.pem", 1))
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf
+}
+
+func callMethod(ctx context.Context, interceptor *AuthInterceptor, method string, call func(ctx context.Context) error) error {
+	_, err := interceptor.Unary()(
+		ctx,
+		nil,
+		&grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, call(ctx)
+		},
+	)
+	return err
+}
+
+func newTestLoginLimiter() *LoginLimiter {
+	return NewLoginLimiter(3, time.Millisecond, time.Second, time.Minute)
+}
+
+func newTestAccessPolicy(t *testing.T) *AccessPolicy {
+	policy, err := ParseAccessPolicy([]byte(`{
+		"default": "deny",
+		"roles": {"user": {}, "admin": {"inherits": ["user"]}},
+		"rules": [{"method": "/test/Protected", "roles": ["user"]}]
+	}`))
+	require.NoError(t, err)
+	return policy
+}
+
+func callProtected(ctx context.Context, interceptor *AuthInterceptor) error {
+	_, err := interceptor.Unary()(
+		ctx,
+		nil,
+		&grpc.UnaryServerInfo{FullMethod: "/test/Protected"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		},
+	)
+	return err
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"strings"
+)
+
+// TrustedProxies is a list of networks whose x-forwarded-for metadata is trusted,
+// such as the address of the REST gateway
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a list of IP addresses or CIDR networks
+func ParseTrustedProxies(addresses []string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(addresses))
+	for _, address := range addresses {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+
+		if !strings.Contains(address, "/") {
+			if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+				address += "/32"
+			} else {
+				address += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", address, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// Contains checks if the IP address belongs to a trusted proxy
+func (proxies TrustedProxies) Contains(ip net.IP) bool {
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientAddress returns the IP address of the caller. If the RPC comes from a trusted proxy like the REST gateway,
+// or from the in-process gateway of the combined server,
+// the address the proxy appended to x-forwarded-for is used instead of the address of the proxy itself.
+func ClientAddress(ctx context.Context, trustedProxies TrustedProxies) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	address := p.Addr.String()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+
+	// 进程内的连接只可能来自同一进程中的REST网关
+	if p.Addr.Network() != InProcessNetwork {
+		ip := net.ParseIP(address)
+		if ip == nil || !trustedProxies.Contains(ip) {
+			return address
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("x-forwarded-for")
+	if len(values) == 0 {
+		return address
+	}
+
+	// 网关把它看到的客户端地址追加在最后，前面的部分可能由客户端伪造
+	forwarded := strings.Split(values[len(values)-1], ",")
+	client := strings.TrimSpace(forwarded[len(forwarded)-1])
+	if client == "" {
+		return address
+	}
+	return client
+}
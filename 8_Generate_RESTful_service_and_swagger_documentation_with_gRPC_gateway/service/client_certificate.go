@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientCertificate maps verified client certificates to a role and a tenant.
+// Every selector that is set must match, a certificate matches a SAN selector if any of its SANs equals it.
+type ClientCertificate struct {
+	CommonName string `json:"common_name"`
+	URI        string `json:"uri"` // 如SPIFFE ID：spiffe://pcbook/batch
+	DNSName    string `json:"dns_name"`
+	Role       string `json:"role"`
+	TenantID   string `json:"tenant_id"` // 为空时属于默认租户
+}
+
+func (mapping *ClientCertificate) check(roles map[string]RoleDefinition) error {
+	if mapping.CommonName == "" && mapping.URI == "" && mapping.DNSName == "" {
+		return fmt.Errorf("client certificate mapping needs a common name, URI or DNS name")
+	}
+	if _, ok := roles[mapping.Role]; !ok {
+		return fmt.Errorf("client certificate mapping refers to unknown role %q", mapping.Role)
+	}
+	return CheckTenantID(mapping.TenantID)
+}
+
+// Matches checks if the certificate is selected by this mapping
+func (mapping *ClientCertificate) Matches(cert *x509.Certificate) bool {
+	if mapping.CommonName != "" && mapping.CommonName != cert.Subject.CommonName {
+		return false
+	}
+	if mapping.URI != "" && !hasURI(cert, mapping.URI) {
+		return false
+	}
+	if mapping.DNSName != "" && !hasDNSName(cert, mapping.DNSName) {
+		return false
+	}
+	return true
+}
+
+func hasURI(cert *x509.Certificate, uri string) bool {
+	for _, certURI := range cert.URIs {
+		if certURI.String() == uri {
+			return true
+		}
+	}
+	return false
+}
+
+func hasDNSName(cert *x509.Certificate, dnsName string) bool {
+	for _, certDNSName := range cert.DNSNames {
+		if certDNSName == dnsName {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateIdentity returns a name for the certificate: its common name, or its first SAN URI or DNS name
+func certificateIdentity(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	default:
+		return cert.SerialNumber.String()
+	}
+}
+
+// verifiedPeerCertificate returns the client certificate of the connection,
+// only if the TLS handshake has verified it against the client CAs
+func verifiedPeerCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, false
+	}
+
+	// 未验证的证书（如服务端不要求客户端证书时）不能作为身份
+	chains := tlsInfo.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil, false
+	}
+	return chains[0][0], true
+}
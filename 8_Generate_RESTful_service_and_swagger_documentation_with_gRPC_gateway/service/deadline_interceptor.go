@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"pcbook/logging"
+	"pcbook/pb"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeadlineRule bounds the RPCs of a method, or of all the methods if Method is "*".
+// The rule of the called method replaces the rule of "*", the zero values mean no limit.
+type DeadlineRule struct {
+	Method string
+	// Timeout is the maximum deadline of a call, for streams the maximum total duration.
+	// The earlier deadline of the client is kept.
+	Timeout     time.Duration
+	IdleTimeout time.Duration // 流等待下一条消息的最长时间
+	MaxMessages int           // 流从客户端接收的最多消息数
+}
+
+// DeadlinePolicy chooses the deadline rule of the methods
+type DeadlinePolicy struct {
+	methodRules map[string]*DeadlineRule
+}
+
+// NewDeadlinePolicy checks the rules and returns a new deadline policy
+func NewDeadlinePolicy(rules []DeadlineRule) (*DeadlinePolicy, error) {
+	policy := &DeadlinePolicy{
+		methodRules: make(map[string]*DeadlineRule),
+	}
+
+	for i := range rules {
+		rule := rules[i]
+		if rule.Method != "*" {
+			_, method, err := splitMethodName(rule.Method)
+			if err != nil {
+				return nil, err
+			}
+			if method == "*" {
+				return nil, fmt.Errorf("deadline rule %q: use \"*\" or a single method", rule.Method)
+			}
+		}
+		if rule.Timeout < 0 || rule.IdleTimeout < 0 || rule.MaxMessages < 0 {
+			return nil, fmt.Errorf("deadline rule %q: timeout, idle_timeout and max_messages must not be negative", rule.Method)
+		}
+		if policy.methodRules[rule.Method] != nil {
+			return nil, fmt.Errorf("duplicate deadline rule %q", rule.Method)
+		}
+
+		policy.methodRules[rule.Method] = &rule
+	}
+	return policy, nil
+}
+
+// CheckMethods checks if the methods of the rules are served
+func (policy *DeadlinePolicy) CheckMethods(services map[string]grpc.ServiceInfo) error {
+	for fullMethod := range policy.methodRules {
+		if fullMethod == "*" {
+			continue
+		}
+
+		service, method, _ := splitMethodName(fullMethod)
+		info, ok := services[service]
+		if !ok || !hasMethod(info, method) {
+			return fmt.Errorf("deadline rule %q refers to an unknown method", fullMethod)
+		}
+	}
+	return nil
+}
+
+// Rule returns the rule of the method, or the rule of "*", or nil if no rule applies
+func (policy *DeadlinePolicy) Rule(fullMethod string) *DeadlineRule {
+	if rule := policy.methodRules[fullMethod]; rule != nil {
+		return rule
+	}
+	return policy.methodRules["*"]
+}
+
+// DeadlineInterceptor is a server interceptor that bounds the duration of the RPCs, even if the clients set no deadline,
+// and closes the streams of the clients that stop sending or send too many messages.
+// The handlers and the stores see the deadline in their context.
+type DeadlineInterceptor struct {
+	mutex  sync.RWMutex
+	policy *DeadlinePolicy
+}
+
+// NewDeadlineInterceptor returns a new deadline interceptor
+func NewDeadlineInterceptor(policy *DeadlinePolicy) *DeadlineInterceptor {
+	return &DeadlineInterceptor{
+		policy: policy,
+	}
+}
+
+// Policy returns the current deadline policy
+func (interceptor *DeadlineInterceptor) Policy() *DeadlinePolicy {
+	interceptor.mutex.RLock()
+	defer interceptor.mutex.RUnlock()
+
+	return interceptor.policy
+}
+
+// SetPolicy replaces the deadline policy, it takes effect from the next RPC
+func (interceptor *DeadlineInterceptor) SetPolicy(policy *DeadlinePolicy) {
+	interceptor.mutex.Lock()
+	defer interceptor.mutex.Unlock()
+
+	interceptor.policy = policy
+}
+
+// Unary returns a server interceptor function to bound unary RPC
+func (interceptor *DeadlineInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		rule := interceptor.Policy().Rule(info.FullMethod)
+		if rule == nil || rule.Timeout == 0 || strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(ctx, req)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, rule.Timeout)
+		defer cancel()
+
+		res, err := handler(ctx, req)
+		if err != nil && ctx.Err() != nil {
+			// 处理函数可能把取消包装成了其他错误
+			return nil, contextError(ctx)
+		}
+		return res, err
+	}
+}
+
+// Stream returns a server interceptor function to bound stream RPC
+func (interceptor *DeadlineInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		rule := interceptor.Policy().Rule(info.FullMethod)
+		// 健康检查的Watch是长期打开的流，不受限制
+		if rule == nil || (rule.Timeout == 0 && rule.IdleTimeout == 0 && rule.MaxMessages == 0) ||
+			strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(srv, stream)
+		}
+
+		ctx := stream.Context()
+		if rule.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, rule.Timeout)
+			defer cancel()
+		}
+
+		deadlineStream := newDeadlineServerStream(ctx, stream, rule)
+		defer deadlineStream.close()
+
+		err := handler(srv, deadlineStream)
+
+		// 处理函数会把接收的错误包装成Unknown，这里返回原来的错误
+		if deadlineStream.err != nil {
+			logging.FromContext(ctx).Warn("stream is closed by the server", "error", status.Convert(deadlineStream.err).Message())
+			return deadlineStream.err
+		}
+		if err != nil && ctx.Err() != nil {
+			return contextError(ctx)
+		}
+		return err
+	}
+}
+
+// deadlineServerStream receives the messages within the limits of the rule.
+// A blocked RecvMsg cannot be interrupted, so the messages are received by one goroutine per stream,
+// that returns when the RPC ends after the handler has returned the error.
+// After a timeout, that goroutine may still write the message passed to RecvMsg, so the handler must not reuse it.
+type deadlineServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	rule     *DeadlineRule
+	messages int
+	err      error
+	requests chan interface{} // 要接收的消息，交给接收的goroutine
+	received chan error
+}
+
+func newDeadlineServerStream(ctx context.Context, stream grpc.ServerStream, rule *DeadlineRule) *deadlineServerStream {
+	deadlineStream := &deadlineServerStream{
+		ServerStream: stream,
+		ctx:          ctx,
+		rule:         rule,
+		requests:     make(chan interface{}),
+		// 有缓冲，超时后接收的goroutine仍然可以返回结果并退出
+		received: make(chan error, 1),
+	}
+	go deadlineStream.receive()
+	return deadlineStream
+}
+
+func (stream *deadlineServerStream) receive() {
+	for m := range stream.requests {
+		stream.received <- stream.ServerStream.RecvMsg(m)
+	}
+}
+
+// close stops the receiving goroutine once its last RecvMsg returns, it is called after the handler has returned
+func (stream *deadlineServerStream) close() {
+	close(stream.requests)
+}
+
+func (stream *deadlineServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *deadlineServerStream) RecvMsg(m interface{}) error {
+	// 前一次接收失败后，接收的goroutine可能还在运行，不能再次接收
+	if stream.err != nil {
+		return stream.err
+	}
+
+	stream.requests <- m
+
+	var idle <-chan time.Time
+	if stream.rule.IdleTimeout > 0 {
+		timer := time.NewTimer(stream.rule.IdleTimeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+
+	select {
+	case err := <-stream.received:
+		if err != nil {
+			return err
+		}
+	case <-idle:
+		stream.err = errorWithReason(
+			codes.DeadlineExceeded,
+			pb.ErrorReason_STREAM_IDLE_TIMEOUT,
+			nil,
+			fmt.Sprintf("no message received for %v", stream.rule.IdleTimeout),
+		)
+		return stream.err
+	case <-stream.ctx.Done():
+		stream.err = contextError(stream.ctx)
+		return stream.err
+	}
+
+	stream.messages++
+	if stream.rule.MaxMessages > 0 && stream.messages > stream.rule.MaxMessages {
+		stream.err = errorWithReason(
+			codes.ResourceExhausted,
+			pb.ErrorReason_TOO_MANY_MESSAGES,
+			nil,
+			fmt.Sprintf("too many messages, the stream accepts at most %d", stream.rule.MaxMessages),
+		)
+		return stream.err
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"pcbook/client"
+	"pcbook/pb"
+	"pcbook/sample"
+	"testing"
+	"time"
+)
+
+func TestDeadlinePolicy(t *testing.T) {
+	t.Parallel()
+
+	rateLaptop := "/pcbook.pbfiles.LaptopService/RateLaptop"
+	policy, err := NewDeadlinePolicy([]DeadlineRule{
+		{Method: "*", Timeout: time.Second},
+		{Method: rateLaptop, IdleTimeout: time.Second, MaxMessages: 10},
+	})
+	require.NoError(t, err)
+
+	// 方法的规则替换*的规则，而不是与它合并
+	require.Equal(t, &DeadlineRule{Method: rateLaptop, IdleTimeout: time.Second, MaxMessages: 10}, policy.Rule(rateLaptop))
+	require.Equal(t, time.Second, policy.Rule("/pcbook.pbfiles.LaptopService/CreateLaptop").Timeout)
+
+	empty, err := NewDeadlinePolicy(nil)
+	require.NoError(t, err)
+	require.Nil(t, empty.Rule(rateLaptop))
+
+	invalidRules := [][]DeadlineRule{
+		{{Method: "RateLaptop"}},
+		{{Method: "/pcbook.pbfiles.LaptopService/*"}},
+		{{Method: "*", Timeout: -time.Second}},
+		{{Method: "*"}, {Method: "*", MaxMessages: 1}},
+	}
+	for _, rules := range invalidRules {
+		_, err := NewDeadlinePolicy(rules)
+		require.Error(t, err, "%+v", rules)
+	}
+
+	unknown, err := NewDeadlinePolicy([]DeadlineRule{{Method: "/pcbook.pbfiles.LaptopService/RemoveLaptop"}})
+	require.NoError(t, err)
+	grpcServer := grpc.NewServer()
+	pb.RegisterLaptopServiceServer(grpcServer, &pb.UnimplementedLaptopServiceServer{})
+	require.NoError(t, policy.CheckMethods(grpcServer.GetServiceInfo()))
+	require.Error(t, unknown.CheckMethods(grpcServer.GetServiceInfo()))
+}
+
+func TestDeadlineInterceptorUnary(t *testing.T) {
+	t.Parallel()
+
+	policy, err := NewDeadlinePolicy([]DeadlineRule{{Method: "*", Timeout: 50 * time.Millisecond}})
+	require.NoError(t, err)
+	interceptor := NewDeadlineInterceptor(policy)
+	info := &grpc.UnaryServerInfo{FullMethod: "/pcbook.pbfiles.LaptopService/CreateLaptop"}
+
+	// 处理函数把取消包装成了其他错误，调用者仍然得到DeadlineExceeded
+	start := time.Now()
+	_, err = interceptor.Unary()(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, status.Errorf(codes.Internal, "cannot save laptop: %v", ctx.Err())
+	})
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// 客户端更早的期限保持不变
+	clientCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	clientDeadline, _ := clientCtx.Deadline()
+	_, err = interceptor.Unary()(clientCtx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		deadline, ok := ctx.Deadline()
+		require.True(t, ok)
+		require.Equal(t, clientDeadline, deadline)
+		return "res", nil
+	})
+	require.NoError(t, err)
+
+	// 关闭期限后没有上限
+	empty, err := NewDeadlinePolicy(nil)
+	require.NoError(t, err)
+	interceptor.SetPolicy(empty)
+	_, err = interceptor.Unary()(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, ok := ctx.Deadline()
+		require.False(t, ok)
+		return "res", nil
+	})
+	require.NoError(t, err)
+}
+
+func TestDeadlineInterceptorStream(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := &blockingLaptopStore{InMemoryLaptopStore: NewInMemoryLaptopStore(), canceled: make(chan error, 1)}
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(DefaultTenantID, laptop))
+
+	policy, err := NewDeadlinePolicy([]DeadlineRule{
+		{Method: "*", Timeout: 100 * time.Millisecond},
+		{Method: "/pcbook.pbfiles.LaptopService/RateLaptop", Timeout: time.Minute, IdleTimeout: 100 * time.Millisecond, MaxMessages: 2},
+	})
+	require.NoError(t, err)
+	serverAddress := startTestDeadlineServer(t, laptopStore, NewDeadlineInterceptor(policy))
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	// 客户端没有设置期限，搜索在服务端的期限到达时被取消
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 5000}})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	require.Equal(t, context.DeadlineExceeded, <-laptopStore.canceled)
+
+	// 客户端打开流之后不再发送消息
+	rateStream, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	_, err = rateStream.Recv()
+	details := client.ParseErrorDetails(err)
+	require.Equal(t, codes.DeadlineExceeded, details.Code)
+	require.Equal(t, pb.ErrorReason_STREAM_IDLE_TIMEOUT, details.Reason)
+
+	// 第三条消息超过了上限
+	rateStream, err = laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, rateStream.Send(&pb.RateLaptopRequest{LaptopId: laptop.Id, Score: 5}))
+		res, err := rateStream.Recv()
+		require.NoError(t, err)
+		require.EqualValues(t, i+1, res.GetRatedCount())
+	}
+	require.NoError(t, rateStream.Send(&pb.RateLaptopRequest{LaptopId: laptop.Id, Score: 5}))
+	_, err = rateStream.Recv()
+	details = client.ParseErrorDetails(err)
+	require.Equal(t, codes.ResourceExhausted, details.Code)
+	require.Equal(t, pb.ErrorReason_TOO_MANY_MESSAGES, details.Reason)
+}
+
+// startTestDeadlineServer starts a laptop server behind the deadline interceptor, and returns its address
+func startTestDeadlineServer(t *testing.T, laptopStore LaptopStore, interceptor *DeadlineInterceptor) string {
+	chain := NewInterceptorChain().Add(StageDeadline, interceptor)
+	grpcServer := grpc.NewServer(chain.ServerOptions()...)
+	pb.RegisterLaptopServiceServer(grpcServer, NewLaptopServer(laptopStore, nil, NewInMemoryRatingStore()))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return listener.Addr().String()
+}
+
+// blockingLaptopStore is a laptop store whose searches last until they are canceled, like a slow database
+type blockingLaptopStore struct {
+	*InMemoryLaptopStore
+	canceled chan error
+}
+
+func (store *blockingLaptopStore) Search(ctx context.Context, tenantID string, filter *pb.Filter, found func(laptop *pb.Laptop) error) error {
+	<-ctx.Done()
+	store.canceled <- ctx.Err()
+	return fmt.Errorf("search is canceled: %w", ctx.Err())
+}
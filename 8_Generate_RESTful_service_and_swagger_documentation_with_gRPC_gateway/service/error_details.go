@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"pcbook/pb"
+	"pcbook/validate"
+	"time"
+)
+
+// ErrorDomain is the domain of the ErrorInfo details of the errors, their reasons are the names of pb.ErrorReason
+const ErrorDomain = "pcbook"
+
+// LaptopResourceType is the resource type of the ResourceInfo details about laptops
+const LaptopResourceType = "pcbook.pbfiles.Laptop"
+
+// errorWithReason returns a status error with an ErrorInfo detail of the reason, followed by the other details
+func errorWithReason(code codes.Code, reason pb.ErrorReason, metadata map[string]string, message string, details ...proto.Message) error {
+	st := status.New(code, message)
+	details = append([]proto.Message{&errdetails.ErrorInfo{
+		Reason:   reason.String(),
+		Domain:   ErrorDomain,
+		Metadata: metadata,
+	}}, details...)
+
+	detailed, err := st.WithDetails(details...)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// invalidField returns an InvalidArgument error with a BadRequest detail of the invalid field.
+// The field is the path of the field in the request, such as "laptop.id".
+func invalidField(reason pb.ErrorReason, field string, description string) error {
+	return errorWithReason(
+		codes.InvalidArgument,
+		reason,
+		nil,
+		fmt.Sprintf("invalid %s: %s", field, description),
+		&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: field, Description: description}},
+		},
+	)
+}
+
+// invalidFields returns an InvalidArgument error with a BadRequest detail of all the violations of the validation rules
+func invalidFields(err *validate.Error) error {
+	return errorWithReason(
+		codes.InvalidArgument,
+		pb.ErrorReason_INVALID_FIELDS,
+		nil,
+		err.Error(),
+		&errdetails.BadRequest{FieldViolations: err.Violations},
+	)
+}
+
+// laptopNotFound returns a NotFound error with a ResourceInfo detail of the laptop
+func laptopNotFound(laptopID string) error {
+	return errorWithReason(
+		codes.NotFound,
+		pb.ErrorReason_LAPTOP_NOT_FOUND,
+		map[string]string{"laptop_id": laptopID},
+		fmt.Sprintf("laptop %s doesn't exist", laptopID),
+		&errdetails.ResourceInfo{ResourceType: LaptopResourceType, ResourceName: laptopID, Description: "laptop doesn't exist"},
+	)
+}
+
+// laptopAlreadyExists returns an AlreadyExists error with a ResourceInfo detail of the laptop
+func laptopAlreadyExists(laptopID string) error {
+	return errorWithReason(
+		codes.AlreadyExists,
+		pb.ErrorReason_LAPTOP_ALREADY_EXISTS,
+		map[string]string{"laptop_id": laptopID},
+		fmt.Sprintf("laptop %s already exists", laptopID),
+		&errdetails.ResourceInfo{ResourceType: LaptopResourceType, ResourceName: laptopID, Description: "laptop already exists"},
+	)
+}
+
+// throttled returns a ResourceExhausted error with a RetryInfo detail, so that the clients know when to retry
+func throttled(reason pb.ErrorReason, retryAfter time.Duration, message string) error {
+	return errorWithReason(
+		codes.ResourceExhausted,
+		reason,
+		nil,
+		message,
+		&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(retryAfter)},
+	)
+}
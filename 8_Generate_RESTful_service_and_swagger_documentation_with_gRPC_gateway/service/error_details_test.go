@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang/protobuf/proto"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"pcbook/client"
+	"pcbook/pb"
+	"pcbook/sample"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLaptopServerErrorDetails(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	server := NewLaptopServer(store, nil, nil)
+
+	owned := sample.NewLaptop()
+	owner := &Principal{Username: "alice", Role: "editor", Roles: map[string]bool{"editor": true, "user": true}}
+	_, err := server.CreateLaptop(ContextWithPrincipal(context.Background(), owner), &pb.CreateLaptopRequest{Laptop: owned})
+	require.NoError(t, err)
+	other := &Principal{Username: "bob", Role: "editor", Roles: map[string]bool{"editor": true, "user": true}}
+
+	invalidLaptop := sample.NewLaptop()
+	invalidLaptop.Id = "invalid_uuid"
+	missingID := sample.NewLaptop().Id
+
+	testCases := []struct {
+		name   string
+		call   func() error
+		code   codes.Code
+		reason pb.ErrorReason
+		field  string
+		// resource is the name of the laptop in the ResourceInfo detail, empty without it
+		resource string
+	}{
+		{
+			name: "invalid_id",
+			call: func() error {
+				_, err := server.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: invalidLaptop})
+				return err
+			},
+			code:   codes.InvalidArgument,
+			reason: pb.ErrorReason_INVALID_LAPTOP_ID,
+			field:  "laptop.id",
+		},
+		{
+			name: "already_exists",
+			call: func() error {
+				_, err := server.CreateLaptop(ContextWithPrincipal(context.Background(), owner), &pb.CreateLaptopRequest{Laptop: owned})
+				return err
+			},
+			code:     codes.AlreadyExists,
+			reason:   pb.ErrorReason_LAPTOP_ALREADY_EXISTS,
+			resource: owned.Id,
+		},
+		{
+			name: "not_found",
+			call: func() error {
+				_, err := server.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: missingID})
+				return err
+			},
+			code:     codes.NotFound,
+			reason:   pb.ErrorReason_LAPTOP_NOT_FOUND,
+			resource: missingID,
+		},
+		{
+			name: "upload_not_found",
+			call: func() error {
+				info := &pb.UploadImageRequest{Data: &pb.UploadImageRequest_Info{Info: &pb.ImageInfo{LaptopId: missingID, ImageType: ".jpg"}}}
+				return server.UploadImage(&fakeUploadImageStream{fakeServerStream{ctx: context.Background(), requests: []proto.Message{info}}})
+			},
+			code:     codes.NotFound,
+			reason:   pb.ErrorReason_LAPTOP_NOT_FOUND,
+			resource: missingID,
+		},
+		{
+			name: "not_owner",
+			call: func() error {
+				_, err := server.DeleteLaptop(ContextWithPrincipal(context.Background(), other), &pb.DeleteLaptopRequest{Id: owned.Id})
+				return err
+			},
+			code:     codes.PermissionDenied,
+			reason:   pb.ErrorReason_NOT_LAPTOP_OWNER,
+			resource: owned.Id,
+		},
+		{
+			name: "login_required",
+			call: func() error {
+				_, err := server.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: owned.Id})
+				return err
+			},
+			code:   codes.Unauthenticated,
+			reason: pb.ErrorReason_LOGIN_REQUIRED,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			details := client.ParseErrorDetails(tc.call())
+			require.NotNil(t, details)
+			require.Equal(t, tc.code, details.Code)
+			require.Equal(t, tc.reason, details.Reason)
+
+			if tc.field != "" {
+				require.Len(t, details.FieldViolations, 1)
+				require.Equal(t, tc.field, details.FieldViolations[0].GetField())
+			} else {
+				require.Empty(t, details.FieldViolations)
+			}
+
+			if tc.resource != "" {
+				require.NotNil(t, details.Resource)
+				require.Equal(t, LaptopResourceType, details.Resource.GetResourceType())
+				require.Equal(t, tc.resource, details.Resource.GetResourceName())
+				require.Empty(t, details.Resource.GetOwner())
+			} else {
+				require.Nil(t, details.Resource)
+			}
+		})
+	}
+
+	// 笔记本仍然属于原来的所有者
+	found, err := store.Find(DefaultTenantID, owned.Id)
+	require.NoError(t, err)
+	require.Equal(t, "alice", found.Owner)
+}
+
+func TestClientErrorDetailsOverGRPC(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewInMemoryRateLimiter()
+	policy, err := NewRateLimitPolicy([]RateLimitRule{{Method: "*", Rate: 0.5, Burst: 1}})
+	require.NoError(t, err)
+	serverAddress := startTestErrorDetailsServer(t, NewRateLimitInterceptor(limiter, policy, nil))
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	_, err = laptopClient.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: sample.NewLaptop().Id})
+	require.Equal(t, pb.ErrorReason_LAPTOP_NOT_FOUND, client.ErrorReason(err))
+	_, ok := client.RetryDelay(err)
+	require.False(t, ok)
+
+	_, err = laptopClient.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: sample.NewLaptop().Id})
+	details := client.ParseErrorDetails(err)
+	require.Equal(t, codes.ResourceExhausted, details.Code)
+	require.Equal(t, pb.ErrorReason_RATE_LIMIT_EXCEEDED, details.Reason)
+	delay, ok := client.RetryDelay(err)
+	require.True(t, ok)
+	require.True(t, delay > 0 && delay <= 2*time.Second, "retry delay %v", delay)
+
+	require.Nil(t, client.ParseErrorDetails(nil))
+	require.Equal(t, pb.ErrorReason_ERROR_REASON_UNSPECIFIED, client.ErrorReason(nil))
+}
+
+func TestGatewayErrorHandler(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewInMemoryRateLimiter()
+	policy, err := NewRateLimitPolicy([]RateLimitRule{{Method: "*", Rate: 0.5, Burst: 1}})
+	require.NoError(t, err)
+	serverAddress := startTestErrorDetailsServer(t, NewRateLimitInterceptor(limiter, policy, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	mux := runtime.NewServeMux(runtime.WithProtoErrorHandler(GatewayErrorHandler))
+	err = pb.RegisterLaptopServiceHandlerFromEndpoint(ctx, mux, serverAddress, []grpc.DialOption{grpc.WithInsecure()})
+	require.NoError(t, err)
+	gateway := httptest.NewServer(mux)
+	t.Cleanup(gateway.Close)
+
+	res, err := http.Post(gateway.URL+"/v1/laptop/create", "application/json", strings.NewReader(`{"laptop": {"id": "invalid_uuid"}}`))
+	require.NoError(t, err)
+	body := decodeGatewayError(t, res)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	require.Equal(t, http.StatusBadRequest, body.Error.Code)
+	require.Equal(t, "INVALID_ARGUMENT", body.Error.Status)
+	require.Contains(t, body.Error.Message, "invalid laptop.id")
+	require.Len(t, body.Error.Details, 2)
+	require.Equal(t, "type.googleapis.com/google.rpc.ErrorInfo", body.Error.Details[0]["@type"])
+	require.Equal(t, "INVALID_LAPTOP_ID", body.Error.Details[0]["reason"])
+	require.Equal(t, ErrorDomain, body.Error.Details[0]["domain"])
+	require.Equal(t, "type.googleapis.com/google.rpc.BadRequest", body.Error.Details[1]["@type"])
+	require.Empty(t, res.Header.Get("Retry-After"))
+
+	res, err = http.Post(gateway.URL+"/v1/laptop/create", "application/json", strings.NewReader(`{"laptop": {}}`))
+	require.NoError(t, err)
+	body = decodeGatewayError(t, res)
+	require.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	require.Equal(t, "RESOURCE_EXHAUSTED", body.Error.Status)
+	require.Equal(t, "RATE_LIMIT_EXCEEDED", body.Error.Details[0]["reason"])
+	require.Equal(t, "type.googleapis.com/google.rpc.RetryInfo", body.Error.Details[1]["@type"])
+	require.Equal(t, "2", res.Header.Get("Retry-After"))
+}
+
+// fakeUploadImageStream replays the requests to UploadImage
+type fakeUploadImageStream struct {
+	fakeServerStream
+}
+
+func (stream *fakeUploadImageStream) Recv() (*pb.UploadImageRequest, error) {
+	req := &pb.UploadImageRequest{}
+	err := stream.RecvMsg(req)
+	return req, err
+}
+
+func (stream *fakeUploadImageStream) SendAndClose(res *pb.UploadImageResponse) error {
+	return stream.SendMsg(res)
+}
+
+// startTestErrorDetailsServer starts a laptop server behind the interceptor, and returns its address
+func startTestErrorDetailsServer(t *testing.T, interceptor ServerInterceptor) string {
+	chain := NewInterceptorChain().Add(StageRateLimit, interceptor)
+	grpcServer := grpc.NewServer(chain.ServerOptions()...)
+	pb.RegisterLaptopServiceServer(grpcServer, NewLaptopServer(NewInMemoryLaptopStore(), nil, nil))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return listener.Addr().String()
+}
+
+// testGatewayError is the JSON error of the gateway, with the details decoded as maps
+type testGatewayError struct {
+	Error struct {
+		Code    int                      `json:"code"`
+		Message string                   `json:"message"`
+		Status  string                   `json:"status"`
+		Details []map[string]interface{} `json:"details"`
+	} `json:"error"`
+}
+
+func decodeGatewayError(t *testing.T, res *http.Response) *testGatewayError {
+	defer res.Body.Close()
+
+	body := &testGatewayError{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(body))
+	return body
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/status"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// GatewayErrorHandler writes the errors of the REST gateway in the JSON error format of the Google APIs:
+// {"error": {"code": 404, "message": "...", "status": "NOT_FOUND", "details": [...]}}.
+// The details keep their "@type", and the RetryInfo of a throttled request also sets the Retry-After header.
+func GatewayErrorHandler(
+	ctx context.Context,
+	mux *runtime.ServeMux,
+	marshaler runtime.Marshaler,
+	w http.ResponseWriter,
+	r *http.Request,
+	err error,
+) {
+	st := status.Convert(err)
+	for _, detail := range st.Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok {
+			if delay, err := ptypes.Duration(retryInfo.GetRetryDelay()); err == nil {
+				// Retry-After只能是整数秒，向上取整以免客户端过早重试
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			}
+		}
+	}
+
+	// 默认的处理函数负责转发响应头和trailer，这里只改变错误的格式
+	runtime.DefaultHTTPProtoErrorHandler(ctx, mux, &gatewayErrorMarshaler{Marshaler: marshaler}, w, r, st.Err())
+}
+
+// gatewayError is the JSON error format of the Google APIs
+type gatewayError struct {
+	Error gatewayErrorBody `json:"error"`
+}
+
+type gatewayErrorBody struct {
+	Code    int               `json:"code"` // HTTP状态码
+	Message string            `json:"message"`
+	Status  string            `json:"status"` // gRPC状态码的名称，如NOT_FOUND
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
+// gatewayErrorMarshaler marshals the status of an error as a gatewayError, and the other messages as is
+type gatewayErrorMarshaler struct {
+	runtime.Marshaler
+}
+
+func (marshaler *gatewayErrorMarshaler) Marshal(v interface{}) ([]byte, error) {
+	st, ok := v.(*spb.Status)
+	if !ok || marshaler.ContentType() != "application/json" {
+		return marshaler.Marshaler.Marshal(v)
+	}
+
+	body := gatewayErrorBody{
+		Code:    runtime.HTTPStatusFromCode(status.FromProto(st).Code()),
+		Message: st.GetMessage(),
+		Status:  code.Code(st.GetCode()).String(),
+	}
+	for _, detail := range st.GetDetails() {
+		// 用网关的JSON编码器输出详情，保留"@type"和字段名的风格
+		data, err := marshaler.Marshaler.Marshal(detail)
+		if err != nil {
+			return nil, err
+		}
+		body.Details = append(body.Details, data)
+	}
+	return json.Marshal(gatewayError{Error: body})
+}
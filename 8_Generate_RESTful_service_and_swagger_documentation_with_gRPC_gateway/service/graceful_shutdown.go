@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainPollInterval is how often the in-flight requests are checked while draining
+const drainPollInterval = 100 * time.Millisecond
+
+// InFlightRequests counts the HTTP requests in progress.
+// http.Server.Shutdown does not wait for the hijacked connections of h2c, so the gRPC streams served over them are counted here.
+type InFlightRequests struct {
+	count int64
+}
+
+// Handler counts the requests served by the handler
+func (requests *InFlightRequests) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests.count, 1)
+		defer atomic.AddInt64(&requests.count, -1)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Count returns the number of requests in progress
+func (requests *InFlightRequests) Count() int64 {
+	return atomic.LoadInt64(&requests.count)
+}
+
+// Wait waits until there is no request in progress or the context is done
+func (requests *InFlightRequests) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for requests.Count() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// GracefulShutdown stops the servers without breaking the RPCs in progress:
+// it reports NOT_SERVING, stops accepting connections, drains the HTTP and gRPC servers,
+// then flushes the persistent stores. The servers are stopped hard if they are not drained before the deadline.
+type GracefulShutdown struct {
+	mutex        sync.Mutex
+	healthServer *health.Server
+	grpcServers  []*grpc.Server
+	httpServers  []*http.Server
+	inFlight     []*InFlightRequests
+	closers      []io.Closer
+	started      bool
+}
+
+// NewGracefulShutdown returns a new graceful shutdown that reports to the health server
+func NewGracefulShutdown(healthServer *health.Server) *GracefulShutdown {
+	return &GracefulShutdown{
+		healthServer: healthServer,
+	}
+}
+
+// AddGRPCServer adds a gRPC server, it is stopped after the HTTP servers since they may forward to it
+func (shutdown *GracefulShutdown) AddGRPCServer(grpcServer *grpc.Server) {
+	shutdown.mutex.Lock()
+	defer shutdown.mutex.Unlock()
+
+	// 关闭已经开始，后加入的服务端直接停止
+	if shutdown.started {
+		grpcServer.Stop()
+		return
+	}
+	shutdown.grpcServers = append(shutdown.grpcServers, grpcServer)
+}
+
+// AddHTTPServer adds an HTTP server, inFlight counts its requests that Shutdown does not wait for and may be nil
+func (shutdown *GracefulShutdown) AddHTTPServer(httpServer *http.Server, inFlight *InFlightRequests) {
+	shutdown.mutex.Lock()
+	defer shutdown.mutex.Unlock()
+
+	if shutdown.started {
+		httpServer.Close()
+		return
+	}
+	shutdown.httpServers = append(shutdown.httpServers, httpServer)
+	if inFlight != nil {
+		shutdown.inFlight = append(shutdown.inFlight, inFlight)
+	}
+}
+
+// AddCloser adds a persistent store to close after the servers have stopped
+func (shutdown *GracefulShutdown) AddCloser(closer io.Closer) {
+	shutdown.mutex.Lock()
+	defer shutdown.mutex.Unlock()
+
+	shutdown.closers = append(shutdown.closers, closer)
+}
+
+// Shutdown drains the servers within the timeout and closes the stores.
+// It returns an error if the servers had to be stopped hard or a store cannot be closed.
+func (shutdown *GracefulShutdown) Shutdown(timeout time.Duration) error {
+	shutdown.mutex.Lock()
+	shutdown.started = true
+	shutdown.mutex.Unlock()
+
+	// 先报告NOT_SERVING，负载均衡器不再把新请求发到这里
+	if shutdown.healthServer != nil {
+		shutdown.healthServer.Shutdown()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- shutdown.drain(ctx)
+	}()
+
+	var drainErr error
+	select {
+	case drainErr = <-drained:
+	case <-ctx.Done():
+		drainErr = ctx.Err()
+	}
+	if drainErr != nil {
+		// 超过期限，强制关闭所有连接
+		for _, httpServer := range shutdown.httpServers {
+			httpServer.Close()
+		}
+		for _, grpcServer := range shutdown.grpcServers {
+			grpcServer.Stop()
+		}
+		<-drained
+		drainErr = fmt.Errorf("servers stopped before draining: %w", drainErr)
+	}
+
+	// 所有RPC都结束后才关闭存储，保证写入的数据已经落盘
+	for _, closer := range shutdown.closers {
+		err := closer.Close()
+		if err != nil && drainErr == nil {
+			drainErr = fmt.Errorf("cannot close store: %w", err)
+		}
+	}
+	return drainErr
+}
+
+// drain stops accepting connections and waits for the requests in progress
+func (shutdown *GracefulShutdown) drain(ctx context.Context) error {
+	// Shutdown先关闭监听，再等待连接空闲
+	for _, httpServer := range shutdown.httpServers {
+		err := httpServer.Shutdown(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	for _, inFlight := range shutdown.inFlight {
+		err := inFlight.Wait(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 合并模式下gRPC服务端只在进程内被网关调用，HTTP请求都结束后才能GracefulStop
+	var wg sync.WaitGroup
+	for _, grpcServer := range shutdown.grpcServers {
+		wg.Add(1)
+		go func(grpcServer *grpc.Server) {
+			defer wg.Done()
+			grpcServer.GracefulStop()
+		}(grpcServer)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"pcbook/pb"
+	"pcbook/sample"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGracefulShutdownWithOpenStream(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		combined bool
+		finish   bool // 关闭期间是否结束流
+		timeout  time.Duration
+	}{
+		{name: "grpc_drained", finish: true, timeout: 10 * time.Second},
+		{name: "grpc_deadline", finish: false, timeout: 300 * time.Millisecond},
+		{name: "combined_drained", combined: true, finish: true, timeout: 10 * time.Second},
+		{name: "combined_deadline", combined: true, finish: false, timeout: 300 * time.Millisecond},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptopStore := NewInMemoryLaptopStore()
+			laptop := sample.NewLaptop()
+			require.NoError(t, laptopStore.Save(DefaultTenantID, laptop))
+			laptopServer := NewLaptopServer(laptopStore, nil, NewInMemoryRatingStore())
+
+			healthServer := health.NewServer()
+			shutdown := NewGracefulShutdown(healthServer)
+			store := &testCloser{}
+			shutdown.AddCloser(store)
+
+			var serverAddress string
+			if tc.combined {
+				serverAddress = startTestCombinedServer(t, laptopServer, nil, false, shutdown)
+			} else {
+				serverAddress = startTestShutdownGRPCServer(t, laptopServer, shutdown)
+			}
+			laptopClient := newTestLaptopClient(t, serverAddress)
+
+			// 关闭之前打开一个双向流
+			stream, err := laptopClient.RateLaptop(context.Background())
+			require.NoError(t, err)
+			require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 8}))
+			_, err = stream.Recv()
+			require.NoError(t, err)
+
+			shutdownErrors := make(chan error, 1)
+			go func() {
+				shutdownErrors <- shutdown.Shutdown(tc.timeout)
+			}()
+
+			// 关闭开始后报告NOT_SERVING，但进行中的流不受影响
+			require.Eventually(t, func() bool {
+				res, err := healthServer.Check(context.Background(), &healthpb.HealthCheckRequest{})
+				return err == nil && res.GetStatus() == healthpb.HealthCheckResponse_NOT_SERVING
+			}, 5*time.Second, 10*time.Millisecond)
+			require.Eventually(t, func() bool {
+				conn, err := net.Dial("tcp", serverAddress)
+				if err == nil {
+					conn.Close()
+				}
+				return err != nil
+			}, 5*time.Second, 10*time.Millisecond)
+
+			if !tc.finish {
+				err = <-shutdownErrors
+				require.Error(t, err)
+
+				_, err = stream.Recv()
+				require.Error(t, err)
+				require.Contains(t, []codes.Code{codes.Unavailable, codes.Canceled, codes.Internal}, status.Code(err))
+				require.True(t, store.isClosed())
+				return
+			}
+
+			require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 10}))
+			res, err := stream.Recv()
+			require.NoError(t, err)
+			require.Equal(t, 9.0, res.GetAverageScore())
+			require.False(t, store.isClosed())
+
+			require.NoError(t, stream.CloseSend())
+			_, err = stream.Recv()
+			require.Equal(t, io.EOF, err)
+
+			require.NoError(t, <-shutdownErrors)
+			require.True(t, store.isClosed())
+		})
+	}
+}
+
+func startTestShutdownGRPCServer(t *testing.T, laptopServer *LaptopServer, shutdown *GracefulShutdown) string {
+	grpcServer := grpc.NewServer()
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	shutdown.AddGRPCServer(grpcServer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	return listener.Addr().String()
+}
+
+// testCloser is a persistent store that records if it is closed
+type testCloser struct {
+	closed int32
+}
+
+func (closer *testCloser) Close() error {
+	atomic.StoreInt32(&closer.closed, 1)
+	return nil
+}
+
+func (closer *testCloser) isClosed() bool {
+	return atomic.LoadInt32(&closer.closed) == 1
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"google.golang.org/grpc"
+	"net/http"
+	"strings"
+)
+
+// InProcessNetwork is the network of the bufconn listener,
+// the REST gateway of the combined server calls the gRPC server in-process through it
+const InProcessNetwork = "bufconn"
+
+// GRPCHandlerFunc serves the gRPC requests with the gRPC server and the other requests with the HTTP handler,
+// so that gRPC and REST can share one port. gRPC requests always come over HTTP/2.
+func GRPCHandlerFunc(grpcServer *grpc.Server, httpHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+		httpHandler.ServeHTTP(w, r)
+	})
+}
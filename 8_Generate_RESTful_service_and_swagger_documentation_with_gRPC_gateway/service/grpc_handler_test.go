@@ -0,0 +1,189 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"net/http"
+	"pcbook/pb"
+	"pcbook/sample"
+	"pcbook/serializer"
+	"testing"
+	"time"
+)
+
+func TestGRPCHandlerFunc(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		tls  bool
+	}{
+		{"h2c", false},
+		{"tls", true},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			policy, err := ParseAccessPolicy([]byte(`{
+				"default": "deny",
+				"roles": {"editor": {}},
+				"rules": [{"method": "/pcbook.pbfiles.LaptopService/CreateLaptop", "roles": ["editor"]}]
+			}`))
+			require.NoError(t, err)
+
+			jwtManager := NewJWTManager("secret", time.Minute)
+			interceptor := NewAuthInterceptor(jwtManager, NewInMemorySessionStore(), NewInMemoryAPIKeyStore(), policy)
+			laptopStore := NewInMemoryLaptopStore()
+			serverAddress := startTestCombinedServer(t, NewLaptopServer(laptopStore, nil, nil), interceptor, tc.tls, nil)
+
+			user, err := NewUser("alice", "secret", "editor")
+			require.NoError(t, err)
+			accessToken, _, err := jwtManager.Generate(user)
+			require.NoError(t, err)
+
+			// gRPC请求由gRPC服务端处理
+			laptopClient := newTestCombinedLaptopClient(t, serverAddress, tc.tls)
+			_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+			require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", accessToken)
+			res, err := laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+			require.NoError(t, err)
+			_, err = laptopStore.Find(DefaultTenantID, res.GetId())
+			require.NoError(t, err)
+
+			// REST请求由网关处理，网关在进程内调用gRPC服务端，同样经过认证拦截器
+			httpClient, baseURL := newTestCombinedHTTPClient(t, serverAddress, tc.tls)
+			body, err := serializer.ProtobufToJSON(&pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+			require.NoError(t, err)
+
+			resp, err := httpClient.Post(baseURL+"/v1/laptop/create", "application/json", bytes.NewReader([]byte(body)))
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+			req, err := http.NewRequest(http.MethodPost, baseURL+"/v1/laptop/create", bytes.NewReader([]byte(body)))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", accessToken)
+			resp, err = httpClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			created := &pb.CreateLaptopResponse{}
+			require.NoError(t, jsonpb.Unmarshal(resp.Body, created))
+			_, err = laptopStore.Find(DefaultTenantID, created.GetId())
+			require.NoError(t, err)
+		})
+	}
+}
+
+// startTestCombinedServer serves the laptop service and its REST gateway on one port,
+// the gateway calls the gRPC server through an in-process listener.
+// The interceptor and the shutdown are optional.
+func startTestCombinedServer(
+	t *testing.T,
+	laptopServer *LaptopServer,
+	interceptor *AuthInterceptor,
+	withTLS bool,
+	shutdown *GracefulShutdown,
+) string {
+	var serverOptions []grpc.ServerOption
+	if interceptor != nil {
+		serverOptions = append(serverOptions, grpc.UnaryInterceptor(interceptor.Unary()))
+	}
+	grpcServer := grpc.NewServer(serverOptions...)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	inProcessListener := bufconn.Listen(1024 * 1024)
+	go grpcServer.Serve(inProcessListener)
+	t.Cleanup(grpcServer.Stop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	mux := runtime.NewServeMux()
+	dialOptions := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return inProcessListener.Dial()
+		}),
+	}
+	err := pb.RegisterLaptopServiceHandlerFromEndpoint(ctx, mux, InProcessNetwork, dialOptions)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	inFlight := &InFlightRequests{}
+	handler := inFlight.Handler(GRPCHandlerFunc(grpcServer, mux))
+	httpServer := &http.Server{Handler: handler}
+	t.Cleanup(func() { httpServer.Close() })
+	if shutdown != nil {
+		shutdown.AddGRPCServer(grpcServer)
+		shutdown.AddHTTPServer(httpServer, inFlight)
+	}
+
+	if withTLS {
+		serverCert, err := tls.LoadX509KeyPair("../cert/server-cert.pem", "../cert/server-key.pem")
+		require.NoError(t, err)
+
+		httpServer.TLSConfig = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+		go httpServer.ServeTLS(listener, "", "")
+	} else {
+		http2Server := &http2.Server{}
+		httpServer.Handler = h2c.NewHandler(handler, http2Server)
+		require.NoError(t, http2.ConfigureServer(httpServer, http2Server))
+		go httpServer.Serve(listener)
+	}
+
+	return listener.Addr().String()
+}
+
+func newTestCombinedClientTLSConfig(t *testing.T) *tls.Config {
+	return &tls.Config{
+		RootCAs:    newTestCertPool(t),
+		ServerName: "pcbook.ezzz.com",
+		Time:       func() time.Time { return testCertTime },
+	}
+}
+
+func newTestCombinedLaptopClient(t *testing.T, serverAddress string, withTLS bool) pb.LaptopServiceClient {
+	transportOption := grpc.WithInsecure()
+	if withTLS {
+		transportOption = grpc.WithTransportCredentials(credentials.NewTLS(newTestCombinedClientTLSConfig(t)))
+	}
+
+	conn, err := grpc.Dial(serverAddress, transportOption)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewLaptopServiceClient(conn)
+}
+
+func newTestCombinedHTTPClient(t *testing.T, serverAddress string, withTLS bool) (*http.Client, string) {
+	if !withTLS {
+		return &http.Client{}, "http://" + serverAddress
+	}
+
+	transport := &http.Transport{TLSClientConfig: newTestCombinedClientTLSConfig(t)}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport}, "https://" + serverAddress
+}
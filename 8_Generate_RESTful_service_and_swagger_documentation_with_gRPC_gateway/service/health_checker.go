@@ -0,0 +1,131 @@
+package service
+
+import (
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"pcbook/logging"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Names of the services with a health status besides the server as a whole
+const (
+	AuthServiceName   = "pcbook.pbfiles.AuthService"
+	LaptopServiceName = "pcbook.pbfiles.LaptopService"
+)
+
+// ReadinessProbe is a dependency of a service, such as a store, that can tell if it is ready to serve requests
+type ReadinessProbe interface {
+	// Ready returns an error if the dependency cannot serve requests
+	Ready() error
+}
+
+// namedProbe is a probe with the name used in logs
+type namedProbe struct {
+	name  string
+	probe ReadinessProbe
+}
+
+// HealthChecker runs the readiness probes of the services and reports their status to the gRPC health server.
+// The health server pushes the status changes to the clients of Watch.
+type HealthChecker struct {
+	healthServer *health.Server
+
+	mutex    sync.Mutex
+	probes   map[string][]namedProbe
+	statuses map[string]healthpb.HealthCheckResponse_ServingStatus
+}
+
+// NewHealthChecker returns a new health checker reporting to the health server
+func NewHealthChecker(healthServer *health.Server) *HealthChecker {
+	return &HealthChecker{
+		healthServer: healthServer,
+		probes:       make(map[string][]namedProbe),
+		statuses:     make(map[string]healthpb.HealthCheckResponse_ServingStatus),
+	}
+}
+
+// AddProbe adds a readiness probe to the service, the service is SERVING only if all its probes are ready
+func (checker *HealthChecker) AddProbe(service string, name string, probe ReadinessProbe) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	checker.probes[service] = append(checker.probes[service], namedProbe{name: name, probe: probe})
+}
+
+// Services returns the names of the services with probes, sorted
+func (checker *HealthChecker) Services() []string {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	services := make([]string, 0, len(checker.probes))
+	for service := range checker.probes {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// Check runs all probes and updates the status of each service, and of the server as a whole under the empty name
+func (checker *HealthChecker) Check() {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	overall := healthpb.HealthCheckResponse_SERVING
+	for service, probes := range checker.probes {
+		status := healthpb.HealthCheckResponse_SERVING
+		for _, probe := range probes {
+			err := probe.probe.Ready()
+			if err != nil {
+				logging.Default().Warn("probe is not ready", "probe", probe.name, "service", service, "error", err)
+				status = healthpb.HealthCheckResponse_NOT_SERVING
+			}
+		}
+		if status != healthpb.HealthCheckResponse_SERVING {
+			overall = status
+		}
+		checker.setStatus(service, status)
+	}
+	checker.setStatus("", overall)
+}
+
+// setStatus reports the status if it has changed, the health server ignores it after the shutdown has started
+func (checker *HealthChecker) setStatus(service string, status healthpb.HealthCheckResponse_ServingStatus) {
+	if previous, ok := checker.statuses[service]; ok && previous == status {
+		return
+	}
+	checker.statuses[service] = status
+
+	name := service
+	if name == "" {
+		name = "server"
+	}
+	logging.Default().Info("health changed", "service", name, "status", status)
+	checker.healthServer.SetServingStatus(service, status)
+}
+
+// Run checks the services now and then at every interval until the returned function is called
+func (checker *HealthChecker) Run(interval time.Duration) (stop func()) {
+	checker.Check()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				checker.Check()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
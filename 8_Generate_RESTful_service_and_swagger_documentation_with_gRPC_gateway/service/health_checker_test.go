@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckerWatch(t *testing.T) {
+	t.Parallel()
+
+	healthServer := health.NewServer()
+	imageStore := &testProbe{}
+	checker := NewHealthChecker(healthServer)
+	checker.AddProbe(AuthServiceName, "user store", NewInMemoryUserStore())
+	checker.AddProbe(LaptopServiceName, "laptop store", NewInMemoryLaptopStore())
+	checker.AddProbe(LaptopServiceName, "image store", imageStore)
+	require.Equal(t, []string{AuthServiceName, LaptopServiceName}, checker.Services())
+	checker.Check()
+
+	healthClient := newTestHealthClient(t, healthServer)
+	stream, err := healthClient.Watch(context.Background(), &healthpb.HealthCheckRequest{Service: LaptopServiceName})
+	require.NoError(t, err)
+	requireWatchStatus(t, stream, healthpb.HealthCheckResponse_SERVING)
+
+	// 图片目录不可写时，笔记本服务和整个服务端都不可用，认证服务不受影响
+	imageStore.setError(errors.New("read-only file system"))
+	checker.Check()
+	requireWatchStatus(t, stream, healthpb.HealthCheckResponse_NOT_SERVING)
+	requireHealthStatus(t, healthClient, "", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireHealthStatus(t, healthClient, AuthServiceName, healthpb.HealthCheckResponse_SERVING)
+
+	imageStore.setError(nil)
+	checker.Check()
+	requireWatchStatus(t, stream, healthpb.HealthCheckResponse_SERVING)
+	requireHealthStatus(t, healthClient, "", healthpb.HealthCheckResponse_SERVING)
+
+	// 开始关闭后不再报告探测结果
+	healthServer.Shutdown()
+	requireWatchStatus(t, stream, healthpb.HealthCheckResponse_NOT_SERVING)
+	checker.Check()
+	requireHealthStatus(t, healthClient, LaptopServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+func TestHealthHandlers(t *testing.T) {
+	t.Parallel()
+
+	healthServer := health.NewServer()
+	imageStore := &testProbe{}
+	checker := NewHealthChecker(healthServer)
+	checker.AddProbe(AuthServiceName, "user store", NewInMemoryUserStore())
+	checker.AddProbe(LaptopServiceName, "image store", imageStore)
+	checker.Check()
+
+	healthClient := newTestHealthClient(t, healthServer)
+	healthz := NewHealthzHandler(healthClient)
+	readyz := NewReadyzHandler(healthClient, checker.Services())
+
+	code, response := serveHealthRequest(t, readyz)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "SERVING", response.Status)
+	require.Equal(t, map[string]string{AuthServiceName: "SERVING", LaptopServiceName: "SERVING"}, response.Services)
+
+	imageStore.setError(errors.New("disk full"))
+	checker.Check()
+
+	code, response = serveHealthRequest(t, readyz)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "NOT_SERVING", response.Status)
+	require.Equal(t, "NOT_SERVING", response.Services[LaptopServiceName])
+	require.Equal(t, "SERVING", response.Services[AuthServiceName])
+
+	// 服务端还活着，只是暂时不能处理请求
+	code, response = serveHealthRequest(t, healthz)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "NOT_SERVING", response.Status)
+
+	// 没有探测的服务是未知的
+	code, response = serveHealthRequest(t, NewReadyzHandler(healthClient, []string{"pcbook.pbfiles.Unknown"}))
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "UNKNOWN", response.Services["pcbook.pbfiles.Unknown"])
+	require.NotEmpty(t, response.Error)
+}
+
+func newTestHealthClient(t *testing.T, healthServer *health.Server) healthpb.HealthClient {
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func requireWatchStatus(t *testing.T, stream healthpb.Health_WatchClient, expected healthpb.HealthCheckResponse_ServingStatus) {
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, expected, res.GetStatus())
+}
+
+func requireHealthStatus(t *testing.T, healthClient healthpb.HealthClient, service string, expected healthpb.HealthCheckResponse_ServingStatus) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	require.NoError(t, err)
+	require.Equal(t, expected, res.GetStatus())
+}
+
+func serveHealthRequest(t *testing.T, handler http.Handler) (int, *HealthResponse) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ReadyzPath, nil))
+
+	response := &HealthResponse{}
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(response))
+	return recorder.Code, response
+}
+
+// testProbe is a readiness probe whose error is set by the test
+type testProbe struct {
+	err atomic.Value
+}
+
+func (probe *testProbe) setError(err error) {
+	probe.err.Store(&err)
+}
+
+func (probe *testProbe) Ready() error {
+	if err, ok := probe.err.Load().(*error); ok {
+		return *err
+	}
+	return nil
+}
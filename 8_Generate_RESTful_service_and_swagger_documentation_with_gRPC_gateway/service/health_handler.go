@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"pcbook/logging"
+	"time"
+)
+
+// Paths of the health endpoints of the REST server
+const (
+	// HealthzPath reports if the gRPC server answers health checks at all, whatever the status
+	HealthzPath = "/healthz"
+	// ReadyzPath reports the status of each service, it fails if any service is not serving
+	ReadyzPath = "/readyz"
+)
+
+// healthCheckTimeout bounds the health checks of the gRPC server, a load balancer should not wait longer
+const healthCheckTimeout = 2 * time.Second
+
+// HealthResponse is the body of the responses of /healthz and /readyz
+type HealthResponse struct {
+	Status   string            `json:"status"`
+	Services map[string]string `json:"services,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// NewHealthzHandler returns an HTTP handler that checks the gRPC server is alive with the health service
+func NewHealthzHandler(healthClient healthpb.HealthClient) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		res, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			writeHealthResponse(w, http.StatusServiceUnavailable, &HealthResponse{Status: "UNKNOWN", Error: err.Error()})
+			return
+		}
+		writeHealthResponse(w, http.StatusOK, &HealthResponse{Status: res.GetStatus().String()})
+	})
+}
+
+// NewReadyzHandler returns an HTTP handler that reports the status of the server and of each service.
+// It responds 503 if any of them is not SERVING, so that a load balancer stops sending requests to the server.
+func NewReadyzHandler(healthClient healthpb.HealthClient, services []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		code := http.StatusOK
+		response := &HealthResponse{Services: make(map[string]string)}
+		for _, service := range append([]string{""}, services...) {
+			status := healthpb.HealthCheckResponse_UNKNOWN.String()
+			res, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+			if err == nil {
+				status = res.GetStatus().String()
+			} else if response.Error == "" {
+				response.Error = err.Error()
+			}
+
+			if status != healthpb.HealthCheckResponse_SERVING.String() {
+				code = http.StatusServiceUnavailable
+			}
+			if service == "" {
+				response.Status = status
+			} else {
+				response.Services[service] = status
+			}
+		}
+		writeHealthResponse(w, code, response)
+	})
+}
+
+func writeHealthResponse(w http.ResponseWriter, code int, response *HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	// 健康状态必须是实时的
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		logging.Default().Warn("cannot write health response", "error", err)
+	}
+}
@@ -2,16 +2,21 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/google/uuid"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 )
 
 // ImageStore is an interface to store laptop images
 type ImageStore interface {
-	// Save saves a new laptop image to the store
-	Save(laptopID string, imageType string, imageData bytes.Buffer) (string, error)
+	// Save saves a new laptop image of the tenant to the store, it stops and saves nothing if the context is done
+	Save(ctx context.Context, tenantID string, laptopID string, imageType string, imageData bytes.Buffer) (string, error)
+	// Ready returns an error if the store cannot serve requests
+	Ready() error
 }
 
 // DiskImageStore stores images on disk and its info on memory
@@ -23,6 +28,7 @@ type DiskImageStore struct {
 
 // ImageInfo contains information of the laptop images
 type ImageInfo struct {
+	tenantID string
 	laptopID string
 	Type string
 	Path string
@@ -35,7 +41,37 @@ func NewDiskImageStore(imageFolder string) *DiskImageStore {
 	}
 }
 
+// Ready checks that the image folder is writable by creating and removing a file in it
+func (store *DiskImageStore) Ready() error {
+	err := os.MkdirAll(store.imageFolder, 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create image folder: %w", err)
+	}
+
+	file, err := ioutil.TempFile(store.imageFolder, ".ready-*")
+	if err != nil {
+		return fmt.Errorf("image folder is not writable: %w", err)
+	}
+	file.Close()
+
+	err = os.Remove(file.Name())
+	if err != nil {
+		return fmt.Errorf("cannot remove file from image folder: %w", err)
+	}
+	return nil
+}
+
+// Count returns the number of images saved since the server started
+func (store *DiskImageStore) Count() int {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return len(store.images)
+}
+
 func (store *DiskImageStore) Save(
+	ctx context.Context,
+	tenantID string,
 	laptopID string,
 	imageType string,
 	imageData bytes.Buffer,
@@ -45,16 +81,33 @@ func (store *DiskImageStore) Save(
 		return "", fmt.Errorf("cannot generate image id: %w", err)
 	}
 
-	imagePath := fmt.Sprintf("%s/%s%s", store.imageFolder, imageID, imageType)
+	// 每个租户的图片保存在各自的子目录中，默认租户的图片直接保存在imageFolder中
+	err = CheckTenantID(tenantID)
+	if err != nil {
+		return "", err
+	}
+	folder := filepath.Join(store.imageFolder, tenantID)
+	err = os.MkdirAll(folder, 0755)
+	if err != nil {
+		return "", fmt.Errorf("cannot create image folder: %w", err)
+	}
+
+	imagePath := fmt.Sprintf("%s/%s%s", folder, imageID, imageType)
 
 	file, err := os.Create(imagePath)
 	if err != nil {
 		return "", fmt.Errorf("cannot create image file: %w", err)
 	}
 
-	_, err = imageData.WriteTo(file)
+	err = writeImage(ctx, file, imageData.Bytes())
+	// 关闭失败时数据可能没有写入磁盘
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("cannot close image file: %w", closeErr)
+	}
 	if err != nil {
-		return "", fmt.Errorf("cannot write image to file: %w", err)
+		// 不保留写了一半的图片
+		os.Remove(imagePath)
+		return "", err
 	}
 
 	// write file success, save its info on memory
@@ -62,6 +115,7 @@ func (store *DiskImageStore) Save(
 	defer store.mutex.Unlock()
 
 	store.images[imageID.String()] = &ImageInfo{
+		tenantID: tenantID,
 		laptopID: laptopID,
 		Type:     imageType,
 		Path:     imagePath,
@@ -70,25 +124,25 @@ func (store *DiskImageStore) Save(
 	return imageID.String(), nil
 }
 
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
+// imageWriteChunkSize is the size of the writes of an image, the context is checked between them
+const imageWriteChunkSize = 64 * 1024
+
+// writeImage writes the image data to the file by chunks, it returns the error of the context if it is done
+func writeImage(ctx context.Context, file *os.File, data []byte) error {
+	for len(data) > 0 {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("image is not saved: %w", err)
+		}
+
+		size := imageWriteChunkSize
+		if size > len(data) {
+			size = len(data)
+		}
+		_, err := file.Write(data[:size])
+		if err != nil {
+			return fmt.Errorf("cannot write image to file: %w", err)
+		}
+		data = data[size:]
+	}
+	return nil
+}
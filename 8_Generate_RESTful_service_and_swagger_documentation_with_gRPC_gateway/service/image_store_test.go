@@ -0,0 +1,76 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskImageStoreTenantFolders(t *testing.T) {
+	t.Parallel()
+
+	imageFolder, err := ioutil.TempDir("", "pcbook-images")
+	require.NoError(t, err)
+	defer os.RemoveAll(imageFolder)
+
+	store := NewDiskImageStore(imageFolder)
+	imageData := *bytes.NewBufferString("image")
+
+	// 默认租户的图片直接保存在imageFolder中
+	imageID, err := store.Save(context.Background(), DefaultTenantID, "laptop", ".jpg", imageData)
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(imageFolder, imageID+".jpg"))
+
+	imageID, err = store.Save(context.Background(), "acme", "laptop", ".jpg", imageData)
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(imageFolder, "acme", imageID+".jpg"))
+
+	_, err = store.Save(context.Background(), "../acme", "laptop", ".jpg", imageData)
+	require.Error(t, err)
+	_, err = store.Save(context.Background(), AllTenants, "laptop", ".jpg", imageData)
+	require.Error(t, err)
+}
+
+func TestDiskImageStoreCanceled(t *testing.T) {
+	t.Parallel()
+
+	imageFolder, err := ioutil.TempDir("", "pcbook-images")
+	require.NoError(t, err)
+	defer os.RemoveAll(imageFolder)
+
+	store := NewDiskImageStore(imageFolder)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// 取消的保存不留下任何文件
+	_, err = store.Save(ctx, DefaultTenantID, "laptop", ".jpg", *bytes.NewBufferString("image"))
+	require.True(t, errors.Is(err, context.Canceled))
+	require.Equal(t, 0, store.Count())
+	files, err := ioutil.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestDiskImageStoreReady(t *testing.T) {
+	t.Parallel()
+
+	imageFolder, err := ioutil.TempDir("", "pcbook-images")
+	require.NoError(t, err)
+	defer os.RemoveAll(imageFolder)
+
+	// 探测用的文件不能留在目录中
+	require.NoError(t, NewDiskImageStore(filepath.Join(imageFolder, "img")).Ready())
+	files, err := ioutil.ReadDir(filepath.Join(imageFolder, "img"))
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	// 图片目录的位置被一个普通文件占用
+	blocker := filepath.Join(imageFolder, "file")
+	require.NoError(t, ioutil.WriteFile(blocker, []byte("not a folder"), 0600))
+	require.Error(t, NewDiskImageStore(filepath.Join(blocker, "img")).Ready())
+}
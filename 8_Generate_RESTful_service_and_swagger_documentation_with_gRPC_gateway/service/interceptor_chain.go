@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+)
+
+// Names of the stages of the interceptor chain of the server, in the order they run
+const (
+	StageRequestID  = "request_id"
+	StageRecovery   = "recovery"
+	StageLogging    = "logging"
+	StageTracing    = "tracing"
+	StageMetrics    = "metrics"
+	StageAudit      = "audit"
+	StageAuth       = "auth"
+	StageRateLimit  = "rate_limit"
+	StageDeadline   = "deadline"
+	StageValidation = "validation"
+	// StageHandlerRecovery recovers the panics of the handlers, so that the earlier stages see an Internal error
+	StageHandlerRecovery = "handler_recovery"
+)
+
+// ServerInterceptor is an interceptor of both unary and stream RPC, such as AuthInterceptor
+type ServerInterceptor interface {
+	Unary() grpc.UnaryServerInterceptor
+	Stream() grpc.StreamServerInterceptor
+}
+
+// InterceptorChain composes the interceptors of named stages, the first stage runs first.
+// The stages can be inserted relative to each other, so that a new interceptor does not need to know the whole chain.
+type InterceptorChain struct {
+	stages []interceptorStage
+}
+
+type interceptorStage struct {
+	name   string
+	unary  grpc.UnaryServerInterceptor
+	stream grpc.StreamServerInterceptor
+}
+
+// NewInterceptorChain returns a new empty interceptor chain
+func NewInterceptorChain() *InterceptorChain {
+	return &InterceptorChain{}
+}
+
+// Add appends a stage to the chain. It panics if the name is already used, like adding a method twice to a server.
+func (chain *InterceptorChain) Add(name string, interceptor ServerInterceptor) *InterceptorChain {
+	chain.insert(len(chain.stages), name, interceptor)
+	return chain
+}
+
+// InsertBefore inserts a stage that runs just before another stage. It panics if that stage does not exist.
+func (chain *InterceptorChain) InsertBefore(before string, name string, interceptor ServerInterceptor) *InterceptorChain {
+	chain.insert(chain.index(before), name, interceptor)
+	return chain
+}
+
+// InsertAfter inserts a stage that runs just after another stage. It panics if that stage does not exist.
+func (chain *InterceptorChain) InsertAfter(after string, name string, interceptor ServerInterceptor) *InterceptorChain {
+	chain.insert(chain.index(after)+1, name, interceptor)
+	return chain
+}
+
+// Stages returns the names of the stages in the order they run
+func (chain *InterceptorChain) Stages() []string {
+	names := make([]string, 0, len(chain.stages))
+	for _, stage := range chain.stages {
+		names = append(names, stage.name)
+	}
+	return names
+}
+
+func (chain *InterceptorChain) insert(position int, name string, interceptor ServerInterceptor) {
+	for _, stage := range chain.stages {
+		if stage.name == name {
+			panic(fmt.Sprintf("interceptor stage %q is already in the chain", name))
+		}
+	}
+
+	stage := interceptorStage{
+		name:   name,
+		unary:  interceptor.Unary(),
+		stream: interceptor.Stream(),
+	}
+	chain.stages = append(chain.stages, interceptorStage{})
+	copy(chain.stages[position+1:], chain.stages[position:])
+	chain.stages[position] = stage
+}
+
+func (chain *InterceptorChain) index(name string) int {
+	for i, stage := range chain.stages {
+		if stage.name == name {
+			return i
+		}
+	}
+	panic(fmt.Sprintf("interceptor stage %q is not in the chain", name))
+}
+
+// Unary returns a unary interceptor that runs the stages in order
+func (chain *InterceptorChain) Unary() grpc.UnaryServerInterceptor {
+	interceptors := make([]grpc.UnaryServerInterceptor, 0, len(chain.stages))
+	for _, stage := range chain.stages {
+		if stage.unary != nil {
+			interceptors = append(interceptors, stage.unary)
+		}
+	}
+
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		// 从最后一个阶段开始，把每个阶段包装成前一个阶段的处理函数
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// Stream returns a stream interceptor that runs the stages in order
+func (chain *InterceptorChain) Stream() grpc.StreamServerInterceptor {
+	interceptors := make([]grpc.StreamServerInterceptor, 0, len(chain.stages))
+	for _, stage := range chain.stages {
+		if stage.stream != nil {
+			interceptors = append(interceptors, stage.stream)
+		}
+	}
+
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(srv interface{}, stream grpc.ServerStream) error {
+				return interceptor(srv, stream, info, inner)
+			}
+		}
+		return next(srv, stream)
+	}
+}
+
+// ServerOptions returns the options to install the chain in a gRPC server
+func (chain *InterceptorChain) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(chain.Unary()),
+		grpc.StreamInterceptor(chain.Stream()),
+	}
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"pcbook/logging"
+	"pcbook/metrics"
+	"pcbook/pb"
+	"pcbook/sample"
+	"strings"
+	"testing"
+)
+
+func TestInterceptorChainOrder(t *testing.T) {
+	t.Parallel()
+
+	calls := []string{}
+	chain := NewInterceptorChain().
+		Add("a", &recordingInterceptor{name: "a", calls: &calls}).
+		Add("c", &recordingInterceptor{name: "c", calls: &calls}).
+		InsertBefore("c", "b", &recordingInterceptor{name: "b", calls: &calls}).
+		InsertAfter("c", "d", &recordingInterceptor{name: "d", calls: &calls})
+	require.Equal(t, []string{"a", "b", "c", "d"}, chain.Stages())
+
+	res, err := chain.Unary()(context.Background(), "req", &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls = append(calls, "handler")
+		return "res", nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "res", res)
+	require.Equal(t, []string{"a", "b", "c", "d", "handler"}, calls)
+
+	calls = nil
+	err = chain.Stream()(nil, &fakeServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c", "d", "handler"}, calls)
+
+	require.Panics(t, func() { chain.Add("a", &recordingInterceptor{calls: &calls}) })
+	require.Panics(t, func() { chain.InsertBefore("x", "e", &recordingInterceptor{calls: &calls}) })
+}
+
+func TestRecoveryInterceptor(t *testing.T) {
+	t.Parallel()
+
+	output := &syncBuffer{}
+	logger := logging.New(output, logging.FormatLogfmt, logging.LevelInfo)
+	chain := NewInterceptorChain().
+		Add(StageRequestID, NewRequestIDInterceptor(logger)).
+		Add(StageRecovery, NewRecoveryInterceptor()).
+		Add(StageLogging, NewLoggingInterceptor())
+	grpcServer := grpc.NewServer(chain.ServerOptions()...)
+	pb.RegisterLaptopServiceServer(grpcServer, &panickingLaptopServer{})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), RequestIDHeader, "req-panic")
+	_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.Internal, status.Code(err))
+	require.NotContains(t, status.Convert(err).Message(), "nil map")
+
+	// 服务端没有崩溃，流式RPC的panic也被恢复
+	stream, err := laptopClient.SearchLaptop(ctx, &pb.SearchLaptopRequest{Filter: &pb.Filter{}})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Internal, status.Code(err))
+	require.NotEqual(t, io.EOF, err)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	panics := 0
+	for _, line := range lines {
+		require.Contains(t, line, "request_id=req-panic")
+		if strings.Contains(line, `msg="recovered from panic"`) {
+			panics++
+			require.Contains(t, line, "panickingLaptopServer")
+		}
+	}
+	require.Equal(t, 2, panics)
+	require.Contains(t, lines[len(lines)-1], "level=error")
+	require.Contains(t, lines[len(lines)-1], "code=Internal")
+
+	// 恢复拦截器也包装了它之后的阶段
+	chain = NewInterceptorChain().
+		Add(StageRecovery, NewRecoveryInterceptor()).
+		Add("panicking", &panickingInterceptor{})
+	_, err = chain.Unary()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test/Panic"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "res", nil
+	})
+	require.Equal(t, codes.Internal, status.Code(err))
+	err = chain.Stream()(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/test/Panic"}, func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	})
+	require.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestRecoveryBeforeHandler(t *testing.T) {
+	t.Parallel()
+
+	output := &syncBuffer{}
+	logger := logging.New(output, logging.FormatLogfmt, logging.LevelInfo)
+	auditLog, err := OpenFileAuditLog(newTestAuditLogFile(t))
+	require.NoError(t, err)
+	defer auditLog.Close()
+	metricsInterceptor := NewMetricsInterceptor(metrics.NewRegistry())
+
+	chain := NewInterceptorChain().
+		Add(StageRequestID, NewRequestIDInterceptor(logger)).
+		Add(StageRecovery, NewRecoveryInterceptor()).
+		Add(StageLogging, NewLoggingInterceptor()).
+		Add(StageMetrics, metricsInterceptor).
+		Add(StageAudit, NewAuditInterceptor(auditLog, nil)).
+		Add(StageHandlerRecovery, NewRecoveryInterceptor())
+
+	const createLaptop = "/pcbook.pbfiles.LaptopService/CreateLaptop"
+	info := &grpc.UnaryServerInfo{FullMethod: createLaptop}
+	_, err = chain.Unary()(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("cannot create laptop")
+	})
+	require.Equal(t, codes.Internal, status.Code(err))
+
+	// 处理函数的panic没有跳过外层的阶段
+	records, err := auditLog.Query(AuditFilter{TenantID: AllTenants})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, codes.Internal.String(), records[0].Code)
+	require.Equal(t, 1.0, metricsInterceptor.requests.Value(createLaptop, codes.Internal.String()))
+	finished := 0
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		if strings.Contains(line, `msg="finished RPC"`) {
+			finished++
+			require.Contains(t, line, "code=Internal")
+		}
+	}
+	require.Equal(t, 1, finished)
+}
+
+// recordingInterceptor records the order in which the stages run
+type recordingInterceptor struct {
+	name  string
+	calls *[]string
+}
+
+func (interceptor *recordingInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		*interceptor.calls = append(*interceptor.calls, interceptor.name)
+		return handler(ctx, req)
+	}
+}
+
+func (interceptor *recordingInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		*interceptor.calls = append(*interceptor.calls, interceptor.name)
+		return handler(srv, stream)
+	}
+}
+
+// panickingInterceptor panics in the chain, like a stage with a bug
+type panickingInterceptor struct{}
+
+func (interceptor *panickingInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		panic("cannot intercept")
+	}
+}
+
+func (interceptor *panickingInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		panic("cannot intercept")
+	}
+}
+
+// panickingLaptopServer panics in its handlers, like a handler with a bug
+type panickingLaptopServer struct {
+	pb.UnimplementedLaptopServiceServer
+}
+
+func (server *panickingLaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLaptopRequest) (*pb.CreateLaptopResponse, error) {
+	var laptops map[string]*pb.Laptop
+	laptops[req.GetLaptop().GetId()] = req.GetLaptop()
+	return nil, nil
+}
+
+func (server *panickingLaptopServer) SearchLaptop(req *pb.SearchLaptopRequest, stream pb.LaptopService_SearchLaptopServer) error {
+	panic("cannot search")
+}
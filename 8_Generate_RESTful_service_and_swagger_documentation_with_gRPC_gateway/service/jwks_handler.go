@@ -0,0 +1,31 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"pcbook/logging"
+)
+
+// JWKSPath is the well-known path where the public signing keys are published
+const JWKSPath = "/.well-known/jwks.json"
+
+// NewJWKSHandler returns an HTTP handler that publishes the public keys of the key set as JWKS,
+// so that other services can verify access tokens without being able to sign them
+func NewJWKSHandler(keySet *KeySet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		// 轮换密钥后，验证方应该能较快地拿到新的公钥
+		w.Header().Set("Cache-Control", "public, max-age=300")
+
+		err := json.NewEncoder(w).Encode(keySet.JWKS())
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("cannot write JWKS response", "error", err)
+		}
+	})
+}
@@ -1,15 +1,21 @@
 package service
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 	"time"
 )
 
 // JWTManager is a JSON web token manager
 type JWTManager struct {
 	secretKey string
+	keySet *KeySet // 不为nil时使用非对称密钥（RS256/ES256）签名，而不是secretKey
 	tokenDuration time.Duration
+	bindCertificate bool // 通过mTLS登录时，令牌与客户端证书绑定（RFC 8705）
 }
 
 // UserClaims is a custom JWT claims that contains some user's information
@@ -17,6 +23,33 @@ type UserClaims struct {
 	jwt.StandardClaims
 	Username string `json:"username"`
 	Role string `json:"role"`
+	// TenantID 是用户所属的租户，默认租户时省略
+	TenantID string `json:"tenant_id,omitempty"`
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+	// AuthMethods 是RFC 8176定义的认证方式，如pwd、otp
+	AuthMethods []string `json:"amr,omitempty"`
+}
+
+// Authentication methods of the amr claim
+const (
+	AuthMethodPassword = "pwd"
+	AuthMethodOTP      = "otp"
+)
+
+// HasAuthMethod checks if the user has been authenticated with the method
+func (claims *UserClaims) HasAuthMethod(method string) bool {
+	for _, authMethod := range claims.AuthMethods {
+		if authMethod == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Confirmation is the RFC 8705 confirmation claim that binds a token to a client certificate
+type Confirmation struct {
+	// X5TS256 是客户端证书（DER编码）的SHA-256指纹，base64url编码
+	X5TS256 string `json:"x5t#S256"`
 }
 
 // NewJWTManager returns a new JWT manager
@@ -27,33 +60,80 @@ func NewJWTManager(secretKey string, tokenDuration time.Duration) *JWTManager {
 	}
 }
 
-// Generate generates and signs a new token for a user
-func (manager *JWTManager) Generate(user *User) (string, error) {
-	claims := UserClaims{
+// NewJWTManagerWithKeySet returns a new JWT manager that signs tokens with the asymmetric keys of the key set
+func NewJWTManagerWithKeySet(keySet *KeySet, tokenDuration time.Duration) *JWTManager {
+	return &JWTManager{
+		keySet:        keySet,
+		tokenDuration: tokenDuration,
+	}
+}
+
+// KeySet returns the key set of the manager, or nil if tokens are signed with a shared secret
+func (manager *JWTManager) KeySet() *KeySet {
+	return manager.keySet
+}
+
+// SetCertificateBinding enables or disables binding new tokens to the client certificate they are issued to,
+// it must be called before the manager is used
+func (manager *JWTManager) SetCertificateBinding(enabled bool) {
+	manager.bindCertificate = enabled
+}
+
+// Generate generates and signs a new token for a user, it also returns the claims of the token
+func (manager *JWTManager) Generate(user *User) (string, *UserClaims, error) {
+	return manager.GenerateForCertificate(user, nil)
+}
+
+// GenerateForCertificate generates a token for a user who logs in with a verified client certificate,
+// the token is bound to the certificate if certificate binding is enabled
+func (manager *JWTManager) GenerateForCertificate(user *User, clientCert *x509.Certificate) (string, *UserClaims, error) {
+	tokenID, err := uuid.NewRandom()
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot generate token id: %w", err)
+	}
+
+	now := time.Now()
+	claims := &UserClaims{
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(manager.tokenDuration).Unix(),
+			Id:        tokenID.String(), // jti，用于注销或吊销某个令牌
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(manager.tokenDuration).Unix(),
 		},
 		Username: user.Username,
 		Role: user.Role,
+		TenantID: user.TenantID,
+		AuthMethods: []string{AuthMethodPassword},
+	}
+	if user.TOTPEnabled {
+		// 只有在验证了一次性验证码后，Login才会为启用两步验证的用户签发令牌
+		claims.AuthMethods = append(claims.AuthMethods, AuthMethodOTP)
+	}
+	if manager.bindCertificate && clientCert != nil {
+		claims.Confirmation = &Confirmation{X5TS256: CertificateThumbprint(clientCert)}
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(manager.secretKey))
+	accessToken, err := manager.sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return accessToken, claims, nil
+}
+
+func (manager *JWTManager) sign(claims *UserClaims) (string, error) {
+	if manager.keySet == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(manager.secretKey))
+	}
+
+	signingKey := manager.keySet.SigningKey()
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	token.Header["kid"] = signingKey.ID
+	return token.SignedString(signingKey.PrivateKey)
 }
 
 // Verify verifies the access token string and return a user claim if the token is valid
 func (manager *JWTManager) Verify(accessToken string) (*UserClaims, error) {
-	token, err := jwt.ParseWithClaims(
-		accessToken,
-		&UserClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			_, ok := token.Method.(*jwt.SigningMethodHMAC)
-			if !ok {
-				return nil, fmt.Errorf("unexpected token signing method")
-			}
-			return []byte(manager.secretKey), nil
-		},
-	)
+	token, err := jwt.ParseWithClaims(accessToken, &UserClaims{}, manager.verificationKey)
 	if err != nil {
 		return nil, fmt.Errorf("invalid token: %w", err)
 	}
@@ -62,30 +142,40 @@ func (manager *JWTManager) Verify(accessToken string) (*UserClaims, error) {
 	if !ok {
 		return nil, fmt.Errorf("invalid token claims: %w", err)
 	}
+	if claims.Id == "" {
+		return nil, fmt.Errorf("token has no id")
+	}
 	return claims, nil
 }
 
+// CertificateThumbprint returns the base64url encoded SHA-256 thumbprint of the certificate, as used in the cnf claim
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
 
+// verificationKey returns the key to verify the token, it must reject any signing method other than the configured one
+func (manager *JWTManager) verificationKey(token *jwt.Token) (interface{}, error) {
+	if manager.keySet == nil {
+		_, ok := token.Method.(*jwt.SigningMethodHMAC)
+		if !ok {
+			return nil, fmt.Errorf("unexpected token signing method")
+		}
+		return []byte(manager.secretKey), nil
+	}
 
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("token has no key id")
+	}
 
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
+	key := manager.keySet.VerificationKey(kid)
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	// 防止算法混淆攻击：令牌头部的alg必须与该密钥的算法一致
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected token signing method")
+	}
+	return key.PrivateKey.Public(), nil
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJWTManagerKeyRotation(t *testing.T) {
+	t.Parallel()
+
+	user, err := NewUser("alice", "secret", "user")
+	require.NoError(t, err)
+
+	oldKey, err := GenerateSigningKey("RS256")
+	require.NoError(t, err)
+	newKey, err := GenerateSigningKey("ES256")
+	require.NoError(t, err)
+
+	now := time.Now()
+	keySet := NewKeySet(oldKey, time.Minute)
+	keySet.now = func() time.Time { return now }
+	manager := NewJWTManagerWithKeySet(keySet, time.Minute)
+
+	oldToken, _, err := manager.Generate(user)
+	require.NoError(t, err)
+
+	keySet.Rotate(newKey)
+	require.Equal(t, newKey.ID, keySet.SigningKey().ID)
+	require.Len(t, keySet.JWKS()["keys"], 2)
+
+	// 旧密钥签发的令牌在其过期前仍然有效
+	claims, err := manager.Verify(oldToken)
+	require.NoError(t, err)
+	require.Equal(t, "alice", claims.Username)
+
+	newToken, _, err := manager.Generate(user)
+	require.NoError(t, err)
+	token, _, err := new(jwt.Parser).ParseUnverified(newToken, &UserClaims{})
+	require.NoError(t, err)
+	require.Equal(t, newKey.ID, token.Header["kid"])
+	require.Equal(t, "ES256", token.Method.Alg())
+
+	// 超过保留期后，旧密钥不再发布，也不能再验证令牌
+	now = now.Add(2 * time.Minute)
+	require.Len(t, keySet.JWKS()["keys"], 1)
+	require.Nil(t, keySet.VerificationKey(oldKey.ID))
+}
+
+func TestJWTManagerRejectsAlgorithmConfusion(t *testing.T) {
+	t.Parallel()
+
+	key, err := GenerateSigningKey("RS256")
+	require.NoError(t, err)
+	manager := NewJWTManagerWithKeySet(NewKeySet(key, time.Minute), time.Minute)
+
+	// 攻击者用公开的公钥作为HMAC密钥签名
+	claims := &UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        "token-id",
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+		Username: "mallory",
+		Role:     "admin",
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = key.ID
+	forged, err := token.SignedString([]byte(key.JWK()["n"]))
+	require.NoError(t, err)
+
+	_, err = manager.Verify(forged)
+	require.Error(t, err)
+}
+
+func TestJWTManagerCertificateBinding(t *testing.T) {
+	t.Parallel()
+
+	user, err := NewUser("alice", "secret", "user")
+	require.NoError(t, err)
+	clientCert := loadTestCertificate(t, "../cert/client-cert.pem")
+
+	manager := NewJWTManager("secret", time.Minute)
+	_, claims, err := manager.GenerateForCertificate(user, clientCert)
+	require.NoError(t, err)
+	require.Nil(t, claims.Confirmation)
+
+	manager.SetCertificateBinding(true)
+	accessToken, _, err := manager.GenerateForCertificate(user, clientCert)
+	require.NoError(t, err)
+
+	claims, err = manager.Verify(accessToken)
+	require.NoError(t, err)
+	require.Equal(t, CertificateThumbprint(clientCert), claims.Confirmation.X5TS256)
+
+	// cnf声明的名称与格式由RFC 8705规定
+	token, _, err := new(jwt.Parser).ParseUnverified(accessToken, jwt.MapClaims{})
+	require.NoError(t, err)
+	cnf := token.Claims.(jwt.MapClaims)["cnf"].(map[string]interface{})
+	require.Len(t, cnf["x5t#S256"], 43)
+}
+
+func TestLoadKeySetUsesFileNameTime(t *testing.T) {
+	t.Parallel()
+
+	keyFolder, err := ioutil.TempDir("", "pcbook-jwt")
+	require.NoError(t, err)
+	defer os.RemoveAll(keyFolder)
+
+	oldKey, err := GenerateSigningKey("RS256")
+	require.NoError(t, err)
+	newKey, err := GenerateSigningKey("ES256")
+	require.NoError(t, err)
+
+	oldFile := filepath.Join(keyFolder, "20200101000000-rs256.pem")
+	newFile := filepath.Join(keyFolder, "20200201000000-es256.pem")
+	writeTestSigningKey(t, oldFile, oldKey)
+	writeTestSigningKey(t, newFile, newKey)
+
+	// 恢复备份后，旧密钥文件的修改时间反而更晚
+	require.NoError(t, os.Chtimes(newFile, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+	require.NoError(t, os.Chtimes(oldFile, time.Now(), time.Now()))
+
+	keySet, err := LoadKeySet(keyFolder, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, newKey.ID, keySet.SigningKey().ID)
+	require.Equal(t, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), keySet.SigningKey().CreatedAt)
+	// 旧密钥在新密钥创建时退役，早已超过保留期
+	require.Nil(t, keySet.VerificationKey(oldKey.ID))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(keyFolder, "signing-key.pem"), nil, 0600))
+	_, err = LoadKeySet(keyFolder, time.Minute)
+	require.Error(t, err)
+}
+
+func writeTestSigningKey(t *testing.T, filename string, key *SigningKey) {
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	require.NoError(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	require.NoError(t, ioutil.WriteFile(filename, data, 0600))
+}
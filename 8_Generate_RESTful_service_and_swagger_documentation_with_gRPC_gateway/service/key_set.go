@@ -0,0 +1,298 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SigningKey is an asymmetric key used to sign and verify access tokens
+type SigningKey struct {
+	ID         string // kid，写入令牌头部，用于选择验证公钥
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	CreatedAt  time.Time
+	RetiredAt  time.Time // 被新密钥替换的时间，零值表示仍用于签名
+}
+
+// NewSigningKey returns a signing key for an RSA or P-256 ECDSA private key
+func NewSigningKey(privateKey crypto.Signer, createdAt time.Time) (*SigningKey, error) {
+	var method jwt.SigningMethod
+
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported elliptic curve: %s", key.Curve.Params().Name)
+		}
+		method = jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", privateKey)
+	}
+
+	key := &SigningKey{
+		Method:     method,
+		PrivateKey: privateKey,
+		CreatedAt:  createdAt,
+	}
+
+	kid, err := key.thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	key.ID = kid
+
+	return key, nil
+}
+
+// GenerateSigningKey generates a new RS256 or ES256 signing key
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var privateKey crypto.Signer
+	var err error
+
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate private key: %w", err)
+	}
+
+	return NewSigningKey(privateKey, time.Now())
+}
+
+// signingKeyTimeLayout is the UTC creation time that prefixes the key file names, e.g. 20200102150405-rs256.pem
+const signingKeyTimeLayout = "20060102150405"
+
+// LoadSigningKey loads a PEM encoded private key file as a signing key.
+// The creation time is read from the file name, because copying or restoring the file resets its modification time.
+func LoadSigningKey(filename string) (*SigningKey, error) {
+	createdAt, err := signingKeyCreatedAt(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", filename)
+	}
+
+	privateKey, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key %s: %w", filename, err)
+	}
+
+	return NewSigningKey(privateKey, createdAt)
+}
+
+func signingKeyCreatedAt(filename string) (time.Time, error) {
+	name := filepath.Base(filename)
+	prefix := strings.SplitN(name, "-", 2)[0]
+
+	createdAt, err := time.ParseInLocation(signingKeyTimeLayout, prefix, time.UTC)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("signing key file %s must be named <%s>-<name>.pem", name, signingKeyTimeLayout)
+	}
+	return createdAt, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type: %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown private key format")
+}
+
+// JWK returns the public part of the key in JSON web key format
+func (key *SigningKey) JWK() map[string]string {
+	jwk := key.publicJWK()
+	jwk["kid"] = key.ID
+	jwk["use"] = "sig"
+	jwk["alg"] = key.Method.Alg()
+	return jwk
+}
+
+// publicJWK returns only the required members of the public key, as defined by RFC 7638
+func (key *SigningKey) publicJWK() map[string]string {
+	switch publicKey := key.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		return map[string]string{
+			"kty": "EC",
+			"crv": publicKey.Curve.Params().Name,
+			"x":   base64.RawURLEncoding.EncodeToString(padBytes(publicKey.X.Bytes(), size)),
+			"y":   base64.RawURLEncoding.EncodeToString(padBytes(publicKey.Y.Bytes(), size)),
+		}
+	default:
+		return map[string]string{}
+	}
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint, which is used as the key ID
+func (key *SigningKey) thumbprint() (string, error) {
+	// json.Marshal 按字母顺序输出map的键，正好满足RFC 7638的要求
+	data, err := json.Marshal(key.publicJWK())
+	if err != nil {
+		return "", fmt.Errorf("cannot compute key thumbprint: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func padBytes(data []byte, size int) []byte {
+	if len(data) >= size {
+		return data
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(data):], data)
+	return padded
+}
+
+// KeySet holds the current signing key and the retired keys that still verify unexpired tokens
+type KeySet struct {
+	mutex     sync.RWMutex
+	keys      []*SigningKey // 按创建时间排序，最后一个为当前签名密钥
+	retention time.Duration // 旧密钥被替换后仍可用于验证的时间，应不小于令牌有效期
+	now       func() time.Time
+}
+
+// NewKeySet returns a new key set signing with the given key
+func NewKeySet(signingKey *SigningKey, retention time.Duration) *KeySet {
+	return &KeySet{
+		keys:      []*SigningKey{signingKey},
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+// LoadKeySet loads all PEM private keys in a folder, the file names are prefixed with their UTC creation time
+// (e.g. 20200102150405-rs256.pem), the newest key signs new tokens
+func LoadKeySet(keyFolder string, retention time.Duration) (*KeySet, error) {
+	filenames, err := filepath.Glob(filepath.Join(keyFolder, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("no signing key found in %s", keyFolder)
+	}
+
+	keys := make([]*SigningKey, 0, len(filenames))
+	for _, filename := range filenames {
+		key, err := LoadSigningKey(filename)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	// 时间前缀长度固定，按文件名排序就是按创建时间排序
+	keySet := NewKeySet(keys[0], retention)
+	for _, key := range keys[1:] {
+		keySet.rotate(key, key.CreatedAt)
+	}
+	return keySet, nil
+}
+
+// Rotate makes the given key the new signing key, the previous keys keep verifying until retention passes
+func (keySet *KeySet) Rotate(signingKey *SigningKey) {
+	keySet.rotate(signingKey, keySet.now())
+}
+
+func (keySet *KeySet) rotate(signingKey *SigningKey, retiredAt time.Time) {
+	keySet.mutex.Lock()
+	defer keySet.mutex.Unlock()
+
+	current := keySet.keys[len(keySet.keys)-1]
+	current.RetiredAt = retiredAt
+
+	keySet.keys = append(keySet.verificationKeys(), signingKey)
+}
+
+// SigningKey returns the key used to sign new tokens
+func (keySet *KeySet) SigningKey() *SigningKey {
+	keySet.mutex.RLock()
+	defer keySet.mutex.RUnlock()
+
+	return keySet.keys[len(keySet.keys)-1]
+}
+
+// VerificationKey finds the key with the given ID, it returns nil if the key is unknown or has been retired for too long
+func (keySet *KeySet) VerificationKey(kid string) *SigningKey {
+	keySet.mutex.RLock()
+	defer keySet.mutex.RUnlock()
+
+	for _, key := range keySet.verificationKeys() {
+		if key.ID == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// JWKS returns the public keys that can verify tokens as a JSON web key set
+func (keySet *KeySet) JWKS() map[string][]map[string]string {
+	keySet.mutex.RLock()
+	defer keySet.mutex.RUnlock()
+
+	keys := keySet.verificationKeys()
+	jwks := make([]map[string]string, 0, len(keys))
+	for _, key := range keys {
+		jwks = append(jwks, key.JWK())
+	}
+	return map[string][]map[string]string{"keys": jwks}
+}
+
+// verificationKeys returns the keys that still verify tokens, the caller must hold the lock
+func (keySet *KeySet) verificationKeys() []*SigningKey {
+	now := keySet.now()
+	keys := make([]*SigningKey, 0, len(keySet.keys))
+	for _, key := range keySet.keys {
+		if !key.RetiredAt.IsZero() && now.After(key.RetiredAt.Add(keySet.retention)) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
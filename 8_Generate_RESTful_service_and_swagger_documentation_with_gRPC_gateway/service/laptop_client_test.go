@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
@@ -34,7 +35,7 @@ func TestClientCreateLaptop(t *testing.T) {
 	require.Equal(t, expectedId, res.Id)
 
 	// check that the laptop is saved to the store
-	other, err := laptopStore.Find(res.Id)
+	other, err := laptopStore.Find(DefaultTenantID, res.Id)
 	require.NoError(t, err)
 	require.NotNil(t, other)
 
@@ -96,7 +97,7 @@ func TestClientSearchLaptop(t *testing.T) {
 		case 2:
 			laptop.Cpu.MinGhz = 2.0
 		case 3:
-			laptop.Ram = &pb.Memory{Value: 4096, Unit: pb.Memory_GIGABYTE}
+			laptop.Ram = &pb.Memory{Value: 4096, Unit: pb.Memory_MEGABATE}
 		case 4:
 			laptop.PriceUsd = 1999
 			laptop.Cpu.NumberCores = 4
@@ -113,7 +114,7 @@ func TestClientSearchLaptop(t *testing.T) {
 			expectedIDs[laptop.Id] = true
 		}
 
-		err := laptopStore.Save(laptop)
+		err := laptopStore.Save(DefaultTenantID, laptop)
 		require.NoError(t, err)
 	}
 
@@ -142,11 +143,15 @@ func TestClientUploadImage(t *testing.T) {
 	t.Parallel()
 
 	testImageFolder := "../tmp"
+	imageFolder, err := ioutil.TempDir("", "pcbook-images")
+	require.NoError(t, err)
+	defer os.RemoveAll(imageFolder)
+
 	laptopStore := NewInMemoryLaptopStore()
-	imageStore := NewDiskImageStore(testImageFolder)
+	imageStore := NewDiskImageStore(imageFolder)
 
 	laptop := sample.NewLaptop()
-	err := laptopStore.Save(laptop)
+	err = laptopStore.Save(DefaultTenantID, laptop)
 	require.NoError(t, err)
 
 	serverAddress := startTestLaptopServer(t, laptopStore, imageStore, nil)
@@ -202,10 +207,8 @@ func TestClientUploadImage(t *testing.T) {
 	require.EqualValues(t, size, res.GetSize())
 
 	// 检测文件是否存在
-	saveImagePath := fmt.Sprintf("%s/%s%s", testImageFolder, res.GetId(), imageType)
+	saveImagePath := fmt.Sprintf("%s/%s%s", imageFolder, res.GetId(), imageType)
 	require.FileExists(t, saveImagePath)
-	// 结束时删除文件（windows使用os.Remove()会报错，删除不了）
-	// require.NoError(t, os.Remove(saveImagePath))
 }
 
 func TestClientRateLaptop(t *testing.T) {
@@ -215,7 +218,7 @@ func TestClientRateLaptop(t *testing.T) {
 	ratingStore := NewInMemoryRatingStore()
 
 	laptop := sample.NewLaptop()
-	err := laptopStore.Save(laptop)
+	err := laptopStore.Save(DefaultTenantID, laptop)
 	require.NoError(t, err)
 
 	serverAddress := startTestLaptopServer(t, laptopStore, nil, ratingStore)
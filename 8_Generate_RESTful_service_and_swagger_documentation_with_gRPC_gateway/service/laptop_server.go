@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"io"
-	"log"
+	"pcbook/logging"
 	"pcbook/pb"
+	"pcbook/tracing"
 )
 
 // maxImageSize 2 megabyte
@@ -35,13 +38,14 @@ func (server *LaptopServer) CreateLaptop(
 	req *pb.CreateLaptopRequest,
 ) (*pb.CreateLaptopResponse, error) {
 	laptop := req.GetLaptop()
-	log.Printf("receive a create-loatop request with id: %s", laptop.Id)
+	logger := logging.FromContext(ctx)
+	logger.Info("received create-laptop request", "laptop_id", laptop.GetId())
 
 	if len(laptop.Id) > 0 { // laptop.Id 不为空，检查其id是否为合法uuid
 		// check if it's valid UUID
 		_, err := uuid.Parse(laptop.Id)
 		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "laptop ID is not a valid UUID: %v", err)
+			return nil, invalidField(pb.ErrorReason_INVALID_LAPTOP_ID, "laptop.id", fmt.Sprintf("not a valid UUID: %v", err))
 		}
 	} else { // laptop.Id 为空时，为其生成uuid
 		id, err := uuid.NewRandom()
@@ -58,31 +62,204 @@ func (server *LaptopServer) CreateLaptop(
 		return nil, err
 	}
 
+	// 笔记本的所有者是调用者本人，不能由客户端指定
+	laptop.Owner = ""
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		laptop.Owner = principal.Username
+	}
+
+	// 笔记本属于调用者的租户，只有超级管理员可以通过x-tenant-id选择租户
+	tenantID, err := TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tenantID == AllTenants {
+		return nil, errorWithReason(
+			codes.InvalidArgument,
+			pb.ErrorReason_TENANT_REQUIRED,
+			map[string]string{"header": TenantHeader},
+			fmt.Sprintf("choose a tenant with the %s header to create a laptop", TenantHeader),
+		)
+	}
+	laptop.TenantId = tenantID
+
 	// save the laptop to laptopStore
-	err := server.laptopStore.Save(laptop)
+	_, span := tracing.StartSpan(ctx, "LaptopStore.Save")
+	span.SetAttribute("laptop.id", laptop.GetId())
+	err = server.laptopStore.Save(tenantID, laptop)
+	span.SetError(err)
+	span.End()
 	if err != nil {
-		code := codes.Internal
 		if errors.Is(err, ErrAlreadyExists) { // 如果是laptop.Id已存在错误，则错误就不是Internal，需要修改
-			code = codes.AlreadyExists
+			return nil, laptopAlreadyExists(laptop.GetId())
 		}
-		return nil, status.Errorf(code, "cannot save laptop to the laptopStore: %v", err)
+		return nil, status.Errorf(codes.Internal, "cannot save laptop to the laptopStore: %v", err)
 	}
-	log.Printf("saved latop with id: %s", laptop.Id)
+	logger.Info("saved laptop", "laptop_id", laptop.GetId())
 	resp := &pb.CreateLaptopResponse{Id: laptop.Id}
 	return resp, nil
 }
 
+func (server *LaptopServer) UpdateLaptop(
+	ctx context.Context,
+	req *pb.UpdateLaptopRequest,
+) (*pb.UpdateLaptopResponse, error) {
+	laptop := req.GetLaptop()
+	logger := logging.FromContext(ctx)
+	logger.Info("received update-laptop request", "laptop_id", laptop.GetId())
+
+	found, err := server.findOwnLaptop(ctx, laptop.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	// 所有者保持不变
+	laptop.Owner = found.Owner
+
+	_, span := tracing.StartSpan(ctx, "LaptopStore.Update")
+	span.SetAttribute("laptop.id", laptop.GetId())
+	err = server.laptopStore.Update(found.GetTenantId(), laptop)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, laptopNotFound(laptop.GetId())
+		}
+		return nil, status.Errorf(codes.Internal, "cannot update laptop in the laptopStore: %v", err)
+	}
+
+	logger.Info("updated laptop", "laptop_id", laptop.GetId())
+	resp := &pb.UpdateLaptopResponse{Id: laptop.Id}
+	return resp, nil
+}
+
+func (server *LaptopServer) DeleteLaptop(
+	ctx context.Context,
+	req *pb.DeleteLaptopRequest,
+) (*pb.DeleteLaptopResponse, error) {
+	laptopID := req.GetId()
+	logger := logging.FromContext(ctx)
+	logger.Info("received delete-laptop request", "laptop_id", laptopID)
+
+	found, err := server.findOwnLaptop(ctx, laptopID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := tracing.StartSpan(ctx, "LaptopStore.Delete")
+	span.SetAttribute("laptop.id", laptopID)
+	err = server.laptopStore.Delete(found.GetTenantId(), laptopID)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, laptopNotFound(laptopID)
+		}
+		return nil, status.Errorf(codes.Internal, "cannot delete laptop from the laptopStore: %v", err)
+	}
+
+	logger.Info("deleted laptop", "laptop_id", laptopID)
+	return &pb.DeleteLaptopResponse{}, nil
+}
+
+// findOwnLaptop finds a laptop that the caller is allowed to modify
+func (server *LaptopServer) findOwnLaptop(ctx context.Context, laptopID string) (*pb.Laptop, error) {
+	laptop, err := server.findLaptop(ctx, laptopID)
+	if err != nil {
+		return nil, err
+	}
+	if laptop == nil {
+		return nil, laptopNotFound(laptopID)
+	}
+
+	err = checkOwner(ctx, laptop)
+	if err != nil {
+		return nil, err
+	}
+	return laptop, nil
+}
+
+// findLaptop finds a laptop in the tenant of the caller, it returns nil if the laptop doesn't exist.
+// The errors are gRPC status errors.
+func (server *LaptopServer) findLaptop(ctx context.Context, laptopID string) (*pb.Laptop, error) {
+	tenantID, err := TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := tracing.StartSpan(ctx, "LaptopStore.Find")
+	span.SetAttribute("laptop.id", laptopID)
+	laptop, err := server.laptopStore.Find(tenantID, laptopID)
+	span.SetError(err)
+	span.End()
+	if errors.Is(err, ErrAmbiguousID) {
+		return nil, errorWithReason(
+			codes.InvalidArgument,
+			pb.ErrorReason_TENANT_REQUIRED,
+			map[string]string{"header": TenantHeader},
+			fmt.Sprintf("laptop %s exists in several tenants, choose a tenant with the %s header", laptopID, TenantHeader),
+		)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+	}
+	return laptop, nil
+}
+
+// checkOwner checks if the caller can modify the laptop:
+// admins can modify any laptop, other users only the laptops they created.
+// Without authentication only the laptops that have no owner can be modified.
+func checkOwner(ctx context.Context, laptop *pb.Laptop) error {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		if laptop.GetOwner() == "" {
+			return nil
+		}
+		return errorWithReason(
+			codes.Unauthenticated,
+			pb.ErrorReason_LOGIN_REQUIRED,
+			map[string]string{"laptop_id": laptop.GetId()},
+			fmt.Sprintf("laptop %s has an owner, login is required", laptop.GetId()),
+		)
+	}
+
+	if principal.IsAdmin() || principal.Username == laptop.GetOwner() {
+		return nil
+	}
+	return errorWithReason(
+		codes.PermissionDenied,
+		pb.ErrorReason_NOT_LAPTOP_OWNER,
+		map[string]string{"laptop_id": laptop.GetId()},
+		fmt.Sprintf("laptop %s is not owned by %s", laptop.GetId(), principal.Username),
+		// 不返回所有者，否则没有权限的调用者可以得知其他用户的用户名
+		&errdetails.ResourceInfo{ResourceType: LaptopResourceType, ResourceName: laptop.GetId()},
+	)
+}
+
 func (server *LaptopServer) SearchLaptop(
 	req *pb.SearchLaptopRequest,
 	stream pb.LaptopService_SearchLaptopServer,
 ) error {
 	filter := req.GetFilter()
-	log.Printf("receive a search-laptop request with filter: %v", filter)
+	logger := logging.FromContext(stream.Context())
+	logger.Info("received search-laptop request", "filter", filter)
+
+	tenantID, err := TenantFromContext(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	// 搜索的span包括向客户端发送结果的时间
+	ctx, span := tracing.StartSpan(stream.Context(), "LaptopStore.Search")
+	defer span.End()
+	found := 0
 
-	err := server.laptopStore.Search(
-		stream.Context(), // 从流中获取上下文
+	err = server.laptopStore.Search(
+		ctx, // 从流中获取上下文
+		tenantID,
 		filter,
 		func(laptop *pb.Laptop) error {
+			found++
 			res := &pb.SearchLaptopResponse{Laptop: laptop}
 
 			err := stream.Send(res)
@@ -90,11 +267,17 @@ func (server *LaptopServer) SearchLaptop(
 				return err
 			}
 
-			log.Printf("send laptop with id: %s", laptop.Id)
+			logger.Debug("sent laptop", "laptop_id", laptop.GetId())
 			return nil
 		},
 	)
+	span.SetAttribute("laptop.count", found)
+	span.SetError(err)
 	if err != nil {
+		// 超时或者客户端取消时，搜索在下一台笔记本之前停止
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			return ctxErr
+		}
 		return status.Errorf(codes.Internal, "unexpected error: %v", err)
 	}
 	return nil
@@ -103,19 +286,25 @@ func (server *LaptopServer) SearchLaptop(
 func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServer) error {
 	req, err := stream.Recv()
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot receive image info"))
+		return status.Errorf(codes.Unknown, "cannot receive image info")
 	}
 	laptopID := req.GetInfo().GetLaptopId()
 	imageType := req.GetInfo().GetImageType()
-	log.Printf("reveive an upload-image request for laptop %s with image type %s", laptopID, imageType)
+	logger := logging.FromContext(stream.Context())
+	logger.Info("received upload-image request", "laptop_id", laptopID, "image_type", imageType)
 
 	// check
-	laptop, err := server.laptopStore.Find(laptopID)
+	laptop, err := server.findLaptop(stream.Context(), laptopID)
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "cannot find laptop: %v", err))
+		return err
 	}
 	if laptop == nil {
-		return logError(status.Errorf(codes.InvalidArgument, "laptop %s doesn't exist", laptopID))
+		return laptopNotFound(laptopID)
+	}
+
+	err = checkOwner(stream.Context(), laptop)
+	if err != nil {
+		return err
 	}
 
 	imageData := bytes.Buffer{}
@@ -124,27 +313,27 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 	for {
 		// check context error
 		if err := contextError(stream.Context()); err != nil {
-			return nil
+			return err
 		}
 
-		log.Print("waiting to receive more data")
+		logger.Debug("waiting to receive more data")
 		req, err := stream.Recv()
 		if err == io.EOF {
-			log.Print("no more data")
+			logger.Debug("no more data")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err))
+			return status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err)
 		}
 
 		chunk := req.GetChunkData()
 		size := len(chunk)
 
-		log.Printf("receive a chunk with size: %d", size)
+		logger.Debug("received a chunk", "size", size)
 
 		imageSize += size
 		if imageSize > maxImageSize {
-			return logError(status.Errorf(codes.InvalidArgument, "image is too large: %d > %d", imageSize, maxImageSize))
+			return invalidField(pb.ErrorReason_IMAGE_TOO_LARGE, "chunk_data", fmt.Sprintf("image is too large: %d > %d bytes", imageSize, maxImageSize))
 		}
 
 		// 模拟超时：假设服务端以某种方式正在非常缓慢地写入数据
@@ -152,13 +341,23 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 
 		_, err = imageData.Write(chunk)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot write chunk data: %v", err))
+			return status.Errorf(codes.Internal, "cannot write chunk data: %v", err)
 		}
 	}
 
-	imageID, err := server.imageStore.Save(laptopID, imageType, imageData)
+	// 图片写入磁盘，可能是上传中最慢的一步
+	_, span := tracing.StartSpan(stream.Context(), "ImageStore.Save")
+	span.SetAttribute("laptop.id", laptopID)
+	span.SetAttribute("image.size", imageSize)
+	imageID, err := server.imageStore.Save(stream.Context(), laptop.GetTenantId(), laptopID, imageType, imageData)
+	span.SetAttribute("image.id", imageID)
+	span.SetError(err)
+	span.End()
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "cannot save image to the store: %v", err))
+		if ctxErr := contextError(stream.Context()); ctxErr != nil {
+			return ctxErr
+		}
+		return status.Errorf(codes.Internal, "cannot save image to the store: %v", err)
 	}
 
 	resp := &pb.UploadImageResponse{
@@ -168,14 +367,15 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 
 	err = stream.SendAndClose(resp)
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot send response: %v", err))
+		return status.Errorf(codes.Unknown, "cannot send response: %v", err)
 	}
 
-	log.Printf("saved image with id: %s, size: %d", imageID, imageSize)
+	logger.Info("saved image", "image_id", imageID, "size", imageSize)
 	return nil
 }
 
 func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
+	logger := logging.FromContext(stream.Context())
 	for {
 		err := contextError(stream.Context())
 		if err != nil {
@@ -184,30 +384,34 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 
 		req, err := stream.Recv()
 		if err == io.EOF {
-			log.Print("no more data")
+			logger.Debug("no more data")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot receive stream request: %v", err))
+			return status.Errorf(codes.Unknown, "cannot receive stream request: %v", err)
 		}
 
 		laptopID := req.GetLaptopId()
 		score := req.GetScore()
 
-		log.Printf("received a rate-laptop request: id = %s, score = %.2f", laptopID, score)
+		logger.Info("received rate-laptop request", "laptop_id", laptopID, "score", score)
 
 		// check if exists
-		found, err := server.laptopStore.Find(laptopID)
+		found, err := server.findLaptop(stream.Context(), laptopID)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot find laptop: %v", err))
+			return err
 		}
 		if found == nil {
-			return logError(status.Errorf(codes.NotFound, "laptopID %s is not found", laptopID))
+			return laptopNotFound(laptopID)
 		}
 
-		rating, err := server.ratingStore.Add(laptopID, score)
+		_, span := tracing.StartSpan(stream.Context(), "RatingStore.Add")
+		span.SetAttribute("laptop.id", laptopID)
+		rating, err := server.ratingStore.Add(found.GetTenantId(), laptopID, score)
+		span.SetError(err)
+		span.End()
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot add rating to the store: %v", err))
+			return status.Errorf(codes.Internal, "cannot add rating to the store: %v", err)
 		}
 
 		resp := &pb.RateLaptopResponse{
@@ -218,25 +422,18 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 
 		err = stream.Send(resp)
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot send stream response: %v", err))
+			return status.Errorf(codes.Unknown, "cannot send stream response: %v", err)
 		}
 	}
 	return nil
 }
 
-func logError(err error) error {
-	if err != nil {
-		log.Print(err)
-	}
-	return err
-}
-
 func contextError(ctx context.Context) error {
 	switch ctx.Err() {
 	case context.Canceled:
-		return logError(status.Error(codes.Canceled, "request is canceled"))
+		return status.Error(codes.Canceled, "request is canceled")
 	case context.DeadlineExceeded:
-		return logError(status.Error(codes.DeadlineExceeded, "deadline is exceeded"))
+		return status.Error(codes.DeadlineExceeded, "deadline is exceeded")
 	default:
 		return nil
 	}
@@ -3,7 +3,9 @@ package service
 import (
 	"context"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"pcbook/pb"
 	"pcbook/sample"
@@ -22,7 +24,7 @@ func TestServerCreateLaptop(t *testing.T) {
 
 	laptopDuplicateID := sample.NewLaptop()
 	storeDuplicateID := NewInMemoryLaptopStore()
-	err := storeDuplicateID.Save(laptopDuplicateID)
+	err := storeDuplicateID.Save(DefaultTenantID, laptopDuplicateID)
 	require.Nil(t, err)
 
 	testCases := []struct {
@@ -95,3 +97,202 @@ func TestServerCreateLaptop(t *testing.T) {
 
 
 
+
+func TestServerLaptopOwnership(t *testing.T) {
+	t.Parallel()
+
+	editor := &Principal{Username: "alice", Role: "editor", Roles: map[string]bool{"editor": true, "user": true}}
+	otherEditor := &Principal{Username: "bob", Role: "editor", Roles: map[string]bool{"editor": true, "user": true}}
+	admin := &Principal{Username: "root", Role: "admin", Roles: map[string]bool{"admin": true, "editor": true, "user": true}}
+
+	testCases := []struct {
+		name      string
+		principal *Principal
+		code      codes.Code
+	}{
+		{name: "owner", principal: editor, code: codes.OK},
+		{name: "admin", principal: admin, code: codes.OK},
+		{name: "other_user", principal: otherEditor, code: codes.PermissionDenied},
+		{name: "anonymous", principal: nil, code: codes.Unauthenticated},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := NewInMemoryLaptopStore()
+			server := NewLaptopServer(store, nil, nil)
+
+			// 客户端指定的所有者会被忽略
+			laptop := sample.NewLaptop()
+			laptop.Owner = "root"
+			res, err := server.CreateLaptop(ContextWithPrincipal(context.Background(), editor), &pb.CreateLaptopRequest{Laptop: laptop})
+			require.NoError(t, err)
+
+			saved, err := store.Find(DefaultTenantID, res.Id)
+			require.NoError(t, err)
+			require.Equal(t, "alice", saved.Owner)
+
+			ctx := context.Background()
+			if tc.principal != nil {
+				ctx = ContextWithPrincipal(ctx, tc.principal)
+			}
+
+			update := sample.NewLaptop()
+			update.Id = res.Id
+			_, err = server.UpdateLaptop(ctx, &pb.UpdateLaptopRequest{Laptop: update})
+			require.Equal(t, tc.code, status.Code(err))
+
+			_, err = server.DeleteLaptop(ctx, &pb.DeleteLaptopRequest{Id: res.Id})
+			require.Equal(t, tc.code, status.Code(err))
+
+			found, err := store.Find(DefaultTenantID, res.Id)
+			require.NoError(t, err)
+			if tc.code == codes.OK {
+				require.Nil(t, found)
+			} else {
+				require.NotNil(t, found)
+				requireSameLaptop(t, saved, found)
+			}
+		})
+	}
+}
+
+func TestServerTenantIsolation(t *testing.T) {
+	t.Parallel()
+
+	editorRoles := map[string]bool{"editor": true, "user": true}
+	editorA := &Principal{Username: "alice", Role: "editor", Roles: editorRoles, TenantID: "a"}
+	editorB := &Principal{Username: "bob", Role: "editor", Roles: editorRoles, TenantID: "b"}
+	superAdmin := &Principal{Username: "root", Role: RoleSuperAdmin, Roles: map[string]bool{RoleSuperAdmin: true, "admin": true, "editor": true, "user": true}}
+
+	store := NewInMemoryLaptopStore()
+	ratingStore := NewInMemoryRatingStore()
+	server := NewLaptopServer(store, nil, ratingStore)
+
+	ctxA := ContextWithPrincipal(context.Background(), editorA)
+	ctxB := ContextWithPrincipal(context.Background(), editorB)
+	ctxSuper := ContextWithPrincipal(context.Background(), superAdmin)
+
+	// 客户端指定的租户会被忽略
+	laptopA := sample.NewLaptop()
+	laptopA.TenantId = "b"
+	resA, err := server.CreateLaptop(ctxA, &pb.CreateLaptopRequest{Laptop: laptopA})
+	require.NoError(t, err)
+	resB, err := server.CreateLaptop(ctxB, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+
+	saved, err := store.Find(AllTenants, resA.Id)
+	require.NoError(t, err)
+	require.Equal(t, "a", saved.GetTenantId())
+
+	require.ElementsMatch(t, []string{resA.Id}, searchLaptopIDs(t, server, ctxA))
+	require.ElementsMatch(t, []string{resB.Id}, searchLaptopIDs(t, server, ctxB))
+	require.ElementsMatch(t, []string{resA.Id, resB.Id}, searchLaptopIDs(t, server, ctxSuper))
+	require.ElementsMatch(t, []string{resB.Id}, searchLaptopIDs(t, server, withTenantHeader(ctxSuper, "b")))
+	require.Empty(t, searchLaptopIDs(t, server, context.Background()))
+
+	// 匿名调用者不能通过请求头选择租户
+	require.Empty(t, searchLaptopIDs(t, server, withTenantHeader(context.Background(), "a")))
+
+	// 其他租户的用户不能通过请求头切换租户
+	require.ElementsMatch(t, []string{resB.Id}, searchLaptopIDs(t, server, withTenantHeader(ctxB, "a")))
+
+	// 其他租户的笔记本对调用者来说不存在
+	update := sample.NewLaptop()
+	update.Id = resA.Id
+	_, err = server.UpdateLaptop(ctxB, &pb.UpdateLaptopRequest{Laptop: update})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = server.DeleteLaptop(ctxB, &pb.DeleteLaptopRequest{Id: resA.Id})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	found, err := store.Find("a", resA.Id)
+	require.NoError(t, err)
+	requireSameLaptop(t, saved, found)
+
+	// 其他租户可以使用相同的ID，调用者不能由此得知其他租户的笔记本
+	reused := sample.NewLaptop()
+	reused.Id = resA.Id
+	_, err = server.CreateLaptop(ctxB, &pb.CreateLaptopRequest{Laptop: reused})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{resA.Id, resB.Id}, searchLaptopIDs(t, server, ctxB))
+
+	found, err = store.Find("a", resA.Id)
+	require.NoError(t, err)
+	requireSameLaptop(t, saved, found)
+
+	// 多个租户使用的ID需要超级管理员选择租户
+	_, err = store.Find(AllTenants, resA.Id)
+	require.Equal(t, ErrAmbiguousID, err)
+	_, err = server.DeleteLaptop(ctxSuper, &pb.DeleteLaptopRequest{Id: resA.Id})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = server.CreateLaptop(ctxSuper, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	resC, err := server.CreateLaptop(withTenantHeader(ctxSuper, "c"), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+	found, err = store.Find("c", resC.Id)
+	require.NoError(t, err)
+	require.Equal(t, "root", found.GetOwner())
+
+	_, err = server.DeleteLaptop(withTenantHeader(ctxSuper, "a"), &pb.DeleteLaptopRequest{Id: resA.Id})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{resA.Id, resB.Id, resC.Id}, searchLaptopIDs(t, server, ctxSuper))
+	found, err = store.Find("b", resA.Id)
+	require.NoError(t, err)
+	require.Equal(t, "b", found.GetTenantId())
+
+	_, err = server.CreateLaptop(withTenantHeader(ctxSuper, "../etc"), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestInMemoryRatingStoreTenants(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryRatingStore()
+	laptopID := sample.NewLaptop().GetId()
+
+	rating, err := store.Add("a", laptopID, 10)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, rating.Count)
+
+	rating, err = store.Add("b", laptopID, 4)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, rating.Count)
+	require.Equal(t, 4.0, rating.Sum)
+}
+
+// searchLaptopStream collects the laptops sent by SearchLaptop
+type searchLaptopStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	laptops []*pb.Laptop
+}
+
+func (stream *searchLaptopStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *searchLaptopStream) Send(res *pb.SearchLaptopResponse) error {
+	stream.laptops = append(stream.laptops, res.GetLaptop())
+	return nil
+}
+
+func searchLaptopIDs(t *testing.T, server *LaptopServer, ctx context.Context) []string {
+	stream := &searchLaptopStream{ctx: ctx}
+	err := server.SearchLaptop(&pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 1e9}}, stream)
+	require.NoError(t, err)
+
+	ids := []string{}
+	for _, laptop := range stream.laptops {
+		ids = append(ids, laptop.GetId())
+	}
+	return ids
+}
+
+func withTenantHeader(ctx context.Context, tenantID string) context.Context {
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(TenantHeader, tenantID))
+}
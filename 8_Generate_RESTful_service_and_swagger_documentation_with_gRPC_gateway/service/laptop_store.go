@@ -5,7 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jinzhu/copier"
-	"log"
+	"pcbook/logging"
 	"pcbook/pb"
 	"sync"
 )
@@ -13,34 +13,72 @@ import (
 // ErrAlreadyExists is returned when a record with the same ID already exists in the store
 var ErrAlreadyExists = errors.New("record already exists")
 
-// LaptopStore is an interface to store laptop
+// ErrNotFound is returned when a record with the given ID doesn't exist in the store
+var ErrNotFound = errors.New("record not found")
+
+// ErrAmbiguousID is returned when a record is looked up in all tenants, and several tenants use its ID
+var ErrAmbiguousID = errors.New("record ID is used by several tenants")
+
+// LaptopStore is an interface to store laptop.
+// Every operation is scoped to a tenant, the laptops of other tenants are invisible as if they don't exist,
+// so the same ID can be used in several tenants. Find, Delete and Search also accept AllTenants.
 type LaptopStore interface {
-	// Save saves the laptop to the store
-	Save(laptop *pb.Laptop) error
+	// Save saves the laptop to the store in the tenant
+	Save(tenantID string, laptop *pb.Laptop) error
 	// Find finds a laptop by ID
-	Find(id string) (*pb.Laptop, error)
+	Find(tenantID string, id string) (*pb.Laptop, error)
+	// Update replaces an existing laptop of the tenant in the store
+	Update(tenantID string, laptop *pb.Laptop) error
+	// Delete deletes a laptop by ID
+	Delete(tenantID string, id string) error
 	// Search searches for laptop with filter, returns one by one via the found funtion
-	Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error ) error
+	Search(ctx context.Context, tenantID string, filter *pb.Filter, found func(laptop *pb.Laptop) error ) error
+	// Ready returns an error if the store cannot serve requests
+	Ready() error
 }
 
 // InMemoryLaptopStore stores laptop in memory
 type InMemoryLaptopStore struct {
 	mutex sync.RWMutex
-	data  map[string]*pb.Laptop
+	data  map[laptopKey]*pb.Laptop
+}
+
+// laptopKey identifies a laptop in its tenant
+type laptopKey struct {
+	tenantID string
+	id       string
 }
 
 func NewInMemoryLaptopStore() *InMemoryLaptopStore {
 	return &InMemoryLaptopStore{
-		data: make(map[string]*pb.Laptop),
+		data: make(map[laptopKey]*pb.Laptop),
 	}
 }
 
-func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
+// Ready returns nil, the memory is always available
+func (store *InMemoryLaptopStore) Ready() error {
+	return nil
+}
+
+// Count returns the number of laptops of all tenants
+func (store *InMemoryLaptopStore) Count() int {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return len(store.data)
+}
+
+func (store *InMemoryLaptopStore) Save(tenantID string, laptop *pb.Laptop) error {
+	if tenantID == AllTenants {
+		return fmt.Errorf("cannot save laptop to all tenants")
+	}
+
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	// 如果内存中已经存在此笔记本了，返回已存在错误
-	if store.data[laptop.Id] != nil {
+	// 如果租户中已经存在此笔记本了，返回已存在错误
+	// 其他租户使用了该ID时可以保存，调用者不能由此得知其他租户的笔记本
+	if store.data[laptopKey{tenantID, laptop.Id}] != nil {
 		return ErrAlreadyExists
 	}
 
@@ -49,26 +87,84 @@ func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 	if err != nil {
 		return err
 	}
+	other.TenantId = tenantID
 
-	store.data[other.Id] = other
+	store.data[laptopKey{tenantID, other.Id}] = other
 	return nil
 }
 
-func  (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
+func  (store *InMemoryLaptopStore) Find(tenantID string, id string) (*pb.Laptop, error) {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 
-	laptop := store.data[id]
-	if laptop == nil {
-		return nil, nil
+	laptop, err := store.find(tenantID, id)
+	if laptop == nil || err != nil {
+		return nil, err
 	}
 
 	// deep copy
 	return deepCopy(laptop)
 }
 
+// find returns the laptop of the tenant, or nil if it doesn't exist, it must be called with the mutex locked.
+// In all tenants, the ID must be used by only one tenant.
+func (store *InMemoryLaptopStore) find(tenantID string, id string) (*pb.Laptop, error) {
+	if tenantID != AllTenants {
+		return store.data[laptopKey{tenantID, id}], nil
+	}
+
+	var found *pb.Laptop
+	for key, laptop := range store.data {
+		if key.id != id {
+			continue
+		}
+		if found != nil {
+			return nil, ErrAmbiguousID
+		}
+		found = laptop
+	}
+	return found, nil
+}
+
+func (store *InMemoryLaptopStore) Update(tenantID string, laptop *pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// 更新不能把笔记本移到另一个租户
+	key := laptopKey{tenantID, laptop.Id}
+	if store.data[key] == nil {
+		return ErrNotFound
+	}
+
+	other, err := deepCopy(laptop)
+	if err != nil {
+		return err
+	}
+	other.TenantId = tenantID
+
+	store.data[key] = other
+	return nil
+}
+
+func (store *InMemoryLaptopStore) Delete(tenantID string, id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	laptop, err := store.find(tenantID, id)
+	if err != nil {
+		return err
+	}
+	if laptop == nil {
+		return ErrNotFound
+	}
+
+	delete(store.data, laptopKey{laptop.GetTenantId(), id})
+	return nil
+}
+
 func (store *InMemoryLaptopStore) Search(
 	ctx context.Context,
+	tenantID string,
 	filter *pb.Filter,
 	found func(laptop *pb.Laptop) error,
 ) error {
@@ -82,11 +178,11 @@ func (store *InMemoryLaptopStore) Search(
 		// log.Print("check laptop id: ", laptop.GetId())
 
 		if ctx.Err() == context.Canceled || ctx.Err() == context.DeadlineExceeded {
-			log.Print("context is canceled")
-			return errors.New("context is canceled")
+			logging.FromContext(ctx).Debug("search is canceled")
+			return fmt.Errorf("search is canceled: %w", ctx.Err())
 		}
 
-		if isQualified(filter, laptop) {
+		if inTenant(tenantID, laptop.GetTenantId()) && isQualified(filter, laptop) {
 			other, err := deepCopy(laptop)
 			if err != nil {
 				return err
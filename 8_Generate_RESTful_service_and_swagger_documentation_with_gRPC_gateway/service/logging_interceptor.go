@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"pcbook/logging"
+	"strings"
+	"time"
+)
+
+// LoggingInterceptor is a server interceptor that writes a line when an RPC completes,
+// at a level that depends on its status code.
+// It runs after the request ID interceptor, so that the line has the request ID.
+type LoggingInterceptor struct{}
+
+// NewLoggingInterceptor returns a new logging interceptor
+func NewLoggingInterceptor() *LoggingInterceptor {
+	return &LoggingInterceptor{}
+}
+
+// Unary returns a server interceptor function to log unary RPC
+func (interceptor *LoggingInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		res, err := handler(ctx, req)
+		logRPC(ctx, info.FullMethod, start, err)
+		return res, err
+	}
+}
+
+// Stream returns a server interceptor function to log stream RPC
+func (interceptor *LoggingInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		err := handler(srv, stream)
+		logRPC(stream.Context(), info.FullMethod, start, err)
+		return err
+	}
+}
+
+// logRPC writes a line when an RPC completes, at a level that depends on its status code
+func logRPC(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := logging.LevelInfo
+	switch code {
+	case codes.OK:
+		// 负载均衡器的健康检查很频繁，只在调试时记录
+		if strings.HasPrefix(method, healthMethodPrefix) {
+			level = logging.LevelDebug
+		}
+	case codes.Unknown, codes.Internal, codes.DataLoss:
+		level = logging.LevelError
+	default:
+		level = logging.LevelWarn
+	}
+
+	keyvals := []interface{}{"code", code, "duration_ms", time.Since(start).Milliseconds()}
+	if err != nil {
+		keyvals = append(keyvals, "error", status.Convert(err).Message())
+	}
+	logging.FromContext(ctx).Log(level, "finished RPC", keyvals...)
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// LoginLimiter tracks failed login attempts per username and per client address,
+// it delays the next attempt with exponential backoff and locks the key out after too many failures.
+// The attempts are counted when they are allowed, and refunded if they do not fail.
+type LoginLimiter struct {
+	mutex           sync.Mutex
+	attempts        map[string]*loginAttempts
+	maxFailures     int           // 连续失败多少次后锁定
+	baseDelay       time.Duration // 第一次失败后需要等待的时间，之后每次翻倍
+	maxDelay        time.Duration
+	lockoutDuration time.Duration
+	now             func() time.Time
+}
+
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// NewLoginLimiter returns a new login limiter
+func NewLoginLimiter(maxFailures int, baseDelay, maxDelay, lockoutDuration time.Duration) *LoginLimiter {
+	return &LoginLimiter{
+		attempts:        make(map[string]*loginAttempts),
+		maxFailures:     maxFailures,
+		baseDelay:       baseDelay,
+		maxDelay:        maxDelay,
+		lockoutDuration: lockoutDuration,
+		now:             time.Now,
+	}
+}
+
+// SetLimits changes the limits, the failures that have been counted are kept
+func (limiter *LoginLimiter) SetLimits(maxFailures int, baseDelay, maxDelay, lockoutDuration time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.maxFailures = maxFailures
+	limiter.baseDelay = baseDelay
+	limiter.maxDelay = maxDelay
+	limiter.lockoutDuration = lockoutDuration
+}
+
+// UserKey returns the limiter key of a username
+func UserKey(username string) string {
+	return "user:" + username
+}
+
+// AddressKey returns the limiter key of a client address
+func AddressKey(address string) string {
+	return "addr:" + address
+}
+
+// Allow checks if a login attempt is allowed for all the keys, if not it returns how long to wait.
+// An allowed attempt is counted as a failure right away, so that concurrent attempts wait for its backoff
+// instead of all passing before the first failure is recorded; Succeed or Refund gives it back.
+func (limiter *LoginLimiter) Allow(keys ...string) (bool, time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	var retryAfter time.Duration
+	for _, key := range keys {
+		attempts := limiter.attempts[key]
+		if attempts == nil {
+			continue
+		}
+		if wait := attempts.blockedUntil.Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+
+	limiter.removeExpired(now)
+	for _, key := range keys {
+		attempts := limiter.attempts[key]
+		if attempts == nil {
+			attempts = &loginAttempts{}
+			limiter.attempts[key] = attempts
+		}
+
+		attempts.failures++
+		attempts.lastFailure = now
+		attempts.blockedUntil = now.Add(limiter.delay(attempts.failures))
+	}
+	return true, 0
+}
+
+// Refund gives back an attempt of the keys that was allowed but did not fail,
+// such as a successful login or an attempt that could not be checked
+func (limiter *LoginLimiter) Refund(keys ...string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	for _, key := range keys {
+		attempts := limiter.attempts[key]
+		if attempts == nil {
+			continue
+		}
+
+		attempts.failures--
+		if attempts.failures <= 0 {
+			delete(limiter.attempts, key)
+			continue
+		}
+		// 取消这次尝试的退避，但保留其他失败造成的锁定
+		if attempts.failures < limiter.maxFailures && attempts.blockedUntil.After(now) {
+			attempts.blockedUntil = now
+		}
+	}
+}
+
+// Succeed resets the failed attempts of the keys after a successful login
+func (limiter *LoginLimiter) Succeed(keys ...string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(limiter.attempts, key)
+	}
+}
+
+// Unlock removes the lockout of a username, it returns false if the user was not locked
+func (limiter *LoginLimiter) Unlock(username string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	key := UserKey(username)
+	_, ok := limiter.attempts[key]
+	delete(limiter.attempts, key)
+	return ok
+}
+
+func (limiter *LoginLimiter) delay(failures int) time.Duration {
+	if failures >= limiter.maxFailures {
+		return limiter.lockoutDuration
+	}
+
+	delay := limiter.baseDelay
+	for i := 1; i < failures && delay < limiter.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > limiter.maxDelay {
+		delay = limiter.maxDelay
+	}
+	return delay
+}
+
+// removeExpired forgets the failures that are older than the lockout duration, the caller must hold the lock
+func (limiter *LoginLimiter) removeExpired(now time.Time) {
+	for key, attempts := range limiter.attempts {
+		if now.After(attempts.blockedUntil) && now.Sub(attempts.lastFailure) > limiter.lockoutDuration {
+			delete(limiter.attempts, key)
+		}
+	}
+}
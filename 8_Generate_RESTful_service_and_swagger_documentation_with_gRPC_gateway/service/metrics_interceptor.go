@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"pcbook/metrics"
+	"pcbook/pb"
+	"sync/atomic"
+	"time"
+)
+
+// streamMessageBuckets are the upper bounds of the number of messages per stream
+var streamMessageBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 5000}
+
+// MetricsInterceptor is a server interceptor that records the count, status and latency of the RPCs,
+// the messages of each stream and the uploaded image bytes.
+// It should run first, so that the RPCs rejected by the other interceptors are counted too.
+type MetricsInterceptor struct {
+	requests         *metrics.CounterVec
+	duration         *metrics.HistogramVec
+	messagesReceived *metrics.HistogramVec
+	messagesSent     *metrics.HistogramVec
+	activeStreams    *metrics.GaugeVec
+	uploadedBytes    *metrics.CounterVec
+}
+
+// NewMetricsInterceptor returns a new metrics interceptor whose metrics are registered in the registry
+func NewMetricsInterceptor(registry *metrics.Registry) *MetricsInterceptor {
+	interceptor := &MetricsInterceptor{
+		requests: registry.NewCounterVec(
+			"pcbook_grpc_requests_total",
+			"Number of RPCs completed, by method and status code.",
+			"method", "code",
+		),
+		duration: registry.NewHistogramVec(
+			"pcbook_grpc_request_duration_seconds",
+			"Latency of the RPCs, until the stream is closed for streaming RPCs.",
+			metrics.DefaultBuckets,
+			"method",
+		),
+		messagesReceived: registry.NewHistogramVec(
+			"pcbook_grpc_stream_messages_received",
+			"Number of messages received per stream of the streaming RPCs.",
+			streamMessageBuckets,
+			"method",
+		),
+		messagesSent: registry.NewHistogramVec(
+			"pcbook_grpc_stream_messages_sent",
+			"Number of messages sent per stream of the streaming RPCs.",
+			streamMessageBuckets,
+			"method",
+		),
+		activeStreams: registry.NewGaugeVec(
+			"pcbook_grpc_active_streams",
+			"Number of streams in progress.",
+			"method",
+		),
+		uploadedBytes: registry.NewCounterVec(
+			"pcbook_image_upload_bytes_total",
+			"Number of image bytes received by UploadImage.",
+		),
+	}
+	// 没有标签的计数器一开始就输出0，而不是等到第一次上传
+	interceptor.uploadedBytes.Add(0)
+	return interceptor
+}
+
+// Unary returns a server interceptor function to record the metrics of unary RPC
+func (interceptor *MetricsInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		res, err := handler(ctx, req)
+
+		interceptor.record(info.FullMethod, start, err)
+		return res, err
+	}
+}
+
+// Stream returns a server interceptor function to record the metrics of stream RPC
+func (interceptor *MetricsInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		interceptor.activeStreams.Add(1, info.FullMethod)
+		defer interceptor.activeStreams.Add(-1, info.FullMethod)
+
+		metricsStream := &metricsServerStream{ServerStream: stream, uploadedBytes: interceptor.uploadedBytes}
+		err := handler(srv, metricsStream)
+
+		interceptor.messagesReceived.Observe(float64(atomic.LoadInt64(&metricsStream.received)), info.FullMethod)
+		interceptor.messagesSent.Observe(float64(atomic.LoadInt64(&metricsStream.sent)), info.FullMethod)
+		interceptor.record(info.FullMethod, start, err)
+		return err
+	}
+}
+
+func (interceptor *MetricsInterceptor) record(method string, start time.Time, err error) {
+	interceptor.requests.Inc(method, status.Code(err).String())
+	interceptor.duration.Observe(time.Since(start).Seconds(), method)
+}
+
+// metricsServerStream counts the messages of a server stream and the image bytes uploaded through it
+type metricsServerStream struct {
+	grpc.ServerStream
+	uploadedBytes *metrics.CounterVec
+	received      int64
+	sent          int64
+}
+
+func (stream *metricsServerStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&stream.received, 1)
+	if req, ok := m.(*pb.UploadImageRequest); ok {
+		stream.uploadedBytes.Add(float64(len(req.GetChunkData())))
+	}
+	return nil
+}
+
+func (stream *metricsServerStream) SendMsg(m interface{}) error {
+	err := stream.ServerStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&stream.sent, 1)
+	}
+	return err
+}
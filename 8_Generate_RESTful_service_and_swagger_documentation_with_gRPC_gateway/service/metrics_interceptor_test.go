@@ -0,0 +1,109 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"pcbook/metrics"
+	"pcbook/pb"
+	"pcbook/sample"
+	"testing"
+	"time"
+)
+
+func TestMetricsInterceptor(t *testing.T) {
+	t.Parallel()
+
+	imageFolder, err := ioutil.TempDir("", "pcbook-images")
+	require.NoError(t, err)
+	defer os.RemoveAll(imageFolder)
+
+	laptopStore := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(DefaultTenantID, laptop))
+	laptopServer := NewLaptopServer(laptopStore, NewDiskImageStore(imageFolder), NewInMemoryRatingStore())
+
+	registry := metrics.NewRegistry()
+	interceptor := NewMetricsInterceptor(registry)
+	serverAddress := startTestMetricsServer(t, laptopServer, interceptor)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	const (
+		createLaptop = "/pcbook.pbfiles.LaptopService/CreateLaptop"
+		uploadImage  = "/pcbook.pbfiles.LaptopService/UploadImage"
+		rateLaptop   = "/pcbook.pbfiles.LaptopService/RateLaptop"
+	)
+
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: &pb.Laptop{Id: "invalid"}})
+	require.Error(t, err)
+
+	// 上传三块共6字节的图片
+	upload, err := laptopClient.UploadImage(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, upload.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpg"}},
+	}))
+	for _, chunk := range []string{"ab", "cd", "ef"} {
+		require.NoError(t, upload.Send(&pb.UploadImageRequest{Data: &pb.UploadImageRequest_ChunkData{ChunkData: []byte(chunk)}}))
+	}
+	_, err = upload.CloseAndRecv()
+	require.NoError(t, err)
+
+	// 评价流打开期间是活跃的
+	rate, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	for _, score := range []float64{8, 9} {
+		require.NoError(t, rate.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: score}))
+		_, err = rate.Recv()
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1.0, interceptor.activeStreams.Value(rateLaptop))
+	require.NoError(t, rate.CloseSend())
+	_, err = rate.Recv()
+	require.Equal(t, io.EOF, err)
+
+	// 服务端在返回状态后才记录指标
+	require.Eventually(t, func() bool {
+		return interceptor.requests.Value(rateLaptop, codes.OK.String()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	require.Equal(t, 1.0, interceptor.requests.Value(createLaptop, codes.OK.String()))
+	require.Equal(t, 1.0, interceptor.requests.Value(createLaptop, codes.InvalidArgument.String()))
+	require.EqualValues(t, 2, interceptor.duration.Count(createLaptop))
+	require.Equal(t, 1.0, interceptor.requests.Value(uploadImage, codes.OK.String()))
+	require.Equal(t, 6.0, interceptor.uploadedBytes.Value())
+	require.Equal(t, 4.0, interceptor.messagesReceived.Sum(uploadImage))
+	require.Equal(t, 1.0, interceptor.messagesSent.Sum(uploadImage))
+	require.Equal(t, 2.0, interceptor.messagesReceived.Sum(rateLaptop))
+	require.Equal(t, 2.0, interceptor.messagesSent.Sum(rateLaptop))
+	require.Equal(t, 0.0, interceptor.activeStreams.Value(rateLaptop))
+
+	var buffer bytes.Buffer
+	require.NoError(t, registry.WriteText(&buffer))
+	require.Contains(t, buffer.String(), `pcbook_grpc_requests_total{method="/pcbook.pbfiles.LaptopService/CreateLaptop",code="InvalidArgument"} 1`)
+	require.Contains(t, buffer.String(), "pcbook_image_upload_bytes_total 6\n")
+}
+
+func startTestMetricsServer(t *testing.T, laptopServer *LaptopServer, interceptor *MetricsInterceptor) string {
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor.Unary()),
+		grpc.StreamInterceptor(interceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	return listener.Addr().String()
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// PasswordHasher hashes passwords with an algorithm and its parameters, both are encoded in the hash
+type PasswordHasher interface {
+	// Hash hashes the password with a random salt
+	Hash(password string) (string, error)
+	// NeedsRehash checks if the hash was made by another algorithm or with other parameters
+	NeedsRehash(hashedPassword string) bool
+}
+
+// DefaultPasswordHasher is used by NewUser
+var DefaultPasswordHasher PasswordHasher = NewBcryptHasher(bcrypt.DefaultCost)
+
+// VerifyPassword checks the password against a hash made by any supported hasher
+func VerifyPassword(hashedPassword string, password string) bool {
+	// 哈希值本身记录了算法与参数，验证时不需要知道当前的配置
+	if strings.HasPrefix(hashedPassword, argon2idPrefix) {
+		params, salt, key, err := decodeArgon2id(hashedPassword)
+		if err != nil {
+			return false
+		}
+		other := params.key(password, salt)
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	return err == nil
+}
+
+// BcryptHasher hashes passwords with bcrypt
+type BcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher returns a new bcrypt hasher with the given cost
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{cost: cost}
+}
+
+func (hasher *BcryptHasher) Hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), hasher.cost)
+	if err != nil {
+		return "", fmt.Errorf("cannot hash password: %w", err)
+	}
+	return string(hashedPassword), nil
+}
+
+func (hasher *BcryptHasher) NeedsRehash(hashedPassword string) bool {
+	// bcrypt的哈希值格式为 $2a$<cost>$<salt+hash>
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return err != nil || cost != hasher.cost
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Argon2idParams are the parameters of argon2id, see RFC 9106 for the recommended values
+type Argon2idParams struct {
+	Time       uint32 // 迭代次数
+	Memory     uint32 // KiB
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+func (params Argon2idParams) key(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+}
+
+// Argon2idHasher hashes passwords with argon2id, encoded in the PHC string format
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+type Argon2idHasher struct {
+	params Argon2idParams
+}
+
+// NewArgon2idHasher returns a new argon2id hasher with the given parameters
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	return &Argon2idHasher{params: params}
+}
+
+func (hasher *Argon2idHasher) Hash(password string) (string, error) {
+	salt, err := randomBytes(int(hasher.params.SaltLength))
+	if err != nil {
+		return "", fmt.Errorf("cannot hash password: %w", err)
+	}
+
+	key := hasher.params.key(password, salt)
+	hashedPassword := fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		hasher.params.Memory,
+		hasher.params.Time,
+		hasher.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+	return hashedPassword, nil
+}
+
+func (hasher *Argon2idHasher) NeedsRehash(hashedPassword string) bool {
+	if !strings.HasPrefix(hashedPassword, argon2idPrefix) {
+		return true
+	}
+
+	params, _, _, err := decodeArgon2id(hashedPassword)
+	return err != nil || params != hasher.params
+}
+
+func decodeArgon2id(hashedPassword string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, fmt.Errorf("malformed argon2id hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id key: %w", err)
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"pcbook/pb"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testArgon2idParams = Argon2idParams{Time: 1, Memory: 1024, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+func TestPasswordHasher(t *testing.T) {
+	t.Parallel()
+
+	argon2id := NewArgon2idHasher(testArgon2idParams)
+	stronger := testArgon2idParams
+	stronger.Time = 2
+
+	testCases := []struct {
+		name        string
+		hasher      PasswordHasher
+		rehashedBy  PasswordHasher
+		needsRehash bool
+	}{
+		{"bcrypt_same_cost", NewBcryptHasher(bcrypt.MinCost), NewBcryptHasher(bcrypt.MinCost), false},
+		{"bcrypt_higher_cost", NewBcryptHasher(bcrypt.MinCost), NewBcryptHasher(bcrypt.MinCost + 1), true},
+		{"bcrypt_to_argon2id", NewBcryptHasher(bcrypt.MinCost), argon2id, true},
+		{"argon2id_same_params", argon2id, argon2id, false},
+		{"argon2id_stronger_params", argon2id, NewArgon2idHasher(stronger), true},
+		{"argon2id_to_bcrypt", argon2id, NewBcryptHasher(bcrypt.MinCost), true},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			hashedPassword, err := tc.hasher.Hash("correct horse")
+			require.NoError(t, err)
+			require.True(t, VerifyPassword(hashedPassword, "correct horse"))
+			require.False(t, VerifyPassword(hashedPassword, "wrong horse"))
+			require.Equal(t, tc.needsRehash, tc.rehashedBy.NeedsRehash(hashedPassword))
+		})
+	}
+}
+
+func TestAuthServerRehashPassword(t *testing.T) {
+	t.Parallel()
+
+	userStore := NewInMemoryUserStore()
+	user, err := NewUserWithHasher("frank", "secret", "user", NewBcryptHasher(bcrypt.MinCost))
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(user))
+
+	hasher := NewArgon2idHasher(testArgon2idParams)
+	server := NewAuthServer(userStore, NewInMemorySessionStore(), NewInMemoryAPIKeyStore(), NewJWTManager("secret", time.Minute), newTestLoginLimiter(), nil, hasher)
+
+	login := &pb.LoginRequest{Username: "frank", Password: "secret"}
+	_, err = server.Login(context.Background(), login)
+	require.NoError(t, err)
+
+	// 登录成功后，旧的bcrypt哈希被替换为当前配置的argon2id哈希
+	stored, err := userStore.Find("frank")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(stored.HashedPassword, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	require.False(t, hasher.NeedsRehash(stored.HashedPassword))
+
+	_, err = server.Login(context.Background(), login)
+	require.NoError(t, err)
+}
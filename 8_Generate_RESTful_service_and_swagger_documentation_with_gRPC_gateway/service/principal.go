@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc"
+)
+
+// RoleAdmin is the role that can act on resources owned by any user
+const RoleAdmin = "admin"
+
+// Principal is the authenticated caller of an RPC
+type Principal struct {
+	Username string
+	Role     string
+	// Roles 包括Role本身及其继承的所有角色
+	Roles map[string]bool
+	// APIKeyID 是调用方使用的API密钥，使用访问令牌时为空
+	APIKeyID string
+	// Certificate 是已验证的客户端证书的身份，没有使用证书时为空
+	Certificate string
+	// TenantID 是调用者所属的租户，其对存储的操作都限定在该租户内
+	TenantID string
+}
+
+// HasRole checks if the principal has the role, either directly or by inheritance
+func (principal *Principal) HasRole(role string) bool {
+	return principal.Role == role || principal.Roles[role]
+}
+
+// IsAdmin checks if the principal can act on resources owned by any user
+func (principal *Principal) IsAdmin() bool {
+	return principal.HasRole(RoleAdmin)
+}
+
+// IsSuperAdmin checks if the principal can operate across tenants
+func (principal *Principal) IsSuperAdmin() bool {
+	return principal.HasRole(RoleSuperAdmin)
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a new context that carries the principal
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal of the context, if the caller has been authenticated
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// serverStreamWithContext overrides the context of a server stream
+type serverStreamWithContext struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *serverStreamWithContext) Context() context.Context {
+	return stream.ctx
+}
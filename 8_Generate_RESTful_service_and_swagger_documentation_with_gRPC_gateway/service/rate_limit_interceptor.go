@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"pcbook/logging"
+	"pcbook/pb"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamLimitRetryDelay is the delay suggested to a caller that has too many open streams,
+// there is no way to know when one of them will end
+const streamLimitRetryDelay = time.Second
+
+// RateLimitRule limits the callers of a method, or of all the methods if Method is "*".
+// The rules of "*" and of the called method both apply, each with its own bucket.
+// Among the rules of the same method, a rule of a role of the caller overrides the rule without role.
+type RateLimitRule struct {
+	Method     string
+	Role       string
+	Rate       float64 // 每秒的请求数，0表示不限制速率
+	Burst      int
+	MaxStreams int // 调用者同时打开的流的数量，0表示不限制
+}
+
+// RateLimitPolicy chooses the rate limits of the callers
+type RateLimitPolicy struct {
+	methodRules map[string][]*RateLimitRule
+}
+
+// NewRateLimitPolicy checks the rules and returns a new rate limit policy
+func NewRateLimitPolicy(rules []RateLimitRule) (*RateLimitPolicy, error) {
+	policy := &RateLimitPolicy{
+		methodRules: make(map[string][]*RateLimitRule),
+	}
+
+	for i := range rules {
+		rule := rules[i]
+		if rule.Method != "*" {
+			_, method, err := splitMethodName(rule.Method)
+			if err != nil {
+				return nil, err
+			}
+			if method == "*" {
+				return nil, fmt.Errorf("rate limit rule %q: use \"*\" or a single method", rule.Method)
+			}
+		}
+		if rule.Rate < 0 || rule.MaxStreams < 0 {
+			return nil, fmt.Errorf("rate limit rule %q: rate and max_streams must not be negative", rule.Method)
+		}
+		if rule.Rate > 0 && rule.Burst < 1 {
+			return nil, fmt.Errorf("rate limit rule %q: burst must be at least 1", rule.Method)
+		}
+		for _, other := range policy.methodRules[rule.Method] {
+			if other.Role == rule.Role {
+				return nil, fmt.Errorf("duplicate rate limit rule %q for role %q", rule.Method, rule.Role)
+			}
+		}
+
+		policy.methodRules[rule.Method] = append(policy.methodRules[rule.Method], &rule)
+	}
+	return policy, nil
+}
+
+// CheckMethods checks if the methods of the rules are served
+func (policy *RateLimitPolicy) CheckMethods(services map[string]grpc.ServiceInfo) error {
+	for fullMethod := range policy.methodRules {
+		if fullMethod == "*" {
+			continue
+		}
+
+		service, method, _ := splitMethodName(fullMethod)
+		info, ok := services[service]
+		if !ok || !hasMethod(info, method) {
+			return fmt.Errorf("rate limit rule %q refers to an unknown method", fullMethod)
+		}
+	}
+	return nil
+}
+
+// Rules returns the rules that apply to the caller of the method: the rule of "*" and the rule of the method, if any
+func (policy *RateLimitPolicy) Rules(fullMethod string, principal *Principal) []*RateLimitRule {
+	rules := []*RateLimitRule{}
+	for _, method := range []string{"*", fullMethod} {
+		if rule := policy.roleRule(method, principal); rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// roleRule returns the first rule of a role of the principal, or the rule without role
+func (policy *RateLimitPolicy) roleRule(method string, principal *Principal) *RateLimitRule {
+	var defaultRule *RateLimitRule
+	for _, rule := range policy.methodRules[method] {
+		if rule.Role == "" {
+			defaultRule = rule
+		} else if principal != nil && principal.HasRole(rule.Role) {
+			return rule
+		}
+	}
+	return defaultRule
+}
+
+// RateLimitInterceptor is a server interceptor that limits the rate of the RPCs and the open streams of each caller.
+// Authenticated callers are limited per user or API key, the others per client address.
+// It runs after the auth interceptor to know the caller.
+type RateLimitInterceptor struct {
+	limiter        RateLimiter
+	trustedProxies TrustedProxies
+
+	mutex  sync.RWMutex
+	policy *RateLimitPolicy
+}
+
+// NewRateLimitInterceptor returns a new rate limit interceptor
+func NewRateLimitInterceptor(limiter RateLimiter, policy *RateLimitPolicy, trustedProxies TrustedProxies) *RateLimitInterceptor {
+	return &RateLimitInterceptor{
+		limiter:        limiter,
+		trustedProxies: trustedProxies,
+		policy:         policy,
+	}
+}
+
+// Policy returns the current rate limit policy
+func (interceptor *RateLimitInterceptor) Policy() *RateLimitPolicy {
+	interceptor.mutex.RLock()
+	defer interceptor.mutex.RUnlock()
+
+	return interceptor.policy
+}
+
+// SetPolicy replaces the rate limit policy, it takes effect from the next RPC
+func (interceptor *RateLimitInterceptor) SetPolicy(policy *RateLimitPolicy) {
+	interceptor.mutex.Lock()
+	defer interceptor.mutex.Unlock()
+
+	interceptor.policy = policy
+}
+
+// Unary returns a server interceptor function to limit unary RPC
+func (interceptor *RateLimitInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(ctx, req)
+		}
+
+		caller, rules := interceptor.rules(ctx, info.FullMethod)
+		err := interceptor.allow(ctx, info.FullMethod, caller, rules)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns a server interceptor function to limit stream RPC
+func (interceptor *RateLimitInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx := stream.Context()
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(srv, stream)
+		}
+
+		caller, rules := interceptor.rules(ctx, info.FullMethod)
+		err := interceptor.allow(ctx, info.FullMethod, caller, rules)
+		if err != nil {
+			return err
+		}
+
+		slots := make([]StreamSlot, 0, len(rules))
+		for _, rule := range rules {
+			if rule.MaxStreams > 0 {
+				slots = append(slots, StreamSlot{Key: rule.Method + "|" + caller, MaxStreams: rule.MaxStreams})
+			}
+		}
+		release, ok := interceptor.limiter.AcquireStream(slots...)
+		if !ok {
+			logging.FromContext(ctx).Warn("too many open streams", "caller", caller)
+			return throttled(pb.ErrorReason_TOO_MANY_STREAMS, streamLimitRetryDelay, fmt.Sprintf("too many open streams for %s", methodName(info.FullMethod)))
+		}
+		defer release()
+
+		return handler(srv, stream)
+	}
+}
+
+// rules returns the key of the caller and the rules that apply to it
+func (interceptor *RateLimitInterceptor) rules(ctx context.Context, method string) (string, []*RateLimitRule) {
+	principal, _ := PrincipalFromContext(ctx)
+	caller := ""
+	switch {
+	case principal != nil && principal.APIKeyID != "":
+		caller = apiKeyUsernamePrefix + principal.APIKeyID
+	case principal != nil:
+		caller = UserKey(principal.Username)
+	default:
+		caller = AddressKey(ClientAddress(ctx, interceptor.trustedProxies))
+	}
+	return caller, interceptor.Policy().Rules(method, principal)
+}
+
+// allow takes a token from the buckets of the caller, the rule of "*" has one bucket for all the methods
+func (interceptor *RateLimitInterceptor) allow(ctx context.Context, method string, caller string, rules []*RateLimitRule) error {
+	buckets := make([]RateLimitBucket, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Rate > 0 {
+			buckets = append(buckets, RateLimitBucket{
+				Key:   rule.Method + "|" + caller,
+				Limit: RateLimit{Rate: rule.Rate, Burst: rule.Burst},
+			})
+		}
+	}
+
+	ok, retryAfter := interceptor.limiter.Allow(buckets...)
+	if ok {
+		return nil
+	}
+
+	logging.FromContext(ctx).Warn("rate limit exceeded", "caller", caller, "retry_after", retryAfter)
+	return throttled(
+		pb.ErrorReason_RATE_LIMIT_EXCEEDED,
+		retryAfter,
+		fmt.Sprintf("rate limit exceeded for %s, retry after %v", methodName(method), retryAfter.Round(time.Millisecond)),
+	)
+}
+
+// methodName returns the name of the method without the service
+func methodName(fullMethod string) string {
+	return fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimitPolicy(t *testing.T) {
+	t.Parallel()
+
+	const createLaptop = "/pcbook.pbfiles.LaptopService/CreateLaptop"
+	policy, err := NewRateLimitPolicy([]RateLimitRule{
+		{Method: "*", Rate: 10, Burst: 20},
+		{Method: "*", Role: RoleAdmin, Rate: 100, Burst: 200},
+		{Method: createLaptop, Rate: 1, Burst: 5},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		method    string
+		principal *Principal
+		rates     []float64
+	}{
+		{name: "anonymous", method: createLaptop, rates: []float64{10, 1}},
+		{name: "user", method: createLaptop, principal: &Principal{Username: "pd", Role: "user"}, rates: []float64{10, 1}},
+		{name: "admin", method: createLaptop, principal: &Principal{Username: "admin", Role: RoleAdmin}, rates: []float64{100, 1}},
+		{name: "inherited_role", method: "/pcbook.pbfiles.LaptopService/SearchLaptop", principal: &Principal{Role: RoleSuperAdmin, Roles: map[string]bool{RoleAdmin: true}}, rates: []float64{100}},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rates := []float64{}
+			for _, rule := range policy.Rules(tc.method, tc.principal) {
+				rates = append(rates, rule.Rate)
+			}
+			require.Equal(t, tc.rates, rates)
+		})
+	}
+
+	_, err = NewRateLimitPolicy([]RateLimitRule{{Method: "/pcbook.pbfiles.LaptopService/*", Rate: 1, Burst: 1}})
+	require.Error(t, err)
+	_, err = NewRateLimitPolicy([]RateLimitRule{{Method: "*", Rate: 1}})
+	require.Error(t, err)
+
+	err = policy.CheckMethods(map[string]grpc.ServiceInfo{
+		"pcbook.pbfiles.LaptopService": {Methods: []grpc.MethodInfo{{Name: "CreateLaptop"}}},
+	})
+	require.NoError(t, err)
+	err = policy.CheckMethods(map[string]grpc.ServiceInfo{})
+	require.Error(t, err)
+}
+
+func TestRateLimitInterceptorUnary(t *testing.T) {
+	t.Parallel()
+
+	policy, err := NewRateLimitPolicy([]RateLimitRule{{Method: "*", Rate: 1, Burst: 2}})
+	require.NoError(t, err)
+	interceptor := NewRateLimitInterceptor(NewInMemoryRateLimiter(), policy, nil)
+	info := &grpc.UnaryServerInfo{FullMethod: "/pcbook.pbfiles.LaptopService/CreateLaptop"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	pd := ContextWithPrincipal(context.Background(), &Principal{Username: "pd", Role: "user"})
+	for i := 0; i < 2; i++ {
+		_, err = interceptor.Unary()(pd, nil, info, handler)
+		require.NoError(t, err)
+	}
+	_, err = interceptor.Unary()(pd, nil, info, handler)
+	delay := requireRetryDelay(t, err)
+	require.True(t, delay > 0 && delay <= time.Second, delay)
+
+	// 用户、API密钥和匿名调用者分别限流
+	apiKey := ContextWithPrincipal(context.Background(), &Principal{Username: "pd", Role: "user", APIKeyID: "key-1"})
+	_, err = interceptor.Unary()(apiKey, nil, info, handler)
+	require.NoError(t, err)
+	anonymous := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.7"), Port: 50000}})
+	_, err = interceptor.Unary()(anonymous, nil, info, handler)
+	require.NoError(t, err)
+
+	// 重新加载的策略立即生效
+	unlimited, err := NewRateLimitPolicy(nil)
+	require.NoError(t, err)
+	interceptor.SetPolicy(unlimited)
+	_, err = interceptor.Unary()(pd, nil, info, handler)
+	require.NoError(t, err)
+}
+
+func TestRateLimitInterceptorStream(t *testing.T) {
+	t.Parallel()
+
+	policy, err := NewRateLimitPolicy([]RateLimitRule{{Method: "/pcbook.pbfiles.LaptopService/SearchLaptop", MaxStreams: 1}})
+	require.NoError(t, err)
+	interceptor := NewRateLimitInterceptor(NewInMemoryRateLimiter(), policy, nil)
+	info := &grpc.StreamServerInfo{FullMethod: "/pcbook.pbfiles.LaptopService/SearchLaptop", IsServerStream: true}
+	stream := &fakeServerStream{ctx: ContextWithPrincipal(context.Background(), &Principal{Username: "pd", Role: "user"})}
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- interceptor.Stream()(nil, stream, info, func(srv interface{}, stream grpc.ServerStream) error {
+			close(started)
+			<-finish
+			return nil
+		})
+	}()
+	<-started
+
+	noop := func(srv interface{}, stream grpc.ServerStream) error { return nil }
+	err = interceptor.Stream()(nil, stream, info, noop)
+	require.Equal(t, streamLimitRetryDelay, requireRetryDelay(t, err))
+	require.Contains(t, status.Convert(err).Message(), "SearchLaptop")
+
+	// 流结束后释放名额
+	close(finish)
+	require.NoError(t, <-done)
+	err = interceptor.Stream()(nil, stream, info, noop)
+	require.NoError(t, err)
+}
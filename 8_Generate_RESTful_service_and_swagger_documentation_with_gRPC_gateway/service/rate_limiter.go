@@ -0,0 +1,155 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket: it refills Rate tokens per second up to Burst, and each request takes one token
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitBucket is the bucket of a caller for a group of methods
+type RateLimitBucket struct {
+	Key   string
+	Limit RateLimit
+}
+
+// StreamSlot is the count of the open streams of a caller for a group of methods
+type StreamSlot struct {
+	Key        string
+	MaxStreams int
+}
+
+// RateLimiter keeps the token buckets and the stream counts of the callers.
+// InMemoryRateLimiter keeps them in the process, a shared backend would enforce the limits across several servers.
+type RateLimiter interface {
+	// Allow takes a token from each bucket, if one of them is empty it takes none and returns how long to wait
+	Allow(buckets ...RateLimitBucket) (bool, time.Duration)
+	// AcquireStream counts a new stream in each slot, if one of them is full it counts none and returns false.
+	// The release function must be called once when the stream ends.
+	AcquireStream(slots ...StreamSlot) (func(), bool)
+}
+
+// bucketCleanupInterval is how often the buckets that have refilled are removed
+const bucketCleanupInterval = time.Minute
+
+// InMemoryRateLimiter keeps the token buckets and the stream counts in memory
+type InMemoryRateLimiter struct {
+	mutex       sync.Mutex
+	buckets     map[string]*tokenBucket
+	streams     map[string]int
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	fullAt  time.Time // 此后桶已满，可以删除
+}
+
+// NewInMemoryRateLimiter returns a new in-memory rate limiter
+func NewInMemoryRateLimiter() *InMemoryRateLimiter {
+	return &InMemoryRateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		streams: make(map[string]int),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from each bucket, if one of them is empty it takes none and returns how long to wait
+func (limiter *InMemoryRateLimiter) Allow(buckets ...RateLimitBucket) (bool, time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	limiter.removeFull(now)
+
+	var retryAfter time.Duration
+	refilled := make([]*tokenBucket, len(buckets))
+	for i, bucket := range buckets {
+		if bucket.Limit.Rate <= 0 {
+			continue
+		}
+
+		state := limiter.buckets[bucket.Key]
+		if state == nil {
+			state = &tokenBucket{tokens: float64(bucket.Limit.Burst), updated: now}
+			limiter.buckets[bucket.Key] = state
+		}
+		// 限制可能在重新加载配置时被降低，令牌数不超过新的突发量
+		elapsed := now.Sub(state.updated).Seconds()
+		state.tokens = math.Min(float64(bucket.Limit.Burst), state.tokens+elapsed*bucket.Limit.Rate)
+		state.updated = now
+		refilled[i] = state
+
+		if state.tokens < 1 {
+			wait := time.Duration((1 - state.tokens) / bucket.Limit.Rate * float64(time.Second))
+			if wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+
+	for i, state := range refilled {
+		if state != nil {
+			state.tokens--
+			limit := buckets[i].Limit
+			state.fullAt = now.Add(time.Duration((float64(limit.Burst) - state.tokens) / limit.Rate * float64(time.Second)))
+		}
+	}
+	return true, 0
+}
+
+// AcquireStream counts a new stream in each slot, if one of them is full it counts none and returns false
+func (limiter *InMemoryRateLimiter) AcquireStream(slots ...StreamSlot) (func(), bool) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	for _, slot := range slots {
+		if slot.MaxStreams > 0 && limiter.streams[slot.Key] >= slot.MaxStreams {
+			return nil, false
+		}
+	}
+	for _, slot := range slots {
+		limiter.streams[slot.Key]++
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			limiter.mutex.Lock()
+			defer limiter.mutex.Unlock()
+
+			for _, slot := range slots {
+				limiter.streams[slot.Key]--
+				if limiter.streams[slot.Key] <= 0 {
+					delete(limiter.streams, slot.Key)
+				}
+			}
+		})
+	}
+	return release, true
+}
+
+// removeFull forgets the buckets that have refilled, a new bucket starts full anyway.
+// The caller must hold the lock.
+func (limiter *InMemoryRateLimiter) removeFull(now time.Time) {
+	if now.Sub(limiter.lastCleanup) < bucketCleanupInterval {
+		return
+	}
+	limiter.lastCleanup = now
+
+	for key, state := range limiter.buckets {
+		if now.After(state.fullAt) {
+			delete(limiter.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestInMemoryRateLimiterAllow(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewInMemoryRateLimiter()
+	limiter.now = func() time.Time { return now }
+
+	global := RateLimitBucket{Key: "*|user:pd", Limit: RateLimit{Rate: 10, Burst: 5}}
+	create := RateLimitBucket{Key: "create|user:pd", Limit: RateLimit{Rate: 1, Burst: 2}}
+
+	// 突发量用完后，按速率补充令牌
+	for i := 0; i < 2; i++ {
+		ok, _ := limiter.Allow(global, create)
+		require.True(t, ok)
+	}
+	ok, retryAfter := limiter.Allow(global, create)
+	require.False(t, ok)
+	require.Equal(t, time.Second, retryAfter)
+
+	// 被拒绝的请求不消耗其他桶的令牌
+	for i := 0; i < 3; i++ {
+		ok, _ = limiter.Allow(global)
+		require.True(t, ok)
+	}
+	ok, retryAfter = limiter.Allow(global)
+	require.False(t, ok)
+	require.Equal(t, 100*time.Millisecond, retryAfter)
+
+	now = now.Add(time.Second)
+	ok, _ = limiter.Allow(global, create)
+	require.True(t, ok)
+
+	// 其他调用者有自己的桶
+	ok, _ = limiter.Allow(RateLimitBucket{Key: "create|user:admin", Limit: create.Limit})
+	require.True(t, ok)
+
+	// 已补满的桶会被删除
+	now = now.Add(time.Hour)
+	ok, _ = limiter.Allow(global)
+	require.True(t, ok)
+	require.Len(t, limiter.buckets, 1)
+}
+
+func TestInMemoryRateLimiterAcquireStream(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewInMemoryRateLimiter()
+	global := StreamSlot{Key: "*|user:pd", MaxStreams: 2}
+	search := StreamSlot{Key: "search|user:pd", MaxStreams: 1}
+
+	release1, ok := limiter.AcquireStream(global, search)
+	require.True(t, ok)
+	_, ok = limiter.AcquireStream(global, search)
+	require.False(t, ok)
+
+	release2, ok := limiter.AcquireStream(global)
+	require.True(t, ok)
+	_, ok = limiter.AcquireStream(global)
+	require.False(t, ok)
+
+	// 重复释放只生效一次
+	release1()
+	release1()
+	require.Equal(t, 1, limiter.streams[global.Key])
+
+	release3, ok := limiter.AcquireStream(global, search)
+	require.True(t, ok)
+	release2()
+	release3()
+	require.Empty(t, limiter.streams)
+}
@@ -6,8 +6,10 @@ import (
 
 // RatingStore is an interface to store laptop ratings
 type RatingStore interface {
-	// Add adds a new laptop score to the store and return its rating
-	Add(laptopID string, score float64) (*Rating, error)
+	// Add adds a new laptop score of the tenant to the store and return its rating
+	Add(tenantID string, laptopID string, score float64) (*Rating, error)
+	// Ready returns an error if the store cannot serve requests
+	Ready() error
 }
 
 // Rating contains the rating information of a laptop
@@ -19,7 +21,7 @@ type Rating struct {
 // InMemoryRatingStore stores laptop ratings in memory
 type InMemoryRatingStore struct {
 	mutex sync.RWMutex
-	rating map[string]*Rating
+	rating map[string]*Rating // 以租户和笔记本ID为键
 }
 
 // NewInMemoryRatingStore returns a new InMemoryRatingStore
@@ -29,11 +31,17 @@ func NewInMemoryRatingStore() *InMemoryRatingStore {
 	}
 }
 
-func (store *InMemoryRatingStore) Add(laptopID string, score float64) (*Rating, error) {
+// Ready returns nil, the memory is always available
+func (store *InMemoryRatingStore) Ready() error {
+	return nil
+}
+
+func (store *InMemoryRatingStore) Add(tenantID string, laptopID string, score float64) (*Rating, error) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	rating := store.rating[laptopID]
+	key := tenantID + "/" + laptopID
+	rating := store.rating[key]
 	if rating == nil {
 		rating = &Rating{
 			Count: 1,
@@ -44,6 +52,6 @@ func (store *InMemoryRatingStore) Add(laptopID string, score float64) (*Rating,
 		rating.Sum += score
 	}
 
-	store.rating[laptopID] = rating
+	store.rating[key] = rating
 	return rating, nil
 }
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"pcbook/logging"
+	"runtime/debug"
+)
+
+// RecoveryInterceptor is a server interceptor that turns the panics of the handlers and of the later stages
+// into Internal errors, instead of crashing the server. The panic and its stack trace are logged with the request ID.
+// The server runs it twice: just after the request ID stage, so that it wraps every other stage,
+// and just before the handlers, so that the logging, metrics and audit stages see the Internal error
+// of a panicking handler instead of being unwound by the panic.
+// The panics of the goroutines started by the handlers cannot be recovered.
+type RecoveryInterceptor struct{}
+
+// NewRecoveryInterceptor returns a new recovery interceptor
+func NewRecoveryInterceptor() *RecoveryInterceptor {
+	return &RecoveryInterceptor{}
+}
+
+// Unary returns a server interceptor function to recover unary RPC
+func (interceptor *RecoveryInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (res interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				res, err = nil, recovered(ctx, r)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns a server interceptor function to recover stream RPC
+func (interceptor *RecoveryInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(stream.Context(), r)
+			}
+		}()
+
+		return handler(srv, stream)
+	}
+}
+
+// recovered logs the panic and returns the error sent to the caller, without the details of the panic
+func recovered(ctx context.Context, r interface{}) error {
+	logging.FromContext(ctx).Error("recovered from panic", "code", codes.Internal, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+	return status.Errorf(codes.Internal, "internal error")
+}
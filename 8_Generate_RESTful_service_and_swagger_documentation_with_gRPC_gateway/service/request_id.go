@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"pcbook/logging"
+	"strings"
+)
+
+// RequestIDHeader is the metadata key and the HTTP header of the request IDs
+const RequestIDHeader = "x-request-id"
+
+// maxRequestIDLength limits the request IDs sent by the callers, they are written in every log line
+const maxRequestIDLength = 128
+
+// NewRequestID returns a new random request ID
+func NewRequestID() string {
+	return uuid.New().String()
+}
+
+// validRequestID checks if a request ID sent by a caller can be logged as is
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a new context that carries the request ID
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID of the context, or an empty string
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDInterceptor is a server interceptor that accepts the request ID of the caller or generates one,
+// sends it back in the response header, and puts a logger with the request ID in the context of the RPC.
+// It should run first, so that the lines logged by the other interceptors have the request ID.
+type RequestIDInterceptor struct {
+	logger *logging.Logger
+}
+
+// NewRequestIDInterceptor returns a new request ID interceptor, the loggers of the RPCs derive from the logger
+func NewRequestIDInterceptor(logger *logging.Logger) *RequestIDInterceptor {
+	return &RequestIDInterceptor{
+		logger: logger,
+	}
+}
+
+// Unary returns a server interceptor function to identify unary RPC
+func (interceptor *RequestIDInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx = interceptor.start(ctx, info.FullMethod)
+		err := grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, RequestIDFromContext(ctx)))
+		if err != nil {
+			logging.FromContext(ctx).Warn("cannot send request ID", "error", err)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns a server interceptor function to identify stream RPC
+func (interceptor *RequestIDInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx := interceptor.start(stream.Context(), info.FullMethod)
+		err := stream.SetHeader(metadata.Pairs(RequestIDHeader, RequestIDFromContext(ctx)))
+		if err != nil {
+			logging.FromContext(ctx).Warn("cannot send request ID", "error", err)
+		}
+
+		return handler(srv, &serverStreamWithContext{ServerStream: stream, ctx: ctx})
+	}
+}
+
+func (interceptor *RequestIDInterceptor) start(ctx context.Context, method string) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := ""
+	if values := md.Get(RequestIDHeader); len(values) > 0 && validRequestID(values[0]) {
+		id = values[0]
+	} else {
+		id = NewRequestID()
+	}
+
+	logger := interceptor.logger.With("request_id", id, "method", method)
+	return logging.NewContext(ContextWithRequestID(ctx, id), logger)
+}
+
+// NewRequestIDHandler returns an HTTP handler that accepts the X-Request-Id header of the caller or generates one,
+// and sends it back in the response. The REST gateway forwards the header to the gRPC server,
+// so the lines of the gateway and of the gRPC server have the same request ID.
+func NewRequestIDHandler(logger *logging.Logger, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		requestLogger := logger.With("request_id", id, "http_method", r.Method, "path", r.URL.Path)
+		ctx := logging.NewContext(ContextWithRequestID(r.Context(), id), requestLogger)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"pcbook/logging"
+	"pcbook/pb"
+	"pcbook/sample"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRequestIDInterceptor(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		requestID string
+		keep      bool
+	}{
+		{name: "caller_id", requestID: "req-1234.abc", keep: true},
+		{name: "generated_id"},
+		{name: "invalid_id", requestID: "bad id\"with=quotes"},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			output := &syncBuffer{}
+			logger := logging.New(output, logging.FormatLogfmt, logging.LevelInfo)
+			laptopServer := NewLaptopServer(NewInMemoryLaptopStore(), NewDiskImageStore("img"), NewInMemoryRatingStore())
+			laptopClient := startTestRequestIDServer(t, laptopServer, logger)
+
+			ctx := context.Background()
+			if tc.requestID != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, RequestIDHeader, tc.requestID)
+			}
+			var header metadata.MD
+			_, err := laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()}, grpc.Header(&header))
+			require.NoError(t, err)
+
+			values := header.Get(RequestIDHeader)
+			require.Len(t, values, 1)
+			id := values[0]
+			if tc.keep {
+				require.Equal(t, tc.requestID, id)
+			} else {
+				require.NotEqual(t, tc.requestID, id)
+				require.True(t, validRequestID(id))
+			}
+
+			// 同一个RPC的每一行都有请求ID
+			lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+			require.NotEmpty(t, lines)
+			for _, line := range lines {
+				require.Contains(t, line, "request_id="+id)
+			}
+			require.Contains(t, lines[len(lines)-1], `msg="finished RPC"`)
+			require.Contains(t, lines[len(lines)-1], "code=OK")
+		})
+	}
+}
+
+func TestRequestIDHandler(t *testing.T) {
+	t.Parallel()
+
+	output := &syncBuffer{}
+	logger := logging.New(output, logging.FormatJSON, logging.LevelInfo)
+	handler := NewRequestIDHandler(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 网关把请求头转发给gRPC服务
+		require.Equal(t, RequestIDFromContext(r.Context()), r.Header.Get(RequestIDHeader))
+		logging.FromContext(r.Context()).Info("handled")
+	}))
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/v1/laptop/search", nil)
+	req.Header.Set(RequestIDHeader, "req-5678")
+	handler.ServeHTTP(recorder, req)
+	require.Equal(t, "req-5678", recorder.Header().Get(RequestIDHeader))
+	require.Contains(t, output.String(), `"request_id":"req-5678","http_method":"GET","path":"/v1/laptop/search"`)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/laptop/search", nil))
+	id := recorder.Header().Get(RequestIDHeader)
+	require.True(t, validRequestID(id))
+	require.NotEqual(t, "req-5678", id)
+}
+
+func TestAdminServerSetLogLevel(t *testing.T) {
+	t.Parallel()
+
+	logger := logging.New(ioutil.Discard, logging.FormatLogfmt, logging.LevelInfo)
+	requestLogger := logger.With("request_id", "abc")
+	server := NewAdminServer(nil, logger)
+
+	// 日志级别是所有租户共享的，访问策略只允许超级管理员修改
+	accessPolicy, err := LoadAccessPolicy("../policy/access_policy.json")
+	require.NoError(t, err)
+	const setLogLevel = "/pcbook.pbfiles.AdminService/SetLogLevel"
+	require.False(t, accessPolicy.Allows(RoleAdmin, setLogLevel))
+	require.True(t, accessPolicy.Allows(RoleSuperAdmin, setLogLevel))
+
+	superAdmin := &Principal{Username: "root", Role: RoleSuperAdmin}
+	ctx := ContextWithPrincipal(context.Background(), superAdmin)
+	res, err := server.SetLogLevel(ctx, &pb.SetLogLevelRequest{Level: "debug"})
+	require.NoError(t, err)
+	require.Equal(t, "info", res.GetPreviousLevel())
+	require.Equal(t, "debug", res.GetLevel())
+	require.Equal(t, logging.LevelDebug, requestLogger.Level())
+
+	_, err = server.SetLogLevel(ctx, &pb.SetLogLevelRequest{Level: "verbose"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Equal(t, logging.LevelDebug, logger.Level())
+}
+
+// startTestRequestIDServer starts a gRPC server with the request ID and logging interceptors, and returns a client of it
+func startTestRequestIDServer(t *testing.T, laptopServer *LaptopServer, logger *logging.Logger) pb.LaptopServiceClient {
+	chain := NewInterceptorChain().
+		Add(StageRequestID, NewRequestIDInterceptor(logger)).
+		Add(StageLogging, NewLoggingInterceptor())
+	grpcServer := grpc.NewServer(chain.ServerOptions()...)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewLaptopServiceClient(conn)
+}
+
+// syncBuffer is a buffer that the server goroutines can write while the test reads it
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (buffer *syncBuffer) Write(data []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.Write(data)
+}
+
+func (buffer *syncBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.String()
+}
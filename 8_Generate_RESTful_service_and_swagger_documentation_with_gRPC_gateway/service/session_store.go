@@ -0,0 +1,156 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionStore is an interface to store issued access tokens and their revocation state
+type SessionStore interface {
+	// Add records a newly issued session
+	Add(session *Session) error
+	// Revoke revokes the token with the given ID until it expires
+	Revoke(tokenID string, expiresAt time.Time) error
+	// RevokeUser revokes all active sessions of a user and returns how many were revoked
+	RevokeUser(username string) (int, error)
+	// IsRevoked checks if the token with the given ID has been revoked
+	IsRevoked(tokenID string) (bool, error)
+	// FindByUser finds all active sessions of a user
+	FindByUser(username string) ([]*Session, error)
+}
+
+// Session contains the information of an issued access token
+type Session struct {
+	ID        string // 令牌ID（jti）
+	Username  string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Revoked   bool
+}
+
+// NewSession returns the session of the given token claims
+func NewSession(claims *UserClaims) *Session {
+	return &Session{
+		ID:        claims.Id,
+		Username:  claims.Username,
+		IssuedAt:  time.Unix(claims.IssuedAt, 0),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+}
+
+// IsExpired checks if the token of this session has expired
+func (session *Session) IsExpired(now time.Time) bool {
+	return !now.Before(session.ExpiresAt)
+}
+
+// Clone returns a clone of this session
+func (session *Session) Clone() *Session {
+	other := *session
+	return &other
+}
+
+// InMemorySessionStore stores sessions in memory
+type InMemorySessionStore struct {
+	mutex    sync.RWMutex
+	sessions map[string]*Session
+	now      func() time.Time
+}
+
+// NewInMemorySessionStore returns a new in-memory session store
+func NewInMemorySessionStore() *InMemorySessionStore {
+	return &InMemorySessionStore{
+		sessions: make(map[string]*Session),
+		now:      time.Now,
+	}
+}
+
+func (store *InMemorySessionStore) Add(session *Session) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.removeExpired()
+
+	if store.sessions[session.ID] != nil {
+		return ErrAlreadyExists
+	}
+
+	store.sessions[session.ID] = session.Clone()
+	return nil
+}
+
+func (store *InMemorySessionStore) Revoke(tokenID string, expiresAt time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.removeExpired()
+
+	session := store.sessions[tokenID]
+	if session == nil {
+		// 令牌可能由其他实例签发，或在服务重启前签发，仍然需要记录下来
+		session = &Session{ID: tokenID, ExpiresAt: expiresAt}
+		if session.IsExpired(store.now()) {
+			return nil
+		}
+		store.sessions[tokenID] = session
+	}
+
+	session.Revoked = true
+	return nil
+}
+
+func (store *InMemorySessionStore) RevokeUser(username string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.removeExpired()
+
+	count := 0
+	for _, session := range store.sessions {
+		if session.Username == username && !session.Revoked {
+			session.Revoked = true
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (store *InMemorySessionStore) IsRevoked(tokenID string) (bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	session := store.sessions[tokenID]
+	if session == nil || session.IsExpired(store.now()) {
+		return false, nil
+	}
+	return session.Revoked, nil
+}
+
+func (store *InMemorySessionStore) FindByUser(username string) ([]*Session, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	now := store.now()
+	sessions := []*Session{}
+	for _, session := range store.sessions {
+		if session.Username == username && !session.Revoked && !session.IsExpired(now) {
+			sessions = append(sessions, session.Clone())
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].IssuedAt.Before(sessions[j].IssuedAt)
+	})
+	return sessions, nil
+}
+
+// removeExpired removes the sessions whose token has expired, the caller must hold the write lock.
+// 过期的令牌无论如何都会被JWTManager拒绝，因此不需要再保存它们的吊销记录
+func (store *InMemorySessionStore) removeExpired() {
+	now := store.now()
+	for id, session := range store.sessions {
+		if session.IsExpired(now) {
+			delete(store.sessions, id)
+		}
+	}
+}
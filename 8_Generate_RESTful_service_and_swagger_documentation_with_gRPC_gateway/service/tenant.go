@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"pcbook/pb"
+	"regexp"
+)
+
+// DefaultTenantID is the tenant of the users, laptops and images that belong to no storefront,
+// a single-tenant deployment keeps everything in it
+const DefaultTenantID = ""
+
+// AllTenants scopes a store operation to every tenant, only super admins can use it
+const AllTenants = "*"
+
+// TenantHeader is the metadata key to choose a tenant, it is only honored for authenticated super admins
+const TenantHeader = "x-tenant-id"
+
+// RoleSuperAdmin is the role that can operate across tenants
+const RoleSuperAdmin = "super-admin"
+
+// 租户ID会用作图片的目录名，只允许安全的字符
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// CheckTenantID checks if the tenant ID is valid, the empty ID of the default tenant is valid
+func CheckTenantID(tenantID string) error {
+	if tenantID == DefaultTenantID || tenantIDPattern.MatchString(tenantID) {
+		return nil
+	}
+	return fmt.Errorf("invalid tenant ID %q", tenantID)
+}
+
+// TenantFromContext returns the tenant that the store operations of the caller are scoped to:
+// the default tenant for anonymous callers, the tenant of an authenticated caller,
+// or for super admins the chosen tenant or all tenants
+func TenantFromContext(ctx context.Context) (string, error) {
+	principal, authenticated := PrincipalFromContext(ctx)
+	if !authenticated {
+		// 匿名调用者不能通过请求头读取其他租户的数据
+		return DefaultTenantID, nil
+	}
+	if !principal.IsSuperAdmin() {
+		return principal.TenantID, nil
+	}
+
+	tenantID, chosen := tenantFromHeader(ctx)
+	if !chosen {
+		return AllTenants, nil
+	}
+
+	err := CheckTenantID(tenantID)
+	if err != nil {
+		return "", errorWithReason(codes.InvalidArgument, pb.ErrorReason_INVALID_TENANT, map[string]string{"header": TenantHeader}, err.Error())
+	}
+	return tenantID, nil
+}
+
+func tenantFromHeader(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	values := md[TenantHeader]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// inTenant checks if a record of the tenant is visible in the scope
+func inTenant(scope string, tenantID string) bool {
+	return scope == AllTenants || scope == tenantID
+}
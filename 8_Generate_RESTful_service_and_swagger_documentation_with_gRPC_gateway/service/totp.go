@@ -0,0 +1,138 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters, the defaults of RFC 6238 that all authenticator apps support
+const (
+	totpIssuer     = "pcbook"
+	totpSecretSize = 20 // 160位，RFC 4226推荐的HMAC-SHA1密钥长度
+	totpDigits     = 6
+	totpPeriod     = 30 * time.Second
+	totpSkew       = 1 // 允许前后各一个时间步长的时钟偏差
+)
+
+// 恢复码在丢失验证器时代替一次性验证码登录，每个只能使用一次
+const (
+	recoveryCodeCount = 10
+	recoveryCodeSize  = 10 // 字节，编码后为16个字符
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret generates a new base32 encoded TOTP secret
+func GenerateTOTPSecret() (string, error) {
+	secret, err := randomBytes(totpSecretSize)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth URI of the secret, which authenticator apps import from a QR code
+func TOTPURI(username string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + totpIssuer + ":" + username,
+		RawQuery: query.Encode(),
+	}
+	return uri.String()
+}
+
+// TOTPCode returns the code of the secret at the given time
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	return hotp(key, totpStep(t)), nil
+}
+
+// VerifyTOTP checks the code against the secret around the given time, and returns the time step it matches.
+// Codes of the steps up to lastStep are rejected, so that a code cannot be used twice.
+func VerifyTOTP(secret string, code string, t time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := totpStep(t)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod.Seconds())
+}
+
+// hotp computes the HOTP value of RFC 4226 for the counter
+func hotp(key []byte, counter int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	// 动态截断
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo)
+}
+
+// GenerateRecoveryCodes generates new recovery codes, it returns the plain codes and their hashes
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashedCodes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		data, err := randomBytes(recoveryCodeSize)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(data))
+		code = code[:8] + "-" + code[8:]
+		codes = append(codes, code)
+		hashedCodes = append(hashedCodes, hashRecoveryCode(code))
+	}
+	return codes, hashedCodes, nil
+}
+
+// 恢复码有80位的随机性，并且登录尝试受到限制，所以使用SHA-256即可
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"encoding/base32"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	t.Parallel()
+
+	// RFC 6238 附录B的SHA1测试向量，取后6位
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	testCases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		code, err := TOTPCode(secret, time.Unix(tc.unix, 0))
+		require.NoError(t, err)
+		require.Equal(t, tc.code, code)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	t.Parallel()
+
+	secret, err := GenerateTOTPSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code, err := TOTPCode(secret, now.Add(-totpPeriod))
+	require.NoError(t, err)
+
+	// 允许一个时间步长的时钟偏差
+	step, ok := VerifyTOTP(secret, code, now, 0)
+	require.True(t, ok)
+
+	// 同一验证码不能再次使用
+	_, ok = VerifyTOTP(secret, code, now, step)
+	require.False(t, ok)
+
+	_, ok = VerifyTOTP(secret, code, now.Add(2*totpPeriod), 0)
+	require.False(t, ok)
+
+	uri, err := url.Parse(TOTPURI("admin", secret))
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", uri.Scheme)
+	require.Equal(t, "/pcbook:admin", uri.Path)
+	require.Equal(t, secret, uri.Query().Get("secret"))
+}
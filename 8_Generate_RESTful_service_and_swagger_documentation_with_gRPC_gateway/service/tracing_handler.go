@@ -0,0 +1,69 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"pcbook/logging"
+	"pcbook/tracing"
+)
+
+// NewTracingHandler returns an HTTP handler that starts a span for each request of the REST gateway,
+// as a child of the traceparent header if the caller sends one.
+// The gateway calls the gRPC server with the request context, so the RPC spans are its children.
+func NewTracingHandler(tracer *tracing.Tracer, handler http.Handler) http.Handler {
+	if tracer == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if traceparent := r.Header.Get(tracing.TraceparentHeader); traceparent != "" {
+			remote, err := tracing.ParseTraceparent(traceparent)
+			if err != nil {
+				logging.FromContext(ctx).Warn("ignore invalid traceparent", "error", err)
+			} else {
+				ctx = tracing.ContextWithRemoteSpanContext(ctx, remote)
+			}
+		}
+
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path, tracing.KindServer)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", recorder.status)
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("HTTP %d %s", recorder.status, http.StatusText(recorder.status)))
+		}
+	})
+}
+
+// statusRecorder remembers the status code written to the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if !recorder.wroteHeader {
+		recorder.status = status
+		recorder.wroteHeader = true
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	recorder.wroteHeader = true
+	return recorder.ResponseWriter.Write(data)
+}
+
+// Flush sends the buffered data, the gateway flushes after each message of the server streaming RPCs
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"pcbook/logging"
+	"pcbook/tracing"
+	"strings"
+)
+
+// healthMethodPrefix starts the methods of the gRPC health service, the probes are not traced
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// TracingInterceptor is a server interceptor that starts a span for each RPC,
+// as a child of the traceparent in the metadata if the caller sends one.
+// It should run first, so that the span includes the time spent in the other interceptors.
+type TracingInterceptor struct {
+	tracer *tracing.Tracer
+}
+
+// NewTracingInterceptor returns a new tracing interceptor, it traces nothing if the tracer is nil
+func NewTracingInterceptor(tracer *tracing.Tracer) *TracingInterceptor {
+	return &TracingInterceptor{
+		tracer: tracer,
+	}
+}
+
+// Unary returns a server interceptor function to trace unary RPC
+func (interceptor *TracingInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, span := interceptor.start(ctx, info.FullMethod)
+		defer span.End()
+
+		res, err := handler(ctx, req)
+		span.SetRPCStatus(err)
+		return res, err
+	}
+}
+
+// Stream returns a server interceptor function to trace stream RPC, the span lasts until the stream is closed
+func (interceptor *TracingInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, span := interceptor.start(stream.Context(), info.FullMethod)
+		defer span.End()
+
+		err := handler(srv, &serverStreamWithContext{ServerStream: stream, ctx: ctx})
+		span.SetRPCStatus(err)
+		return err
+	}
+}
+
+func (interceptor *TracingInterceptor) start(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	if interceptor.tracer == nil || strings.HasPrefix(method, healthMethodPrefix) {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(tracing.TraceparentHeader); len(values) > 0 {
+		remote, err := tracing.ParseTraceparent(values[0])
+		if err != nil {
+			// 无效的traceparent被忽略，开始新的追踪
+			logging.FromContext(ctx).Warn("ignore invalid traceparent", "error", err)
+		} else {
+			ctx = tracing.ContextWithRemoteSpanContext(ctx, remote)
+		}
+	}
+
+	ctx, span := interceptor.tracer.Start(ctx, method, tracing.KindServer)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.method", method)
+	return ctx, span
+}
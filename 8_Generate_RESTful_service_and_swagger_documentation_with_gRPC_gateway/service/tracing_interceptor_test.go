@@ -0,0 +1,159 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"pcbook/client"
+	"pcbook/pb"
+	"pcbook/sample"
+	"pcbook/serializer"
+	"pcbook/tracing"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTracingThroughGateway(t *testing.T) {
+	t.Parallel()
+
+	const remoteTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	testCases := []struct {
+		name        string
+		traceparent string
+	}{
+		{name: "traceparent", traceparent: remoteTraceparent},
+		{name: "new_trace"},
+		{name: "invalid_traceparent", traceparent: "00-invalid"},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			exporter := &testExporter{}
+			laptopStore := NewInMemoryLaptopStore()
+			laptopServer := NewLaptopServer(laptopStore, NewDiskImageStore("img"), NewInMemoryRatingStore())
+			serverAddress := startTestTracingServer(t, laptopServer, tracing.NewTracer("pcbook-grpc", exporter))
+			gatewayURL := startTestTracingGateway(t, serverAddress, tracing.NewTracer("pcbook-rest", exporter))
+
+			body, err := serializer.ProtobufToJSON(&pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+			require.NoError(t, err)
+			req, err := http.NewRequest(http.MethodPost, gatewayURL+"/v1/laptop/create", bytes.NewReader([]byte(body)))
+			require.NoError(t, err)
+			if tc.traceparent != "" {
+				req.Header.Set(tracing.TraceparentHeader, tc.traceparent)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			// HTTP请求的span在响应写完后才结束
+			require.Eventually(t, func() bool {
+				return len(exporter.Spans()) == 4
+			}, time.Second, 10*time.Millisecond)
+
+			spans := make(map[string]*tracing.SpanData)
+			for _, span := range exporter.Spans() {
+				spans[span.Name] = span
+			}
+			const method = "/pcbook.pbfiles.LaptopService/CreateLaptop"
+			httpSpan := spans["POST /v1/laptop/create"]
+			require.NotNil(t, httpSpan)
+			require.EqualValues(t, http.StatusOK, httpSpan.Attributes["http.status_code"])
+
+			// 网关的gRPC客户端span、服务端span和存储的span依次是上一个span的子span
+			clientSpan := spans[method+"#"+tracing.KindClient]
+			serverSpan := spans[method+"#"+tracing.KindServer]
+			storeSpan := spans["LaptopStore.Save"]
+			require.NotNil(t, clientSpan)
+			require.NotNil(t, serverSpan)
+			require.NotNil(t, storeSpan)
+			require.Equal(t, "pcbook-rest", clientSpan.Service)
+			require.Equal(t, httpSpan.SpanID, clientSpan.ParentSpanID)
+			require.Equal(t, "pcbook-grpc", serverSpan.Service)
+			require.Equal(t, clientSpan.SpanID, serverSpan.ParentSpanID)
+			require.Equal(t, "OK", serverSpan.Attributes["rpc.grpc.status_code"])
+			require.Equal(t, serverSpan.SpanID, storeSpan.ParentSpanID)
+
+			for _, span := range []*tracing.SpanData{clientSpan, serverSpan, storeSpan} {
+				require.Equal(t, httpSpan.TraceID, span.TraceID)
+			}
+			if tc.traceparent == remoteTraceparent {
+				require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", httpSpan.TraceID)
+				require.Equal(t, "00f067aa0ba902b7", httpSpan.ParentSpanID)
+			} else {
+				require.Empty(t, httpSpan.ParentSpanID)
+			}
+		})
+	}
+}
+
+// testExporter keeps the spans in memory, the client and server spans of an RPC have the same name,
+// so the kind is appended to the names of the RPC spans
+type testExporter struct {
+	mutex sync.Mutex
+	spans []*tracing.SpanData
+}
+
+func (exporter *testExporter) ExportSpan(span *tracing.SpanData) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	if span.Kind == tracing.KindClient || (span.Kind == tracing.KindServer && span.Attributes["rpc.method"] != nil) {
+		span.Name += "#" + span.Kind
+	}
+	exporter.spans = append(exporter.spans, span)
+}
+
+func (exporter *testExporter) Spans() []*tracing.SpanData {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	return append([]*tracing.SpanData(nil), exporter.spans...)
+}
+
+func startTestTracingServer(t *testing.T, laptopServer *LaptopServer, tracer *tracing.Tracer) string {
+	interceptor := NewTracingInterceptor(tracer)
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor.Unary()),
+		grpc.StreamInterceptor(interceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	return listener.Addr().String()
+}
+
+// startTestTracingGateway starts a REST gateway that calls the gRPC server, and returns its URL
+func startTestTracingGateway(t *testing.T, serverAddress string, tracer *tracing.Tracer) string {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	interceptor := client.NewTracingInterceptor(tracer)
+	dialOptions := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(interceptor.Unary()),
+		grpc.WithStreamInterceptor(interceptor.Stream()),
+	}
+	mux := runtime.NewServeMux()
+	err := pb.RegisterLaptopServiceHandlerFromEndpoint(ctx, mux, serverAddress, dialOptions)
+	require.NoError(t, err)
+
+	gateway := httptest.NewServer(NewTracingHandler(tracer, mux))
+	t.Cleanup(gateway.Close)
+	return gateway.URL
+}
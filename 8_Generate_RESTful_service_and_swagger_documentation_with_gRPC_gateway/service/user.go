@@ -1,8 +1,7 @@
 package service
 
 import (
-	"fmt"
-	"golang.org/x/crypto/bcrypt"
+	"crypto/subtle"
 )
 
 // User contains user's information
@@ -10,18 +9,29 @@ type User struct {
 	Username       string
 	HashedPassword string
 	Role           string
+	TenantID       string // 用户所属的租户，为空时属于默认租户
+	// TOTPSecret 在登记后、确认前就已设置，TOTPEnabled为true后登录才需要一次性验证码
+	TOTPSecret    string
+	TOTPEnabled   bool
+	LastTOTPStep  int64    // 最近一次使用的验证码的时间步长，防止同一验证码被重复使用
+	RecoveryCodes []string // 恢复码的哈希值
 }
 
-// NewUser returns a new User
+// NewUser returns a new User, its password is hashed with the default hasher
 func NewUser(username string, password string, role string) (*User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return NewUserWithHasher(username, password, role, DefaultPasswordHasher)
+}
+
+// NewUserWithHasher returns a new User, its password is hashed with the given hasher
+func NewUserWithHasher(username string, password string, role string, hasher PasswordHasher) (*User, error) {
+	hashedPassword, err := hasher.Hash(password)
 	if err != nil {
-		return nil, fmt.Errorf("cannot hash password: %w", err)
+		return nil, err
 	}
 
 	user := &User{
 		Username:       username,
-		HashedPassword: string(hashedPassword),
+		HashedPassword: hashedPassword,
 		Role:           role,
 	}
 	return user, nil
@@ -29,8 +39,19 @@ func NewUser(username string, password string, role string) (*User, error) {
 
 // IsCorrectPassword checks if the provided is correct or not
 func (user *User) IsCorrectPassword(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password))
-	return err == nil
+	return VerifyPassword(user.HashedPassword, password)
+}
+
+// UseRecoveryCode checks the recovery code and removes it from the user if it is correct
+func (user *User) UseRecoveryCode(code string) bool {
+	hashedCode := hashRecoveryCode(code)
+	for i, recoveryCode := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(recoveryCode), []byte(hashedCode)) == 1 {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
 }
 
 // Clone returns a clone of this user
@@ -39,6 +60,11 @@ func (user *User) Clone() *User {
 		Username:       user.Username,
 		HashedPassword: user.HashedPassword,
 		Role:           user.Role,
+		TenantID:       user.TenantID,
+		TOTPSecret:     user.TOTPSecret,
+		TOTPEnabled:    user.TOTPEnabled,
+		LastTOTPStep:   user.LastTOTPStep,
+		RecoveryCodes:  append([]string(nil), user.RecoveryCodes...),
 	}
 }
 
@@ -10,6 +10,10 @@ type UserStore interface {
 	Save(user *User) error
 	// Find finds a user by username
 	Find(username string) (*User, error)
+	// Update replaces an existing user in the store
+	Update(user *User) error
+	// Ready returns an error if the store cannot serve requests
+	Ready() error
 }
 
 // InMemoryUserStore store users in memory
@@ -25,6 +29,19 @@ func NewInMemoryUserStore() *InMemoryUserStore {
 	}
 }
 
+// Ready returns nil, the memory is always available
+func (store *InMemoryUserStore) Ready() error {
+	return nil
+}
+
+// Count returns the number of users
+func (store *InMemoryUserStore) Count() int {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return len(store.users)
+}
+
 func (store *InMemoryUserStore) Save(user *User) error {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
@@ -48,3 +65,15 @@ func (store *InMemoryUserStore) Find(username string) (*User, error) {
 
 	return user.Clone(), nil
 }
+
+func (store *InMemoryUserStore) Update(user *User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.users[user.Username] == nil {
+		return ErrNotFound
+	}
+
+	store.users[user.Username] = user.Clone()
+	return nil
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"pcbook/logging"
+	"pcbook/validate"
+)
+
+// ValidationInterceptor is a server interceptor that checks the request messages against the validation rules of the proto files,
+// the invalid requests are rejected with an InvalidArgument error listing all the invalid fields.
+// Each message of a client stream is checked when the handler receives it.
+type ValidationInterceptor struct{}
+
+// NewValidationInterceptor returns a new validation interceptor
+func NewValidationInterceptor() *ValidationInterceptor {
+	return &ValidationInterceptor{}
+}
+
+// Unary returns a server interceptor function to validate unary RPC
+func (interceptor *ValidationInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		err := validateRequest(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns a server interceptor function to validate stream RPC
+func (interceptor *ValidationInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		validationStream := &validationServerStream{ServerStream: stream}
+		err := handler(srv, validationStream)
+
+		// 处理函数会把接收的错误包装成Unknown，这里返回原来的InvalidArgument错误
+		if validationStream.err != nil {
+			return validationStream.err
+		}
+		return err
+	}
+}
+
+// validateRequest returns the InvalidArgument error of an invalid request, the messages that are not protobuf are not checked
+func validateRequest(ctx context.Context, req interface{}) error {
+	message, ok := req.(protoreflect.ProtoMessage)
+	if !ok {
+		return nil
+	}
+
+	err := validate.Validate(message)
+	if err == nil {
+		return nil
+	}
+	logging.FromContext(ctx).Debug("invalid request", "error", err)
+	return invalidFields(err.(*validate.Error))
+}
+
+// validationServerStream checks the messages received by the handler
+type validationServerStream struct {
+	grpc.ServerStream
+	err error
+}
+
+func (stream *validationServerStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+
+	err = validateRequest(stream.Context(), m)
+	if err != nil && stream.err == nil {
+		stream.err = err
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"net"
+	"pcbook/client"
+	"pcbook/pb"
+	"pcbook/sample"
+	"testing"
+)
+
+func TestValidationInterceptor(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	serverAddress := startTestValidationServer(t, laptopStore)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	laptop := sample.NewLaptop()
+	laptop.PriceUsd = -1
+	laptop.Cpu.MaxGhz = laptop.Cpu.MinGhz / 2
+	_, err := laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	details := client.ParseErrorDetails(err)
+	require.Equal(t, codes.InvalidArgument, details.Code)
+	require.Equal(t, pb.ErrorReason_INVALID_FIELDS, details.Reason)
+	require.Len(t, details.FieldViolations, 2)
+	require.Equal(t, "laptop.cpu.max_ghz", details.FieldViolations[0].GetField())
+	require.Equal(t, "laptop.price_usd", details.FieldViolations[1].GetField())
+	require.Equal(t, 0, laptopStore.Count())
+
+	res, err := laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+
+	// 流中的无效消息返回InvalidArgument，而不是处理函数包装后的Unknown
+	stream, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: res.Id, Score: 8}))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: res.Id, Score: 0}))
+	_, err = stream.Recv()
+	details = client.ParseErrorDetails(err)
+	require.Equal(t, codes.InvalidArgument, details.Code)
+	require.Equal(t, pb.ErrorReason_INVALID_FIELDS, details.Reason)
+	require.Equal(t, "score", details.FieldViolations[0].GetField())
+}
+
+func TestClientValidationInterceptor(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+
+	interceptor := client.NewValidationInterceptor()
+	conn, err := grpc.Dial(
+		serverAddress,
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(interceptor.Unary()),
+		grpc.WithStreamInterceptor(interceptor.Stream()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	// 没有校验拦截器的服务端也收不到无效的请求
+	laptop := sample.NewLaptop()
+	laptop.Cpu.NumberThreads = 0
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	details := client.ParseErrorDetails(err)
+	require.Equal(t, codes.InvalidArgument, details.Code)
+	require.Equal(t, pb.ErrorReason_ERROR_REASON_UNSPECIFIED, details.Reason)
+	require.Equal(t, "laptop.cpu.number_threads", details.FieldViolations[0].GetField())
+	require.Equal(t, 0, laptopStore.Count())
+
+	_, err = laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{Filter: &pb.Filter{MinCpuGhz: -1}})
+	details = client.ParseErrorDetails(err)
+	require.Equal(t, codes.InvalidArgument, details.Code)
+	require.Equal(t, "filter.min_cpu_ghz", details.FieldViolations[0].GetField())
+
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+	require.Equal(t, 1, laptopStore.Count())
+}
+
+// startTestValidationServer starts a laptop server behind the validation interceptor, and returns its address
+func startTestValidationServer(t *testing.T, laptopStore LaptopStore) string {
+	chain := NewInterceptorChain().Add(StageValidation, NewValidationInterceptor())
+	grpcServer := grpc.NewServer(chain.ServerOptions()...)
+	pb.RegisterLaptopServiceServer(grpcServer, NewLaptopServer(laptopStore, nil, NewInMemoryRatingStore()))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return listener.Addr().String()
+}
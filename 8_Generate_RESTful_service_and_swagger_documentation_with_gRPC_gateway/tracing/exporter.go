@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"pcbook/logging"
+	"sync"
+)
+
+// Exporter receives the spans when they end, it must be safe for concurrent use
+type Exporter interface {
+	ExportSpan(span *SpanData)
+}
+
+// JSONExporter writes each span as a line of JSON, to a file or stdout, so that traces work without a collector
+type JSONExporter struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewJSONExporter returns a new exporter that writes the spans to the writer
+func NewJSONExporter(writer io.Writer) *JSONExporter {
+	return &JSONExporter{
+		encoder: json.NewEncoder(writer),
+	}
+}
+
+// OpenFileExporter returns a new exporter that appends the spans to the file, which is created if needed
+func OpenFileExporter(filename string) (*JSONExporter, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter := NewJSONExporter(file)
+	exporter.closer = file
+	return exporter, nil
+}
+
+// ExportSpan writes the span, the errors are only logged because tracing must not fail the requests
+func (exporter *JSONExporter) ExportSpan(span *SpanData) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	err := exporter.encoder.Encode(span)
+	if err != nil {
+		logging.Default().Error("cannot export span", "span", span.Name, "error", err)
+	}
+}
+
+// Close closes the file of the exporter, the spans ended afterwards are lost
+func (exporter *JSONExporter) Close() error {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	if exporter.closer == nil {
+		return nil
+	}
+	return exporter.closer.Close()
+}
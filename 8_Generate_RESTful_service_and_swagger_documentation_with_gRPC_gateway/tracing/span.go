@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+// Statuses of the spans
+const (
+	StatusOK    = "OK"
+	StatusError = "ERROR"
+)
+
+// Span is a timed operation of a trace, such as an RPC or a store call.
+// All methods can be called on a nil span, which records nothing.
+type Span struct {
+	tracer       *Tracer
+	context      SpanContext
+	parentSpanID SpanID
+	name         string
+	kind         string
+	startTime    time.Time
+
+	mutex      sync.Mutex
+	attributes map[string]interface{}
+	err        string
+	ended      bool
+}
+
+// SpanData is an ended span, as written by the exporters
+type SpanData struct {
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"` // 根span为空
+	Service      string                 `json:"service"`
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	StartTime    time.Time              `json:"start_time"`
+	EndTime      time.Time              `json:"end_time"`
+	DurationMs   float64                `json:"duration_ms"`
+	Status       string                 `json:"status"`
+	Error        string                 `json:"error,omitempty"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// SpanContext returns the IDs of the span to propagate, it is invalid for a nil span
+func (span *Span) SpanContext() SpanContext {
+	if span == nil {
+		return SpanContext{}
+	}
+	return span.context
+}
+
+// SetAttribute sets an attribute of the span, such as the laptop ID or the image size
+func (span *Span) SetAttribute(key string, value interface{}) {
+	if span == nil {
+		return
+	}
+
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+
+	span.attributes[key] = value
+}
+
+// SetError marks the span as failed if the error is not nil
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+
+	span.err = err.Error()
+}
+
+// SetRPCStatus records the status code of an RPC, and marks the span as failed if the error is not nil
+func (span *Span) SetRPCStatus(err error) {
+	span.SetAttribute("rpc.grpc.status_code", status.Code(err).String())
+	span.SetError(err)
+}
+
+// End ends the span and exports it if the trace is sampled, only the first call has an effect
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+
+	endTime := time.Now()
+	span.mutex.Lock()
+	if span.ended {
+		span.mutex.Unlock()
+		return
+	}
+	span.ended = true
+	data := span.data(endTime)
+	span.mutex.Unlock()
+
+	if span.context.Sampled && span.tracer.exporter != nil {
+		span.tracer.exporter.ExportSpan(data)
+	}
+}
+
+// data returns the exported form of the span, it must be called with the mutex locked
+func (span *Span) data(endTime time.Time) *SpanData {
+	data := &SpanData{
+		TraceID:    span.context.TraceID.String(),
+		SpanID:     span.context.SpanID.String(),
+		Service:    span.tracer.serviceName,
+		Name:       span.name,
+		Kind:       span.kind,
+		StartTime:  span.startTime,
+		EndTime:    endTime,
+		DurationMs: float64(endTime.Sub(span.startTime).Microseconds()) / 1000,
+		Status:     StatusOK,
+		Error:      span.err,
+	}
+	if span.parentSpanID != (SpanID{}) {
+		data.ParentSpanID = span.parentSpanID.String()
+	}
+	if span.err != "" {
+		data.Status = StatusError
+	}
+	if len(span.attributes) > 0 {
+		data.Attributes = make(map[string]interface{}, len(span.attributes))
+		for key, value := range span.attributes {
+			data.Attributes[key] = value
+		}
+	}
+	return data
+}
@@ -0,0 +1,110 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TraceparentHeader is the W3C trace context header, it is also the key of the gRPC metadata
+const TraceparentHeader = "traceparent"
+
+// traceparentVersion is the only version of the traceparent header defined so far
+const traceparentVersion = "00"
+
+// sampledFlag is the bit of the trace flags that asks to record the trace
+const sampledFlag = 0x01
+
+// TraceID identifies a trace, all the spans of a request share it
+type TraceID [16]byte
+
+// String returns the ID in lowercase hex, as in the traceparent header
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span in a trace
+type SpanID [8]byte
+
+// String returns the ID in lowercase hex, as in the traceparent header
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span that is propagated to other processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid returns true if both IDs are set, the spec forbids IDs of all zeros
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent returns the value of the traceparent header for the span context
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return traceparentVersion + "-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses the value of a traceparent header, such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+
+	// 未来的版本可以在末尾增加字段，但不能是无效的ff版本
+	version := parts[0]
+	if len(version) != 2 || version == "ff" || (version == traceparentVersion && len(parts) != 4) {
+		return SpanContext{}, fmt.Errorf("invalid traceparent version %q", version)
+	}
+	if !isLowerHex(version) {
+		return SpanContext{}, fmt.Errorf("invalid traceparent version %q", version)
+	}
+
+	var sc SpanContext
+	err := decodeHex(sc.TraceID[:], parts[1])
+	if err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace ID: %w", err)
+	}
+	err = decodeHex(sc.SpanID[:], parts[2])
+	if err != nil {
+		return SpanContext{}, fmt.Errorf("invalid parent ID: %w", err)
+	}
+	var flags [1]byte
+	err = decodeHex(flags[:], parts[3])
+	if err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace flags: %w", err)
+	}
+	sc.Sampled = flags[0]&sampledFlag != 0
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q: IDs cannot be all zeros", value)
+	}
+	return sc, nil
+}
+
+// decodeHex decodes exactly len(dst) bytes of lowercase hex
+func decodeHex(dst []byte, text string) error {
+	if len(text) != 2*len(dst) || !isLowerHex(text) {
+		return fmt.Errorf("%q is not %d lowercase hex digits", text, 2*len(dst))
+	}
+	_, err := hex.Decode(dst, []byte(text))
+	return err
+}
+
+func isLowerHex(text string) bool {
+	for _, c := range text {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"time"
+)
+
+// Kinds of spans, as in OpenTelemetry
+const (
+	KindServer   = "server"
+	KindClient   = "client"
+	KindInternal = "internal"
+)
+
+// Tracer starts the spans of a service and sends them to the exporter.
+// A nil tracer is valid and starts no spans, so that tracing can be disabled.
+type Tracer struct {
+	serviceName string
+	exporter    Exporter
+}
+
+// NewTracer returns a new tracer whose spans are exported with the service name
+func NewTracer(serviceName string, exporter Exporter) *Tracer {
+	return &Tracer{
+		serviceName: serviceName,
+		exporter:    exporter,
+	}
+}
+
+// Start starts a span of the kind.
+// Its parent is the span in the context, or else the remote span of the context, or else it starts a new trace.
+// The returned context carries the new span, the span is nil if the tracer is nil.
+func (tracer *Tracer) Start(ctx context.Context, name string, kind string) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer:     tracer,
+		name:       name,
+		kind:       kind,
+		startTime:  time.Now(),
+		attributes: make(map[string]interface{}),
+	}
+
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.context.TraceID = parent.context.TraceID
+		span.context.Sampled = parent.context.Sampled
+		span.parentSpanID = parent.context.SpanID
+	} else if remote, ok := RemoteSpanContextFromContext(ctx); ok {
+		span.context.TraceID = remote.TraceID
+		span.context.Sampled = remote.Sampled
+		span.parentSpanID = remote.SpanID
+	} else {
+		// 没有上游时开始新的追踪，并且总是记录
+		span.context.TraceID = newTraceID()
+		span.context.Sampled = true
+	}
+	span.context.SpanID = newSpanID()
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// StartSpan starts a child of the span in the context, with the same tracer.
+// It is used around calls inside a request, such as store calls, and starts nothing when the request is not traced.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, KindInternal)
+}
+
+type spanKey struct{}
+
+type remoteSpanContextKey struct{}
+
+// ContextWithSpan returns a new context that carries the span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span in the context, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a new context that carries the span context received from another process,
+// the next span started from it is its child
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanContextKey{}, sc)
+}
+
+// RemoteSpanContextFromContext returns the span context received from another process
+func RemoteSpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(remoteSpanContextKey{}).(SpanContext)
+	return sc, ok
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		randomBytes(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		randomBytes(id[:])
+	}
+	return id
+}
+
+func randomBytes(b []byte) {
+	_, err := rand.Read(b)
+	if err != nil {
+		// crypto/rand在支持的平台上不会失败
+		panic(err)
+	}
+}
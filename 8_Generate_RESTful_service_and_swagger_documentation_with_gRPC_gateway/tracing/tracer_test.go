@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTracerSpans(t *testing.T) {
+	t.Parallel()
+
+	var buffer bytes.Buffer
+	tracer := NewTracer("test", NewJSONExporter(&buffer))
+
+	ctx, root := tracer.Start(context.Background(), "root", KindServer)
+	root.SetAttribute("rpc.method", "/test")
+	_, child := StartSpan(ctx, "child")
+	child.SetError(errors.New("store is down"))
+	child.End()
+	root.End()
+	root.End()
+
+	spans := readTestSpans(t, &buffer)
+	require.Len(t, spans, 2)
+
+	// 子span先结束
+	require.Equal(t, "child", spans[0].Name)
+	require.Equal(t, KindInternal, spans[0].Kind)
+	require.Equal(t, StatusError, spans[0].Status)
+	require.Equal(t, "store is down", spans[0].Error)
+	require.Equal(t, spans[1].TraceID, spans[0].TraceID)
+	require.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+
+	require.Equal(t, "root", spans[1].Name)
+	require.Equal(t, "test", spans[1].Service)
+	require.Equal(t, StatusOK, spans[1].Status)
+	require.Empty(t, spans[1].ParentSpanID)
+	require.Equal(t, "/test", spans[1].Attributes["rpc.method"])
+	require.False(t, spans[1].EndTime.Before(spans[1].StartTime))
+}
+
+func TestTracerRemoteParent(t *testing.T) {
+	t.Parallel()
+
+	var buffer bytes.Buffer
+	tracer := NewTracer("test", NewJSONExporter(&buffer))
+
+	sampled, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	_, span := tracer.Start(ContextWithRemoteSpanContext(context.Background(), sampled), "sampled", KindServer)
+	require.Equal(t, sampled.TraceID, span.SpanContext().TraceID)
+	require.NotEqual(t, sampled.SpanID, span.SpanContext().SpanID)
+	span.End()
+
+	// 上游决定不记录时，只传播追踪而不导出
+	notSampled := sampled
+	notSampled.Sampled = false
+	ctx, span := tracer.Start(ContextWithRemoteSpanContext(context.Background(), notSampled), "not_sampled", KindServer)
+	_, child := StartSpan(ctx, "child")
+	require.False(t, child.SpanContext().Sampled)
+	child.End()
+	span.End()
+
+	spans := readTestSpans(t, &buffer)
+	require.Len(t, spans, 1)
+	require.Equal(t, "sampled", spans[0].Name)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].TraceID)
+	require.Equal(t, "00f067aa0ba902b7", spans[0].ParentSpanID)
+}
+
+func TestNilTracer(t *testing.T) {
+	t.Parallel()
+
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "root", KindServer)
+	require.Nil(t, span)
+	require.Nil(t, SpanFromContext(ctx))
+
+	// 没有追踪的请求中，子span什么也不做
+	_, child := StartSpan(ctx, "child")
+	require.Nil(t, child)
+	child.SetAttribute("key", "value")
+	child.SetError(errors.New("error"))
+	child.End()
+	require.False(t, child.SpanContext().IsValid())
+}
+
+func readTestSpans(t *testing.T, buffer *bytes.Buffer) []*SpanData {
+	var spans []*SpanData
+	scanner := bufio.NewScanner(buffer)
+	for scanner.Scan() {
+		span := &SpanData{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), span))
+		spans = append(spans, span)
+	}
+	require.NoError(t, scanner.Err())
+	return spans
+}
@@ -0,0 +1,282 @@
+package validate
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"pcbook/pb"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error is the error of a message that breaks its validation rules, with one violation per invalid field.
+// As a gRPC status it is an InvalidArgument error with a BadRequest detail.
+type Error struct {
+	Violations []*errdetails.BadRequest_FieldViolation
+}
+
+func (err *Error) Error() string {
+	messages := make([]string, 0, len(err.Violations))
+	for _, violation := range err.Violations {
+		messages = append(messages, violation.GetField()+" "+violation.GetDescription())
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+// GRPCStatus returns the status of the error, so that the status package can convert it
+func (err *Error) GRPCStatus() *status.Status {
+	st := status.New(codes.InvalidArgument, err.Error())
+	detailed, detailsErr := st.WithDetails(&errdetails.BadRequest{FieldViolations: err.Violations})
+	if detailsErr != nil {
+		return st
+	}
+	return detailed
+}
+
+// Validate checks the message against the rules of the validate_msg.proto options, recursively.
+// The fields of the violations are paths from the message, such as "laptop.cpu.max_ghz" or "laptop.storages[1].driver".
+// It returns nil or an *Error with all the violations.
+func Validate(message proto.Message) error {
+	validator := &validator{}
+	validator.message(message.ProtoReflect(), "")
+	if len(validator.violations) == 0 {
+		return nil
+	}
+	return &Error{Violations: validator.violations}
+}
+
+type validator struct {
+	violations []*errdetails.BadRequest_FieldViolation
+}
+
+func (validator *validator) violation(field string, format string, args ...interface{}) {
+	validator.violations = append(validator.violations, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+func (validator *validator) message(message protoreflect.Message, path string) {
+	descriptor := message.Descriptor()
+
+	messageRules, _ := proto.GetExtension(descriptor.Options(), pb.E_MessageRules).(*pb.MessageRules)
+	for _, name := range messageRules.GetRequiredOneofs() {
+		oneof := descriptor.Oneofs().ByName(protoreflect.Name(name))
+		if oneof != nil && message.WhichOneof(oneof) == nil {
+			validator.violation(fieldPath(path, name), "is required")
+		}
+	}
+
+	fields := descriptor.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		path := fieldPath(path, string(field.Name()))
+		rules := fieldRules(field)
+
+		switch {
+		case field.IsList():
+			list := message.Get(field).List()
+			if rules.MinItems != nil && uint32(list.Len()) < rules.GetMinItems() {
+				validator.violation(path, "must have at least %d items", rules.GetMinItems())
+			}
+			if rules.MaxItems != nil && uint32(list.Len()) > rules.GetMaxItems() {
+				validator.violation(path, "must have at most %d items", rules.GetMaxItems())
+			}
+			for j := 0; j < list.Len(); j++ {
+				validator.value(message, field, rules, list.Get(j), fmt.Sprintf("%s[%d]", path, j))
+			}
+		case field.IsMap():
+			// 目前没有map字段，它们的规则没有定义
+		case !message.Has(field) && (field.Message() != nil || field.ContainingOneof() != nil):
+			// 未设置的消息字段和oneof字段只检查required，oneof由required_oneofs检查
+			if rules.GetRequired() {
+				validator.violation(path, "is required")
+			}
+		default:
+			validator.value(message, field, rules, message.Get(field), path)
+		}
+	}
+}
+
+// value checks a value of the field, an item of a repeated field is checked like a single value
+func (validator *validator) value(
+	message protoreflect.Message,
+	field protoreflect.FieldDescriptor,
+	rules *pb.FieldRules,
+	value protoreflect.Value,
+	path string,
+) {
+	switch field.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		validator.message(value.Message(), path)
+	case protoreflect.StringKind:
+		validator.string(rules, value.String(), path)
+	case protoreflect.BytesKind:
+		if rules.GetRequired() && len(value.Bytes()) == 0 {
+			validator.violation(path, "is required")
+		}
+	case protoreflect.EnumKind:
+		number := value.Enum()
+		if rules.GetDefinedEnum() && (number == 0 || field.Enum().Values().ByNumber(number) == nil) {
+			validator.violation(path, "must be a defined value other than %s", field.Enum().Values().ByNumber(0).Name())
+		}
+	case protoreflect.BoolKind:
+		// 布尔字段没有规则
+	default:
+		validator.number(message, rules, toFloat(value), path)
+	}
+}
+
+func (validator *validator) string(rules *pb.FieldRules, value string, path string) {
+	if rules.GetRequired() && value == "" {
+		validator.violation(path, "is required")
+		return
+	}
+	if rules.MaxLen != nil && uint32(utf8.RuneCountInString(value)) > rules.GetMaxLen() {
+		validator.violation(path, "must be at most %d characters", rules.GetMaxLen())
+	}
+	if rules.GetUuid() && value != "" {
+		if _, err := uuid.Parse(value); err != nil {
+			validator.violation(path, "must be a UUID")
+		}
+	}
+}
+
+func (validator *validator) number(message protoreflect.Message, rules *pb.FieldRules, value float64, path string) {
+	if rules.Gt != nil && !(value > rules.GetGt()) {
+		validator.violation(path, "must be greater than %v", rules.GetGt())
+	}
+	if rules.Gte != nil && !(value >= rules.GetGte()) {
+		validator.violation(path, "must be greater than or equal to %v", rules.GetGte())
+	}
+	if rules.Lte != nil && !(value <= rules.GetLte()) {
+		validator.violation(path, "must be less than or equal to %v", rules.GetLte())
+	}
+	if rules.GteField != nil {
+		other := message.Descriptor().Fields().ByName(protoreflect.Name(rules.GetGteField()))
+		// 写错的规则由CheckRules在启动时报告，这里跳过，不能因为proto文件的错误拒绝请求或使服务端崩溃
+		if other != nil && !(value >= toFloat(message.Get(other))) {
+			validator.violation(path, "must be greater than or equal to %s", other.Name())
+		}
+	}
+}
+
+// CheckRules checks the validation rules of all the registered messages, such as a gte_field that is not
+// a numeric field of the message, or a max_len on a number. A rule that cannot apply is a mistake of the proto files:
+// the programs call it at startup to fail fast, Validate skips such rules instead of failing the requests.
+func CheckRules() error {
+	var err error
+	protoregistry.GlobalFiles.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		err = checkMessages(file.Messages())
+		return err == nil
+	})
+	return err
+}
+
+func checkMessages(messages protoreflect.MessageDescriptors) error {
+	for i := 0; i < messages.Len(); i++ {
+		err := checkMessage(messages.Get(i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMessage checks the rules of the message and of its fields, then of its nested messages
+func checkMessage(descriptor protoreflect.MessageDescriptor) error {
+	messageRules, _ := proto.GetExtension(descriptor.Options(), pb.E_MessageRules).(*pb.MessageRules)
+	for _, name := range messageRules.GetRequiredOneofs() {
+		if descriptor.Oneofs().ByName(protoreflect.Name(name)) == nil {
+			return fmt.Errorf("%s: required_oneofs %q is not a oneof of the message", descriptor.FullName(), name)
+		}
+	}
+
+	fields := descriptor.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		err := checkFieldRules(descriptor, field, fieldRules(field))
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.FullName(), err)
+		}
+	}
+
+	return checkMessages(descriptor.Messages())
+}
+
+func checkFieldRules(descriptor protoreflect.MessageDescriptor, field protoreflect.FieldDescriptor, rules *pb.FieldRules) error {
+	if (rules.MinItems != nil || rules.MaxItems != nil) && !field.IsList() {
+		return fmt.Errorf("min_items and max_items only apply to repeated fields")
+	}
+	if (rules.Gt != nil || rules.Gte != nil || rules.Lte != nil || rules.GteField != nil) && !isNumber(field) {
+		return fmt.Errorf("gt, gte, lte and gte_field only apply to numeric fields")
+	}
+	if (rules.Uuid != nil || rules.MaxLen != nil) && field.Kind() != protoreflect.StringKind {
+		return fmt.Errorf("uuid and max_len only apply to string fields")
+	}
+	if rules.DefinedEnum != nil && field.Kind() != protoreflect.EnumKind {
+		return fmt.Errorf("defined_enum only applies to enum fields")
+	}
+
+	if rules.GteField != nil {
+		other := descriptor.Fields().ByName(protoreflect.Name(rules.GetGteField()))
+		if other == nil || other.IsList() || other.IsMap() || !isNumber(other) {
+			return fmt.Errorf("gte_field %q is not a numeric field of %s", rules.GetGteField(), descriptor.FullName())
+		}
+	}
+	return nil
+}
+
+// isNumber checks if the rules of the field are checked by number
+func isNumber(field protoreflect.FieldDescriptor) bool {
+	if field.IsMap() {
+		return false
+	}
+
+	switch field.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind, protoreflect.StringKind,
+		protoreflect.BytesKind, protoreflect.EnumKind, protoreflect.BoolKind:
+		return false
+	}
+	return true
+}
+
+// fieldRules returns the rules of the field, the rules are empty if the field has no (rules) option
+func fieldRules(field protoreflect.FieldDescriptor) *pb.FieldRules {
+	rules, _ := proto.GetExtension(field.Options(), pb.E_Rules).(*pb.FieldRules)
+	if rules == nil {
+		return &pb.FieldRules{}
+	}
+	return rules
+}
+
+// toFloat returns a numeric value as float64, the rules of all the numeric kinds are float64
+func toFloat(value protoreflect.Value) float64 {
+	switch v := value.Interface().(type) {
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+func fieldPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,215 @@
+package validate
+
+import (
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"pcbook/pb"
+	"pcbook/sample"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		message func() proto.Message
+		// fields are the fields of the violations, none for a valid message
+		fields []string
+	}{
+		{
+			name:    "valid_laptop",
+			message: func() proto.Message { return &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()} },
+		},
+		{
+			name: "negative_price",
+			message: func() proto.Message {
+				laptop := sample.NewLaptop()
+				laptop.PriceUsd = -1
+				return &pb.CreateLaptopRequest{Laptop: laptop}
+			},
+			fields: []string{"laptop.price_usd"},
+		},
+		{
+			name: "cpu",
+			message: func() proto.Message {
+				laptop := sample.NewLaptop()
+				laptop.Cpu.NumberCores = 0
+				laptop.Cpu.MinGhz = 3.5
+				laptop.Cpu.MaxGhz = 2.5
+				return &pb.CreateLaptopRequest{Laptop: laptop}
+			},
+			fields: []string{"laptop.cpu.number_cores", "laptop.cpu.max_ghz"},
+		},
+		{
+			name: "fewer_threads_than_cores",
+			message: func() proto.Message {
+				laptop := sample.NewLaptop()
+				laptop.Cpu.NumberThreads = laptop.Cpu.NumberCores - 1
+				return &pb.CreateLaptopRequest{Laptop: laptop}
+			},
+			fields: []string{"laptop.cpu.number_threads"},
+		},
+		{
+			name: "unknown_memory_unit",
+			message: func() proto.Message {
+				laptop := sample.NewLaptop()
+				laptop.Storages[1].Memory.Unit = pb.Memory_UNKNOWN
+				laptop.Storages[1].Driver = pb.Storage_Driver(42)
+				return &pb.CreateLaptopRequest{Laptop: laptop}
+			},
+			fields: []string{"laptop.storages[1].driver", "laptop.storages[1].memory.unit"},
+		},
+		{
+			name: "missing_fields",
+			message: func() proto.Message {
+				return &pb.CreateLaptopRequest{Laptop: &pb.Laptop{Brand: "Apple", Name: "Macbook Pro", ReleaseYear: 2020}}
+			},
+			fields: []string{"laptop.weight", "laptop.cpu", "laptop.ram", "laptop.storages", "laptop.screen", "laptop.keyboard"},
+		},
+		{
+			name:    "missing_laptop",
+			message: func() proto.Message { return &pb.CreateLaptopRequest{} },
+			fields:  []string{"laptop"},
+		},
+		{
+			name: "invalid_filter",
+			message: func() proto.Message {
+				return &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: -5, MinRam: &pb.Memory{Value: 8}}}
+			},
+			fields: []string{"filter.max_price_usd", "filter.min_ram.unit"},
+		},
+		{
+			name:    "empty_filter",
+			message: func() proto.Message { return &pb.SearchLaptopRequest{} },
+		},
+		{
+			name:    "invalid_uuid",
+			message: func() proto.Message { return &pb.DeleteLaptopRequest{Id: "invalid_uuid"} },
+			fields:  []string{"id"},
+		},
+		{
+			name:    "score_out_of_range",
+			message: func() proto.Message { return &pb.RateLaptopRequest{LaptopId: sample.NewLaptop().Id, Score: 11} },
+			fields:  []string{"score"},
+		},
+		{
+			name:    "missing_image_data",
+			message: func() proto.Message { return &pb.UploadImageRequest{} },
+			fields:  []string{"data"},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := Validate(tc.message())
+			if len(tc.fields) == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			require.IsType(t, &Error{}, err)
+			fields := []string{}
+			for _, violation := range err.(*Error).Violations {
+				require.NotEmpty(t, violation.GetDescription())
+				fields = append(fields, violation.GetField())
+			}
+			require.Equal(t, tc.fields, fields)
+		})
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	laptop.PriceUsd = -1
+	err := Validate(&pb.CreateLaptopRequest{Laptop: laptop})
+
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Equal(t, "invalid request: laptop.price_usd must be greater than or equal to 0", st.Message())
+	require.Len(t, st.Details(), 1)
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	require.Equal(t, "laptop.price_usd", badRequest.GetFieldViolations()[0].GetField())
+}
+
+func TestCheckRules(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, CheckRules())
+
+	testCases := []struct {
+		name  string
+		rules *pb.FieldRules
+	}{
+		{"unknown_gte_field", &pb.FieldRules{GteField: proto.String("minimum")}},
+		{"non_numeric_gte_field", &pb.FieldRules{GteField: proto.String("name")}},
+		{"max_len_on_number", &pb.FieldRules{MaxLen: proto.Uint32(10)}},
+		{"min_items_on_single_field", &pb.FieldRules{MinItems: proto.Uint32(1)}},
+		{"defined_enum_on_number", &pb.FieldRules{DefinedEnum: proto.Bool(true)}},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			descriptor := newTestRangeDescriptor(t, tc.rules)
+			require.Error(t, checkMessage(descriptor))
+
+			// 写错的规则被跳过，请求不会因此失败或使服务端崩溃
+			message := dynamicpb.NewMessage(descriptor)
+			message.Set(descriptor.Fields().ByName("max"), protoreflect.ValueOfFloat64(1))
+			require.NotPanics(t, func() { require.NoError(t, Validate(message)) })
+		})
+	}
+
+	descriptor := newTestRangeDescriptor(t, &pb.FieldRules{GteField: proto.String("min")})
+	require.NoError(t, checkMessage(descriptor))
+}
+
+// newTestRangeDescriptor returns the descriptor of a message with the fields name, min and max, the rules are those of max
+func newTestRangeDescriptor(t *testing.T, rules *pb.FieldRules) protoreflect.MessageDescriptor {
+	options := &descriptorpb.FieldOptions{}
+	proto.SetExtension(options, pb.E_Rules, rules)
+
+	field := func(name string, number int32, fieldType descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     fieldType.Enum(),
+		}
+	}
+	max := field("max", 3, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE)
+	max.Options = options
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("validate_test.proto"),
+		Package: proto.String("pcbook.test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Range"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("min", 2, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE),
+				max,
+			},
+		}},
+	}, nil)
+	require.NoError(t, err)
+	return file.Messages().Get(0)
+}